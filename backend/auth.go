@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// apiScope is what an API key may do. Scopes are ordered: each allows everything the
+// ones before it do.
+type apiScope int
+
+const (
+	// scopeRead allows downloads, exports and the read-only data endpoints
+	scopeRead apiScope = iota
+	// scopeAdmin additionally allows cache control, /stats, /lookup and /metrics
+	scopeAdmin
+)
+
+func (s apiScope) String() string {
+	if s == scopeAdmin {
+		return "admin"
+	}
+	return "read"
+}
+
+// allows reports whether a key with scope s may access a route requiring required
+func (s apiScope) allows(required apiScope) bool {
+	return s >= required
+}
+
+// apiKeyEntry is an accepted API key, the identity it belongs to, and its scope
+type apiKeyEntry struct {
+	Name  string
+	Key   string
+	Scope apiScope
+}
+
+// scopedKeysFrom returns the keys configured by READ_API_KEY and ADMIN_API_KEY
+func scopedKeysFrom(readKey, adminKey string) ([]apiKeyEntry, error) {
+	if readKey != "" && readKey == adminKey {
+		return nil, fmt.Errorf("READ_API_KEY and ADMIN_API_KEY must be different")
+	}
+	var keys []apiKeyEntry
+	if readKey != "" {
+		keys = append(keys, apiKeyEntry{Name: "read", Key: readKey, Scope: scopeRead})
+	}
+	if adminKey != "" {
+		keys = append(keys, apiKeyEntry{Name: "admin", Key: adminKey, Scope: scopeAdmin})
+	}
+	return keys, nil
+}
+
+// parseAPIKeys parses API_KEYS: comma-separated keys, each optionally written as name:key.
+// Unnamed keys are called key-1, key-2, ... by position.
+func parseAPIKeys(value string) ([]apiKeyEntry, error) {
+	var keys []apiKeyEntry
+	for i, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		entry := apiKeyEntry{Name: fmt.Sprintf("key-%d", i+1), Key: raw, Scope: scopeAdmin}
+		if name, key, ok := strings.Cut(raw, ":"); ok {
+			entry = apiKeyEntry{Name: strings.TrimSpace(name), Key: strings.TrimSpace(key), Scope: scopeAdmin}
+		}
+		if entry.Name == "" || entry.Key == "" {
+			return nil, fmt.Errorf("invalid API_KEYS entry #%d: expected key or name:key", i+1)
+		}
+		keys = append(keys, entry)
+	}
+	return keys, nil
+}
+
+// loadAPIKeysFile reads named keys from a JSON object mapping identity names to keys,
+// e.g. {"analytics-team": "abc123", "homepage": "def456"}
+func loadAPIKeysFile(path string) ([]apiKeyEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading API keys file: %w", err)
+	}
+
+	var named map[string]string
+	if err := json.Unmarshal(data, &named); err != nil {
+		return nil, fmt.Errorf("parsing API keys file: %w", err)
+	}
+
+	keys := make([]apiKeyEntry, 0, len(named))
+	for name, key := range named {
+		if name == "" || key == "" {
+			return nil, fmt.Errorf("API keys file contains an empty name or key")
+		}
+		keys = append(keys, apiKeyEntry{Name: name, Key: key, Scope: scopeAdmin})
+	}
+	return keys, nil
+}
+
+// matchAPIKey returns the entry in c.APIKeys owning the provided key. Every key is compared
+// in constant time, without stopping at the first match, so response timing doesn't
+// reveal which (or how many) keys exist.
+func (c *Config) matchAPIKey(provided string) (apiKeyEntry, bool) {
+	var matched apiKeyEntry
+	found := 0
+	for _, entry := range c.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(entry.Key)) == 1 {
+			if found == 0 {
+				matched = entry
+			}
+			found = 1
+		}
+	}
+	return matched, found == 1
+}
+
+type apiScopeKey struct{}
+
+// scopeFrom returns the scope authMiddleware granted the request, if any
+func scopeFrom(r *http.Request) (apiScope, bool) {
+	scope, ok := r.Context().Value(apiScopeKey{}).(apiScope)
+	return scope, ok
+}
+
+// requireScope only lets requests through to next if their key has at least the given
+// scope. It must sit behind authMiddleware.
+func requireScope(required apiScope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if scope, ok := scopeFrom(r); !ok || !scope.allows(required) {
+			requestLog(r).Warn("Auth failed: %s scope required for %s", required, r.URL.Path)
+			writeJSONError(w, http.StatusForbidden, fmt.Sprintf("Forbidden: this API key lacks the %s scope", required))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestInfo carries per-request details from inner handlers back out to loggingMiddleware
+type requestInfo struct {
+	id       string
+	identity string
+	clientIP net.IP // resolved once by loggingMiddleware; see clientIP
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo attaches a fresh requestInfo to the request context
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	info := &requestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// requestInfoFrom returns the request's requestInfo, or nil if none was attached
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestLog returns a logger that tags every line with the request's ID, falling
+// back to the application logger outside of loggingMiddleware
+func requestLog(r *http.Request) *Logger {
+	if info := requestInfoFrom(r); info != nil && info.id != "" {
+		return &Logger{prefix: fmt.Sprintf("[%s] ", info.id)}
+	}
+	return appLog
+}
+
+// extractAPIKey returns the key presented via the Authorization or X-API-Key header,
+// along with the method used to provide it
+func extractAPIKey(r *http.Request) (string, string) {
+	authHeader := r.Header.Get("Authorization")
+	apiKeyHeader := r.Header.Get("X-API-Key")
+
+	if authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
+			return parts[1], "Bearer"
+		}
+		return authHeader, "Authorization"
+	}
+	if apiKeyHeader != "" {
+		return apiKeyHeader, "X-API-Key"
+	}
+	return "", ""
+}
+
+// authMiddleware only lets requests through to next with one of cfg's API keys, recording
+// the key's identity and scope on the request
+func authMiddleware(cfg *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		providedKey, authMethod := extractAPIKey(r)
+
+		if providedKey == "" {
+			requestLog(r).Warn("Auth failed: no API key provided")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="API"`)
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized: API key is required")
+			return
+		}
+
+		entry, ok := cfg.matchAPIKey(providedKey)
+		if !ok {
+			requestLog(r).Warn("Auth failed: invalid API key (method: %s)", authMethod)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="API"`)
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized: API key is required")
+			return
+		}
+
+		// Let the logging middleware report who made the request
+		if info := requestInfoFrom(r); info != nil {
+			info.identity = entry.Name
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiScopeKey{}, entry.Scope)))
+	})
+}
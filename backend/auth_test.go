@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys("analytics:abc123, def456 ,homepage:ghi789")
+	if err != nil {
+		t.Fatalf("parseAPIKeys() error: %v", err)
+	}
+	expected := []apiKeyEntry{
+		{Name: "analytics", Key: "abc123", Scope: scopeAdmin},
+		{Name: "key-2", Key: "def456", Scope: scopeAdmin},
+		{Name: "homepage", Key: "ghi789", Scope: scopeAdmin},
+	}
+	if len(keys) != len(expected) {
+		t.Fatalf("parseAPIKeys() = %v, want %v", keys, expected)
+	}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Errorf("key %d = %+v, want %+v", i, keys[i], expected[i])
+		}
+	}
+
+	if _, err := parseAPIKeys("name-only:"); err == nil {
+		t.Error("parseAPIKeys() expected error for an entry with an empty key")
+	}
+}
+
+func TestLoadAPIKeysFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`{"analytics": "abc123"}`), 0o600); err != nil {
+		t.Fatalf("writing keys file: %v", err)
+	}
+
+	keys, err := loadAPIKeysFile(path)
+	if err != nil {
+		t.Fatalf("loadAPIKeysFile() error: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != (apiKeyEntry{Name: "analytics", Key: "abc123", Scope: scopeAdmin}) {
+		t.Errorf("loadAPIKeysFile() = %v", keys)
+	}
+}
+
+func TestAuthMiddlewareRecordsIdentity(t *testing.T) {
+	cfg := &Config{APIKeys: []apiKeyEntry{{Name: "default", Key: "main-key"}, {Name: "analytics", Key: "team-key"}}}
+
+	handler := authMiddleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("Authorization", "Bearer team-key")
+	req, info := withRequestInfo(req)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if info.identity != "analytics" {
+		t.Errorf("identity = %q, want analytics", info.identity)
+	}
+
+	req = httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("X-API-Key", "revoked-key")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status for unknown key = %d, want 401", rec.Code)
+	}
+}
+
+func TestScopedKeysFrom(t *testing.T) {
+	keys, err := scopedKeysFrom("reader", "boss")
+	if err != nil {
+		t.Fatalf("scopedKeysFrom() error: %v", err)
+	}
+	want := []apiKeyEntry{{Name: "read", Key: "reader", Scope: scopeRead}, {Name: "admin", Key: "boss", Scope: scopeAdmin}}
+	if len(keys) != 2 || keys[0] != want[0] || keys[1] != want[1] {
+		t.Errorf("scopedKeysFrom() = %v, want %v", keys, want)
+	}
+
+	if keys, _ := scopedKeysFrom("", ""); len(keys) != 0 {
+		t.Errorf("scopedKeysFrom() with nothing set = %v, want none", keys)
+	}
+	if _, err := scopedKeysFrom("same", "same"); err == nil {
+		t.Error("scopedKeysFrom() accepted identical read and admin keys")
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	cfg := &Config{APIKeys: []apiKeyEntry{{Name: "read", Key: "read-key", Scope: scopeRead}, {Name: "admin", Key: "admin-key", Scope: scopeAdmin}}}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux := http.NewServeMux()
+	mux.Handle("/db", ok)
+	mux.Handle("/cache/invalidate", requireScope(scopeAdmin, ok))
+	handler := authMiddleware(cfg, mux)
+
+	tests := []struct {
+		key, path string
+		want      int
+	}{
+		{"read-key", "/db", http.StatusOK},
+		{"read-key", "/cache/invalidate", http.StatusForbidden},
+		{"admin-key", "/db", http.StatusOK},
+		{"admin-key", "/cache/invalidate", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, nil)
+		req.Header.Set("X-API-Key", tt.key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s with %s: status = %d, want %d", tt.path, tt.key, rec.Code, tt.want)
+		}
+	}
+}
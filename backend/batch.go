@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// batchSize is how many rows the copy functions insert per SQLite transaction (BATCH_SIZE).
+// Committing periodically keeps the journal, and the memory behind it, bounded on large tables.
+var batchSize = 10000
+
+// batchInserter inserts rows into one table, and optionally its search table, committing
+// every batchSize rows. The statements are prepared again for each new transaction.
+type batchInserter struct {
+	ctx       context.Context
+	db        *sql.DB
+	insertSQL string
+	ftsSQL    string // empty if the table has no search table
+
+	tx      *sql.Tx
+	stmt    *sql.Stmt
+	ftsStmt *sql.Stmt // nil without FTS5 or a search table
+	pending int
+}
+
+// newBatchInserter begins the first transaction and prepares its statements
+func newBatchInserter(ctx context.Context, db *sql.DB, insertSQL, ftsSQL string) (*batchInserter, error) {
+	b := &batchInserter{ctx: ctx, db: db, insertSQL: insertSQL, ftsSQL: ftsSQL}
+	if err := b.begin(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *batchInserter) begin() error {
+	tx, err := b.db.BeginTx(b.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+
+	stmt, err := tx.Prepare(b.insertSQL)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("preparing insert statement: %w", err)
+	}
+
+	var ftsStmt *sql.Stmt
+	if b.ftsSQL != "" {
+		if ftsStmt, err = prepareFTSInsert(tx, b.ftsSQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("preparing search insert statement: %w", err)
+		}
+	}
+
+	b.tx, b.stmt, b.ftsStmt, b.pending = tx, stmt, ftsStmt, 0
+	return nil
+}
+
+// insert adds one row, plus its search row if there's a search table, and starts a new
+// transaction once the current one holds batchSize rows
+func (b *batchInserter) insert(values []interface{}, ftsValues ...interface{}) error {
+	if _, err := b.stmt.Exec(values...); err != nil {
+		return fmt.Errorf("inserting row: %w", err)
+	}
+	if b.ftsStmt != nil {
+		if _, err := b.ftsStmt.Exec(ftsValues...); err != nil {
+			return fmt.Errorf("inserting search row: %w", err)
+		}
+	}
+
+	b.pending++
+	if b.pending < batchSize {
+		return nil
+	}
+	if err := b.commit(); err != nil {
+		return err
+	}
+	return b.begin()
+}
+
+// commit commits the current transaction. Committing (or rolling back) a transaction
+// also closes the statements prepared on it.
+func (b *batchInserter) commit() error {
+	tx := b.tx
+	b.tx = nil
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// rollback discards the uncommitted rows, if any; it's safe to defer after commit.
+// Batches already committed stay, so callers discard the whole file on failure.
+func (b *batchInserter) rollback() {
+	if b.tx != nil {
+		b.tx.Rollback()
+		b.tx = nil
+	}
+}
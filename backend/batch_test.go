@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func withBatchSize(t *testing.T, n int) {
+	t.Helper()
+	previous := batchSize
+	batchSize = n
+	t.Cleanup(func() { batchSize = previous })
+}
+
+func TestBatchInserterCommitsEveryBatch(t *testing.T) {
+	withBatchSize(t, 2)
+	db := openTestSQLite(t)
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+
+	inserter, err := newBatchInserter(context.Background(), db,
+		`INSERT INTO approved_projects (record_id) VALUES (?)`,
+		`INSERT INTO projects_fts (record_id, first_name) VALUES (?, ?)`)
+	if err != nil {
+		t.Fatalf("newBatchInserter() error: %v", err)
+	}
+	defer inserter.rollback()
+
+	for _, id := range []string{"rec1", "rec2", "rec3", "rec4", "rec5"} {
+		if err := inserter.insert([]interface{}{id}, id, "Ada"); err != nil {
+			t.Fatalf("insert(%s) error: %v", id, err)
+		}
+	}
+	if err := inserter.commit(); err != nil {
+		t.Fatalf("commit() error: %v", err)
+	}
+
+	if n, err := countRows(db, "approved_projects"); err != nil || n != 5 {
+		t.Errorf("approved_projects has %d rows (%v), want 5", n, err)
+	}
+	if ftsAvailable {
+		if n, err := countRows(db, "projects_fts"); err != nil || n != 5 {
+			t.Errorf("projects_fts has %d rows (%v), want 5", n, err)
+		}
+	}
+}
+
+func TestBatchInserterRollsBackFailedBatch(t *testing.T) {
+	withBatchSize(t, 2)
+	db := openTestSQLite(t)
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+
+	inserter, err := newBatchInserter(context.Background(), db, `INSERT INTO approved_projects (record_id) VALUES (?)`, "")
+	if err != nil {
+		t.Fatalf("newBatchInserter() error: %v", err)
+	}
+	for _, id := range []string{"rec1", "rec2", "rec3"} {
+		if err := inserter.insert([]interface{}{id}); err != nil {
+			t.Fatalf("insert(%s) error: %v", id, err)
+		}
+	}
+	// A duplicate key fails the second batch; its earlier row is rolled back with it
+	if err := inserter.insert([]interface{}{"rec1"}); err == nil {
+		t.Fatal("insert() of a duplicate key succeeded")
+	}
+	inserter.rollback()
+
+	if n, err := countRows(db, "approved_projects"); err != nil || n != 2 {
+		t.Errorf("approved_projects has %d rows (%v), want the 2 from the committed batch", n, err)
+	}
+}
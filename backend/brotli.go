@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// brotliQuality is the Brotli quality (0-11) the .br files are built at (BROTLI_QUALITY).
+// Each is built once per database and then served many times, so it leans towards size;
+// 11 is noticeably smaller again but takes minutes on a large database.
+var brotliQuality = 9
+
+// brotliFiles holds the .br files built from the full database and from subsets, for
+// requests with Accept-Encoding: br
+var brotliFiles = newBrotliCache()
+
+// buildBrotli is buildBrotliFile; swapped out in tests
+var buildBrotli = buildBrotliFile
+
+func newBrotliCache() *derivedCache {
+	return newDerivedCache("Brotli", func(log *Logger, source string) (string, error) { return buildBrotli(log, source) })
+}
+
+// buildBrotliFile decompresses the zstd database at source and writes it Brotli-encoded
+// next to it, with .br in place of .zst so cache cleanup treats both alike, returning
+// that path
+func buildBrotliFile(log *Logger, source string) (string, error) {
+	start := time.Now()
+	path, err := buildDerivedFile(source, ".br", func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(w, brotliQuality), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		log.Info("Built Brotli copy of the database: %.2f MB (quality %d) in %s",
+			float64(info.Size())/(1024*1024), brotliQuality, time.Since(start))
+	}
+	return path, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// withBrotliFiles gives the test its own cache of .br files
+func withBrotliFiles(t *testing.T) {
+	t.Helper()
+	prev := brotliFiles
+	brotliFiles = newBrotliCache()
+	t.Cleanup(func() { brotliFiles = prev })
+}
+
+func TestDBHandlerServesBrotliFromACachedFile(t *testing.T) {
+	withBrotliFiles(t)
+	contents := bytes.Repeat([]byte("SQLite format 3\x00 pretend database page "), 1000)
+	withCachedDatabase(t, contents)
+	entry, _ := fullCache.Get()
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/db", nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+		rec := httptest.NewRecorder()
+		dbHandler(testConfig)(rec, req)
+		return rec
+	}
+
+	rec := request()
+	if rec.Code != 200 || rec.Header().Get("Content-Encoding") != "br" || rec.Header().Get("Content-Type") != "application/vnd.sqlite3" {
+		t.Fatalf("status = %d, Content-Encoding %q, Content-Type %q; want 200 br SQLite",
+			rec.Code, rec.Header().Get("Content-Encoding"), rec.Header().Get("Content-Type"))
+	}
+	if vary := rec.Header().Values("Vary"); len(vary) == 0 || !bytes.Contains([]byte(vary[0]), []byte("Accept-Encoding")) {
+		t.Errorf("Vary = %q, want Accept-Encoding", vary)
+	}
+	decoded, err := io.ReadAll(brotli.NewReader(rec.Body))
+	if err != nil || !bytes.Equal(decoded, contents) {
+		t.Fatalf("decoded %d bytes (%v), want the %d-byte database", len(decoded), err, len(contents))
+	}
+
+	// The second request reuses the file built for the first
+	path, ok := brotliFiles.Lookup(entry.path)
+	if !ok {
+		t.Fatal("no .br file cached after the first request")
+	}
+	before, _ := os.Stat(path)
+	rec = request()
+	after, _ := os.Stat(path)
+	if rec.Code != 200 || !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("second request rebuilt the .br file or failed (status %d)", rec.Code)
+	}
+	if rec.Body.Len() != int(after.Size()) {
+		t.Errorf("second response sent %d bytes, want the %d-byte .br file", rec.Body.Len(), after.Size())
+	}
+}
+
+func TestBrotliFileIsRemovedWithItsSource(t *testing.T) {
+	withBrotliFiles(t)
+	withFileRemovalGrace(t, 0)
+	withCachedDatabase(t, []byte("SQLite format 3\x00 small database"))
+	entry, _ := fullCache.Get()
+
+	path, err := brotliFiles.Get(appLog, entry.path)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	brotliFiles.Remove(entry.path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf(".br file still exists after Remove (%v)", err)
+	}
+	if _, ok := brotliFiles.Lookup(entry.path); ok {
+		t.Error("Lookup() still finds the removed file")
+	}
+}
+
+func TestBrotliOpenRebuildsAFileRemovedAfterLookup(t *testing.T) {
+	withBrotliFiles(t)
+	withCachedDatabase(t, []byte("SQLite format 3\x00 small database"))
+	entry, _ := fullCache.Get()
+
+	path, err := brotliFiles.Get(appLog, entry.path)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	// Deleted behind the cache's back, e.g. by a cleanup, after the lookup
+	os.Remove(path)
+
+	file, err := brotliFiles.Open(appLog, entry.path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer file.Close()
+	decoded, err := io.ReadAll(brotli.NewReader(file))
+	if err != nil || string(decoded) != "SQLite format 3\x00 small database" {
+		t.Errorf("Open() read %q (%v), want the database", decoded, err)
+	}
+}
+
+func TestBrotliBuildOfADroppedSourceIsNotKept(t *testing.T) {
+	withBrotliFiles(t)
+	withFileRemovalGrace(t, time.Hour)
+	t.Cleanup(removePendingFiles)
+	withCachedDatabase(t, []byte("SQLite format 3\x00 small database"))
+	entry, _ := fullCache.Get()
+
+	prevBuild := buildBrotli
+	t.Cleanup(func() { buildBrotli = prevBuild })
+	buildBrotli = func(log *Logger, source string) (string, error) {
+		// The database is replaced while its Brotli copy is being built
+		brotliFiles.Remove(source)
+		return buildBrotliFile(log, source)
+	}
+
+	path, err := brotliFiles.Get(appLog, entry.path)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the request's own build was removed before it could be sent: %v", err)
+	}
+	if _, ok := brotliFiles.Lookup(entry.path); ok {
+		t.Error("the build of a dropped source was kept")
+	}
+}
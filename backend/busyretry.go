@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteBusyRetries is how many more times a read of the query database is tried after
+// SQLite reports it busy or locked (SQLITE_BUSY_RETRIES). The file can be briefly
+// contended while it's being replaced, which shouldn't surface to clients as a 500.
+var sqliteBusyRetries = 3
+
+// sqliteBusyDelay is the wait before the first retry; it doubles after each one
+const sqliteBusyDelay = 50 * time.Millisecond
+
+// busySleep is time.Sleep; swapped out in tests
+var busySleep = time.Sleep
+
+// isSQLiteBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED, including their
+// extended codes
+func isSQLiteBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// retryIfBusy runs read, running it again with backoff while it fails because the
+// database is busy, up to sqliteBusyRetries times, logging each retry to log. Other errors
+// are returned at once.
+func retryIfBusy(log *Logger, read func() error) error {
+	delay := sqliteBusyDelay
+	err := read()
+	for retry := 1; retry <= sqliteBusyRetries && isSQLiteBusy(err); retry++ {
+		log.Warn("SQLite busy (retry %d/%d in %s): %v", retry, sqliteBusyRetries, delay, err)
+		busySleep(delay)
+		delay *= 2
+		err = read()
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// lockedDatabaseError returns a real SQLITE_BUSY error, from writing to a database another
+// connection holds an exclusive lock on
+func lockedDatabaseError(t *testing.T) error {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "locked.db")
+	holder, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { holder.Close() })
+	holder.SetMaxOpenConns(1)
+	if _, err := holder.Exec(`CREATE TABLE t (x); PRAGMA locking_mode = EXCLUSIVE; BEGIN EXCLUSIVE`); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Close() })
+	_, err = other.Exec(`INSERT INTO t VALUES (1)`)
+	if err == nil {
+		t.Fatal("write to a locked database succeeded")
+	}
+	return fmt.Errorf("querying t: %w", err)
+}
+
+func withBusyRetries(t *testing.T, retries int) *[]time.Duration {
+	t.Helper()
+	prevRetries, prevSleep := sqliteBusyRetries, busySleep
+	var sleeps []time.Duration
+	sqliteBusyRetries = retries
+	busySleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	t.Cleanup(func() { sqliteBusyRetries, busySleep = prevRetries, prevSleep })
+	return &sleeps
+}
+
+func TestIsSQLiteBusy(t *testing.T) {
+	if err := lockedDatabaseError(t); !isSQLiteBusy(err) {
+		t.Errorf("isSQLiteBusy(%v) = false, want true", err)
+	}
+	for _, err := range []error{nil, errors.New("database is locked"), sql.ErrNoRows} {
+		if isSQLiteBusy(err) {
+			t.Errorf("isSQLiteBusy(%v) = true, want false", err)
+		}
+	}
+}
+
+func TestRetryIfBusy(t *testing.T) {
+	busy := lockedDatabaseError(t)
+	sleeps := withBusyRetries(t, 3)
+
+	calls := 0
+	err := retryIfBusy(appLog, func() error {
+		calls++
+		if calls < 3 {
+			return busy
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retryIfBusy() = %v after %d calls, want success on the 3rd", err, calls)
+	}
+	if want := []time.Duration{sqliteBusyDelay, 2 * sqliteBusyDelay}; fmt.Sprint(*sleeps) != fmt.Sprint(want) {
+		t.Errorf("slept %v, want %v", *sleeps, want)
+	}
+
+	calls = 0
+	if err := retryIfBusy(appLog, func() error { calls++; return busy }); !errors.Is(err, busy) || calls != 4 {
+		t.Errorf("retryIfBusy() = %v after %d calls, want the busy error after 4", err, calls)
+	}
+
+	calls = 0
+	other := errors.New("no such table")
+	if err := retryIfBusy(appLog, func() error { calls++; return other }); err != other || calls != 1 {
+		t.Errorf("retryIfBusy() = %v after %d calls, want the error at once", err, calls)
+	}
+}
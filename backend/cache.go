@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// cacheEntry describes one generated, zstd-compressed database file and what was
+// recorded about it at generation time
+type cacheEntry struct {
+	path             string
+	createdAt        time.Time
+	etag             string // identifies the file's contents; see fileETag
+	compressedSize   int64
+	uncompressedSize int64
+	compressionRatio float64
+	schemaHash       string
+	sha256           string // hex SHA-256 of the compressed file
+	projectCount     int
+	mentionCount     int
+	dataModifiedAt   time.Time // newest timestamp in the data; see sqliteBuild
+	dataVersion      string    // Postgres data version it was built from; see queryDataVersion
+	emailHashes      emailHashMode
+}
+
+// age returns how long ago the entry was generated
+func (e cacheEntry) age() time.Duration {
+	return time.Since(e.createdAt)
+}
+
+// dbCache holds the current cache entry behind a single mutex. Get returns a copy, so
+// callers never hold the lock while serving a file.
+type dbCache struct {
+	mu    sync.RWMutex
+	entry cacheEntry
+	// ready is set the first time an entry is stored, so /ready can tell a cold
+	// instance from one whose cache merely expired
+	ready bool
+}
+
+// fullCache is the cache for the complete database served by /db
+var fullCache = &dbCache{}
+
+// Get returns the current entry, or false if nothing is cached
+func (c *dbCache) Get() (cacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.entry, c.entry.path != ""
+}
+
+// Lookup returns the current entry if it's the one stored at path
+func (c *dbCache) Lookup(path string) (cacheEntry, bool) {
+	entry, ok := c.Get()
+	if !ok || entry.path != path {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Set replaces the current entry, returning the one it replaced
+func (c *dbCache) Set(entry cacheEntry) cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := c.entry
+	c.entry = entry
+	c.ready = true
+	return previous
+}
+
+// Clear empties the cache, returning the entry that was removed
+func (c *dbCache) Clear() cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := c.entry
+	c.entry = cacheEntry{}
+	return previous
+}
+
+// Ready reports whether an entry has ever been stored
+func (c *dbCache) Ready() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ready
+}
+
+// fileETag identifies a cache file's contents. Cache files are never modified once
+// written, so their modification time and size are enough.
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+}
+
+// invalidateResponse is the body returned by POST /cache/invalidate
+type invalidateResponse struct {
+	Invalidated        bool     `json:"invalidated"`
+	PreviousAgeSeconds *float64 `json:"previous_age_seconds,omitempty"`
+}
+
+// invalidateCache drops the cached database and every cached subset so the next request
+// regenerates from Postgres. It waits for an in-flight generation, since that may have
+// read the data from before the change that prompted the invalidation.
+func invalidateCache() (cacheEntry, bool) {
+	generationMutex.Lock()
+	defer generationMutex.Unlock()
+
+	previous, ok := fullCache.Get()
+	removeCachedDB()
+	removeSubsetDBs()
+	return previous, ok
+}
+
+// cacheInvalidateRoute accepts only a POST, and ignores its body. /cache/refresh shares it.
+var cacheInvalidateRoute = routeSpec{methods: []string{http.MethodPost}}
+
+// cacheInvalidateHandler handles POST /cache/invalidate, for forcing a refresh after
+// data is pushed to Postgres out-of-band
+func cacheInvalidateHandler(w http.ResponseWriter, r *http.Request) {
+	previous, ok := invalidateCache()
+	resp := invalidateResponse{Invalidated: ok}
+	if ok {
+		age := previous.age().Seconds()
+		resp.PreviousAgeSeconds = &age
+		requestLog(r).Info("Cache invalidated (age: %s)", previous.age().Round(time.Second))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// refreshResponse is the body returned by POST /cache/refresh
+type refreshResponse struct {
+	ApprovedProjects int     `json:"approved_projects"`
+	Mentions         int     `json:"ysws_project_mentions"`
+	CompressedSize   int64   `json:"compressed_size"`
+	UncompressedSize int64   `json:"uncompressed_size"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	SHA256           string  `json:"sha256"`
+	DurationSeconds  float64 `json:"duration_seconds"`
+}
+
+// forceRegenerate builds a new database even if the cached one is fresh; swapped out in tests
+var forceRegenerate = refreshDB
+
+// refreshDB rebuilds the database even if the cached one is fresh. The cached database is
+// served until the new one replaces it, and stays if the build fails. A generation that's
+// already running may have read Postgres before the change that prompted the refresh, so
+// a refresh never joins one (nor another refresh): it builds under generationMutex, after
+// the running generation finishes. /db requests arriving meanwhile wait on the mutex too,
+// then find the refreshed database in their double-check.
+func refreshDB(cfg *Config) (string, error) {
+	return generateDBIfOlderThan(cfg, 0)
+}
+
+// cacheRefreshHandler handles POST /cache/refresh: it rebuilds the database right away and
+// waits for it, then reports on it, for verifying deploys. The previous database (and its
+// subsets) is only dropped once the new one is in place, so a failed refresh leaves the
+// server serving the last good data. /db requests arriving meanwhile wait for it rather
+// than starting their own generation.
+func cacheRefreshHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		path, err := forceRegenerate(cfg)
+		if err != nil {
+			metrics.observeGenerationFailure()
+			requestLog(r).Error("Failed to refresh database, keeping the previous one: %v", err)
+			writeGenerationFailure(w, err)
+			return
+		}
+		// Subsets were built from the data the refresh replaced
+		removeSubsetDBs()
+
+		entry, ok := fullCache.Lookup(path)
+		if !ok {
+			// Invalidated again before we could read it
+			requestLog(r).Error("Refreshed database %s is no longer cached", path)
+			writeJSONError(w, http.StatusConflict, "Conflict: the cache was invalidated during the refresh")
+			return
+		}
+		requestLog(r).Info("Cache refreshed in %s", time.Since(start).Round(time.Millisecond))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(refreshResponse{
+			ApprovedProjects: entry.projectCount,
+			Mentions:         entry.mentionCount,
+			CompressedSize:   entry.compressedSize,
+			UncompressedSize: entry.uncompressedSize,
+			CompressionRatio: entry.compressionRatio,
+			SHA256:           entry.sha256,
+			DurationSeconds:  time.Since(start).Seconds(),
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDBCache(t *testing.T) {
+	c := &dbCache{}
+	if _, ok := c.Get(); ok || c.Ready() {
+		t.Fatal("new cache reports an entry or readiness")
+	}
+
+	first := cacheEntry{path: "/tmp/first.db.zst", createdAt: time.Now(), uncompressedSize: 100}
+	if previous := c.Set(first); previous.path != "" {
+		t.Errorf("Set() on an empty cache returned previous entry %q", previous.path)
+	}
+	if got, ok := c.Lookup(first.path); !ok || got.uncompressedSize != 100 {
+		t.Errorf("Lookup(%q) = %+v, %v", first.path, got, ok)
+	}
+
+	second := cacheEntry{path: "/tmp/second.db.zst", createdAt: time.Now()}
+	if previous := c.Set(second); previous.path != first.path {
+		t.Errorf("Set() returned previous entry %q, want %q", previous.path, first.path)
+	}
+	if _, ok := c.Lookup(first.path); ok {
+		t.Error("Lookup() still finds the replaced entry")
+	}
+
+	if removed := c.Clear(); removed.path != second.path {
+		t.Errorf("Clear() returned %q, want %q", removed.path, second.path)
+	}
+	if _, ok := c.Get(); ok {
+		t.Error("Get() found an entry after Clear()")
+	}
+	if !c.Ready() {
+		t.Error("Ready() = false after an entry was stored")
+	}
+}
+
+func TestCacheInvalidateHandler(t *testing.T) {
+	withFileRemovalGrace(t, 0)
+	path := withCachedFile(t, "cached", 90*time.Second)
+	prevDir := cacheDir
+	cacheDir = t.TempDir()
+	t.Cleanup(func() { cacheDir = prevDir })
+
+	rec := httptest.NewRecorder()
+	cacheInvalidateHandler(rec, httptest.NewRequest("POST", "/cache/invalidate", nil))
+
+	var resp invalidateResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if !resp.Invalidated || resp.PreviousAgeSeconds == nil || *resp.PreviousAgeSeconds < 90 {
+		t.Errorf("response = %s, want invalidated with a previous age of at least 90s", rec.Body.String())
+	}
+	if _, ok := fullCache.Get(); ok {
+		t.Error("cache still holds an entry after invalidation")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("cached file was not removed")
+	}
+
+	// Nothing left to invalidate
+	rec = httptest.NewRecorder()
+	cacheInvalidateHandler(rec, httptest.NewRequest("POST", "/cache/invalidate", nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"invalidated":false}` {
+		t.Errorf("second invalidation = %s, want {\"invalidated\":false}", body)
+	}
+
+	rec = httptest.NewRecorder()
+	cacheInvalidateRoute.wrap(http.HandlerFunc(cacheInvalidateHandler)).ServeHTTP(rec, httptest.NewRequest("GET", "/cache/invalidate", nil))
+	if rec.Code != 405 || rec.Header().Get("Allow") != "POST" {
+		t.Errorf("GET status = %d, want 405", rec.Code)
+	}
+}
+
+func TestCacheRefreshHandler(t *testing.T) {
+	withFileRemovalGrace(t, 0)
+	oldPath := withCachedFile(t, "old", time.Minute)
+	subset := withSubsetEntry(t, "tables=approved_projects")
+	prevDir, prevForce := cacheDir, forceRegenerate
+	cacheDir = t.TempDir()
+	t.Cleanup(func() { cacheDir, forceRegenerate = prevDir, prevForce })
+
+	forceRegenerate = func(*Config) (string, error) {
+		if path, ok := getCachedDB(); !ok || path != oldPath {
+			t.Error("the previous database was dropped before the refresh finished")
+		}
+		entry := cacheEntry{path: "/tmp/cached-db-2.db.zst", createdAt: time.Now(), projectCount: 3, mentionCount: 5,
+			compressedSize: 100, uncompressedSize: 400, compressionRatio: 4, sha256: "abc"}
+		fullCache.Set(entry)
+		return entry.path, nil
+	}
+
+	rec := httptest.NewRecorder()
+	cacheRefreshHandler(testConfig)(rec, httptest.NewRequest("POST", "/cache/refresh", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	var resp refreshResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if resp.ApprovedProjects != 3 || resp.Mentions != 5 || resp.CompressedSize != 100 || resp.UncompressedSize != 400 ||
+		resp.CompressionRatio != 4 || resp.SHA256 != "abc" || resp.DurationSeconds < 0 {
+		t.Errorf("response = %s, want the new database's stats", rec.Body.String())
+	}
+	if _, ok := getSubsetDB("tables=approved_projects"); ok {
+		t.Error("subset built from the old data is still cached")
+	}
+	if _, err := os.Stat(subset); !os.IsNotExist(err) {
+		t.Errorf("old subset file still exists after refresh (%v)", err)
+	}
+}
+
+func TestCacheRefreshFailureKeepsServingThePreviousDatabase(t *testing.T) {
+	contents := []byte("SQLite format 3\x00 last good database")
+	withCachedDatabase(t, contents)
+	prevDir, prevForce := cacheDir, forceRegenerate
+	cacheDir = t.TempDir()
+	t.Cleanup(func() { cacheDir, forceRegenerate = prevDir, prevForce })
+	forceRegenerate = func(*Config) (string, error) { return "", errTooFewRows }
+
+	rec := httptest.NewRecorder()
+	cacheRefreshHandler(testConfig)(rec, httptest.NewRequest("POST", "/cache/refresh", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 for a refused generation", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/db?format=sqlite", nil)
+	rec = httptest.NewRecorder()
+	dbHandler(testConfig)(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" || !bytes.Equal(rec.Body.Bytes(), contents) {
+		t.Errorf("/db after a failed refresh = %d (X-Cache %q), want the previous database from the cache",
+			rec.Code, rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestCacheRefreshDuringGenerationBuildsAgain(t *testing.T) {
+	withFileRemovalGrace(t, 0)
+	withCacheEntry(t, cacheEntry{})
+	prevDir := cacheDir
+	cacheDir = t.TempDir()
+	t.Cleanup(func() { cacheDir = prevDir })
+
+	// The first build reads Postgres and then waits; later builds finish right away
+	var builds atomic.Int32
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	withTableCopies(t,
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			if builds.Add(1) == 1 {
+				close(reading)
+				<-release
+			}
+			_, err := db.ExecContext(ctx, `INSERT INTO approved_projects (record_id) VALUES ('rec1')`)
+			return 1, err
+		},
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			_, err := db.ExecContext(ctx, `INSERT INTO ysws_project_mentions (id, ysws_approved_project) VALUES ('m1', 'rec1')`)
+			return 1, err
+		},
+	)
+
+	// A cacheTTL generation, e.g. the prewarmer's, reads Postgres...
+	generated := make(chan string, 1)
+	go func() {
+		path, _ := generateDBIfOlderThan(testConfig, cacheTTL)
+		generated <- path
+	}()
+	<-reading
+
+	// ...then the refresh starts, and a /db request queues behind both. Neither that
+	// generation nor the request's, which settles for a fresh cache, may stand in for it.
+	refreshed := make(chan string, 1)
+	go func() {
+		path, err := refreshDB(testConfig)
+		if err != nil {
+			t.Errorf("refreshDB() error: %v", err)
+		}
+		refreshed <- path
+	}()
+	waitForBlockedGoroutines(t, 1, "sync.(*Mutex).Lock", "backend.refreshDB")
+	requested := make(chan string, 1)
+	go func() {
+		path, _ := generateDB(testConfig)
+		requested <- path
+	}()
+	waitForBlockedGoroutines(t, 1, "sync.(*Mutex).Lock", "backend.generateDB")
+	close(release)
+
+	first, refreshPath, requestPath := <-generated, <-refreshed, <-requested
+	if n := builds.Load(); n != 2 {
+		t.Errorf("%d builds ran, want the generation's and the refresh's", n)
+	}
+	if refreshPath == "" || refreshPath == first {
+		t.Errorf("refreshDB() = %q, want a new database rather than the in-flight generation's %q", refreshPath, first)
+	}
+	if requestPath != first && requestPath != refreshPath {
+		t.Errorf("generateDB() = %q, want one of the two builds", requestPath)
+	}
+	if path, ok := getCachedDB(); !ok || path != refreshPath {
+		t.Errorf("cached database = %q, want the refreshed %q", path, refreshPath)
+	}
+}
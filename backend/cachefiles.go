@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// cacheDir holds the generated database files and the cache metadata sidecar (CACHE_DIR).
+// It defaults to a directory under the user cache dir rather than the OS temp dir, so
+// the cache survives /tmp cleanup and restarts.
+var cacheDir = defaultCacheDir()
+
+// workDir holds the uncompressed SQLite files while they're built: this process's own
+// directory inside WORK_DIR (or TEMP_DIR). They're several times the size of the
+// compressed files in cacheDir, so a small tmpfs may not fit them. Empty means cacheDir.
+var workDir string
+
+// workDirPrefix names the directory each process builds in under WORK_DIR. Other instances
+// may share WORK_DIR, so a process only ever deletes the directory it created itself.
+const workDirPrefix = "viral-project-explorer-build-"
+
+// createWorkDir creates this process's build directory in parent
+func createWorkDir(parent string) (string, error) {
+	return os.MkdirTemp(parent, workDirPrefix+"*")
+}
+
+// removeWorkDir deletes this process's build directory, with any unfinished build in it
+func removeWorkDir() {
+	if workDir == "" {
+		return
+	}
+	if err := os.RemoveAll(workDir); err != nil {
+		appLog.Warn("Failed to remove work directory %s: %v", workDir, err)
+	}
+}
+
+// buildDir returns the directory uncompressed databases are built in
+func buildDir() string {
+	if workDir != "" {
+		return workDir
+	}
+	return cacheDir
+}
+
+// checkWritableDir returns an error unless dir is an existing directory we can create
+// files in
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// cacheMetadataFile is the sidecar in cacheDir describing the current cache entry
+const cacheMetadataFile = "cache.json"
+
+func defaultCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "viral-project-explorer")
+	}
+	return filepath.Join(os.TempDir(), "viral-project-explorer")
+}
+
+// cacheFilePattern matches the files generateDB creates via os.CreateTemp(cacheDir, "cached-db-*.db"),
+// plus their compressed ".zst", Brotli ".br" and dictionary ".dict.zst" counterparts. It's deliberately strict so we never touch
+// unrelated files in a shared directory.
+var cacheFilePattern = regexp.MustCompile(`^cached-db-\d+\.db(\.zst|\.br|\.dict\.zst)?$`)
+
+// cleanupStaleCacheFiles removes database files left in dir by previous runs (e.g. after
+// a crash), except keep, and returns how many were removed and their total size
+func cleanupStaleCacheFiles(dir, keep string) (int, int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	removed := 0
+	var totalSize int64
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !cacheFilePattern.MatchString(entry.Name()) {
+			continue
+		}
+		if keep != "" && filepath.Join(dir, entry.Name()) == filepath.Clean(keep) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			appLog.Warn("Failed to remove stale cache file %s: %v", path, err)
+			continue
+		}
+		removed++
+		totalSize += info.Size()
+	}
+
+	return removed, totalSize, nil
+}
+
+// fileRemovalGrace is how long a replaced or invalidated cache file stays on disk. A request
+// may have looked its path up just before it was dropped from the cache and not opened it
+// yet; once it has, deleting the file no longer affects it.
+var fileRemovalGrace = time.Minute
+
+// pendingRemovals holds the timers of files waiting out fileRemovalGrace, so shutdown can
+// delete them right away
+var pendingRemovals = struct {
+	sync.Mutex
+	timers map[string]*time.Timer
+}{timers: map[string]*time.Timer{}}
+
+// removeAfterGrace deletes path once fileRemovalGrace has passed. Callers drop it from
+// every cache first, so no new request can find it meanwhile.
+func removeAfterGrace(path string) {
+	if fileRemovalGrace <= 0 {
+		os.Remove(path)
+		return
+	}
+	pendingRemovals.Lock()
+	defer pendingRemovals.Unlock()
+	if _, ok := pendingRemovals.timers[path]; ok {
+		return
+	}
+	pendingRemovals.timers[path] = time.AfterFunc(fileRemovalGrace, func() {
+		pendingRemovals.Lock()
+		delete(pendingRemovals.timers, path)
+		pendingRemovals.Unlock()
+		os.Remove(path)
+	})
+}
+
+// removePendingFiles deletes every file still waiting out fileRemovalGrace, at shutdown
+func removePendingFiles() {
+	pendingRemovals.Lock()
+	defer pendingRemovals.Unlock()
+	for path, timer := range pendingRemovals.timers {
+		timer.Stop()
+		os.Remove(path)
+		delete(pendingRemovals.timers, path)
+	}
+}
+
+// cacheMetadata is the JSON form of a cacheEntry stored in the sidecar file
+type cacheMetadata struct {
+	Path             string    `json:"path"`
+	CreatedAt        time.Time `json:"created_at"`
+	ETag             string    `json:"etag"`
+	CompressedSize   int64     `json:"compressed_size"`
+	UncompressedSize int64     `json:"uncompressed_size"`
+	CompressionRatio float64   `json:"compression_ratio"`
+	SchemaHash       string    `json:"schema_hash"`
+	SHA256           string    `json:"sha256"`
+	ProjectCount     int       `json:"project_count"`
+	MentionCount     int       `json:"mention_count"`
+	DataModifiedAt   time.Time `json:"data_modified_at"`
+	ZstdDictID       uint32    `json:"zstd_dict_id,omitempty"` // only set by versions that compressed the cache with it
+	DataVersion      string    `json:"data_version,omitempty"`
+
+	// How the email_hash values were made; see emailHashMode
+	EmailSaltFingerprint string `json:"email_salt_fingerprint"`
+	EmailHashPrefixed    bool   `json:"email_hash_prefixed"`
+}
+
+// saveCacheMetadata records entry in dir's sidecar file. It writes a temporary file and
+// renames it into place, so a crash never leaves a half-written sidecar behind.
+func saveCacheMetadata(dir string, entry cacheEntry) error {
+	data, err := json.Marshal(cacheMetadata{
+		Path:             entry.path,
+		CreatedAt:        entry.createdAt,
+		ETag:             entry.etag,
+		CompressedSize:   entry.compressedSize,
+		UncompressedSize: entry.uncompressedSize,
+		CompressionRatio: entry.compressionRatio,
+		SchemaHash:       entry.schemaHash,
+		SHA256:           entry.sha256,
+		ProjectCount:     entry.projectCount,
+		MentionCount:     entry.mentionCount,
+		DataModifiedAt:   entry.dataModifiedAt,
+		DataVersion:      entry.dataVersion,
+
+		EmailSaltFingerprint: entry.emailHashes.saltFingerprint,
+		EmailHashPrefixed:    entry.emailHashes.prefixed,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding cache metadata: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, cacheMetadataFile+".*")
+	if err != nil {
+		return fmt.Errorf("writing cache metadata: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing cache metadata: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing cache metadata: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, cacheMetadataFile)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing cache metadata: %w", err)
+	}
+	return nil
+}
+
+// removeCacheMetadata deletes dir's sidecar file, if any
+func removeCacheMetadata(dir string) {
+	os.Remove(filepath.Join(dir, cacheMetadataFile))
+}
+
+// loadCacheMetadata reads the cache entry recorded in dir's sidecar file and checks it's
+// still usable: the file must be in dir, unchanged since it was recorded, built with the
+// current schema, cfg's email hash mode and without a zstd dictionary, and no older than
+// maxAge
+func loadCacheMetadata(cfg *Config, dir string, maxAge time.Duration) (cacheEntry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, cacheMetadataFile))
+	if err != nil {
+		return cacheEntry{}, err
+	}
+
+	var meta cacheMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return cacheEntry{}, fmt.Errorf("parsing cache metadata: %w", err)
+	}
+
+	if filepath.Dir(meta.Path) != filepath.Clean(dir) || !cacheFilePattern.MatchString(filepath.Base(meta.Path)) {
+		return cacheEntry{}, fmt.Errorf("cached database %q is not a cache file in %s", meta.Path, dir)
+	}
+	info, err := os.Stat(meta.Path)
+	if err != nil {
+		return cacheEntry{}, fmt.Errorf("cached database: %w", err)
+	}
+	if info.Size() != meta.CompressedSize || fileETag(info) != meta.ETag {
+		return cacheEntry{}, fmt.Errorf("cached database %s changed since it was recorded", meta.Path)
+	}
+	if meta.SchemaHash != expectedSchemaHash {
+		return cacheEntry{}, fmt.Errorf("cached database was built with a different schema")
+	}
+	// Before ?format=zstd-dict, the cached file itself was compressed with the dictionary
+	if meta.ZstdDictID != 0 {
+		return cacheEntry{}, fmt.Errorf("cached database was compressed with zstd dictionary %d", meta.ZstdDictID)
+	}
+	emailHashes := emailHashMode{saltFingerprint: meta.EmailSaltFingerprint, prefixed: meta.EmailHashPrefixed}
+	if emailHashes != cfg.emailHashMode() {
+		return cacheEntry{}, fmt.Errorf("cached database's email hashes were made with a different EMAIL_SALT or EMAIL_HASH_PREFIX")
+	}
+	if age := time.Since(meta.CreatedAt); age > maxAge || age < 0 {
+		return cacheEntry{}, fmt.Errorf("cached database is %s old, past the %s TTL", age.Round(time.Second), maxAge)
+	}
+
+	// Sidecars written before checksums were recorded don't have one
+	sum := meta.SHA256
+	if sum == "" {
+		if sum, err = fileSHA256(meta.Path); err != nil {
+			return cacheEntry{}, fmt.Errorf("cached database: %w", err)
+		}
+	}
+
+	return cacheEntry{
+		path:             meta.Path,
+		createdAt:        meta.CreatedAt,
+		etag:             meta.ETag,
+		compressedSize:   meta.CompressedSize,
+		uncompressedSize: meta.UncompressedSize,
+		compressionRatio: meta.CompressionRatio,
+		schemaHash:       meta.SchemaHash,
+		sha256:           sum,
+		projectCount:     meta.ProjectCount,
+		mentionCount:     meta.MentionCount,
+		dataModifiedAt:   meta.DataModifiedAt,
+		dataVersion:      meta.DataVersion,
+		emailHashes:      emailHashes,
+	}, nil
+}
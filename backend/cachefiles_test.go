@@ -0,0 +1,239 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withFileRemovalGrace sets how long dropped cache files wait before they're deleted
+func withFileRemovalGrace(t *testing.T, grace time.Duration) {
+	t.Helper()
+	prev := fileRemovalGrace
+	fileRemovalGrace = grace
+	t.Cleanup(func() { fileRemovalGrace = prev })
+}
+
+func TestRemoveAfterGraceKeepsFileOpenableUntilItPasses(t *testing.T) {
+	withFileRemovalGrace(t, 50*time.Millisecond)
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "cached-db-1.db.zst"), filepath.Join(dir, "cached-db-2.db.zst")
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+			t.Fatalf("writing %s: %v", path, err)
+		}
+	}
+
+	removeAfterGrace(first)
+	removeAfterGrace(second)
+	// A request that looked the path up just before it was dropped can still open it
+	if file, err := os.Open(first); err != nil {
+		t.Fatalf("file removed before the grace period passed: %v", err)
+	} else {
+		file.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(first); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("file still exists long after the grace period")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Shutdown deletes whatever is still waiting
+	withFileRemovalGrace(t, time.Hour)
+	if err := os.WriteFile(first, []byte("data"), 0o600); err != nil {
+		t.Fatalf("rewriting %s: %v", first, err)
+	}
+	removeAfterGrace(first)
+	removePendingFiles()
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("pending file still exists after removePendingFiles() (%v)", err)
+	}
+}
+
+func TestCleanupStaleCacheFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	stale := []string{"cached-db-123456.db", "cached-db-987654.db.zst"}
+	unrelated := []string{"cached-db-notes.txt", "cached-db-123.db.bak", "other-123.db", "cached-db-.db"}
+	for _, name := range append(append([]string{}, stale...), unrelated...) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0o600); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+
+	removed, size, err := cleanupStaleCacheFiles(dir, "")
+	if err != nil {
+		t.Fatalf("cleanupStaleCacheFiles() error: %v", err)
+	}
+	if removed != len(stale) {
+		t.Errorf("removed = %d, want %d", removed, len(stale))
+	}
+	if size != int64(4*len(stale)) {
+		t.Errorf("total size = %d, want %d", size, 4*len(stale))
+	}
+
+	for _, name := range stale {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed", name)
+		}
+	}
+	for _, name := range unrelated {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s should have been kept: %v", name, err)
+		}
+	}
+}
+
+func TestCleanupStaleCacheFilesKeepsRestoredDatabase(t *testing.T) {
+	dir := t.TempDir()
+	keep := filepath.Join(dir, "cached-db-1.db.zst")
+	stale := filepath.Join(dir, "cached-db-2.db.zst")
+	for _, path := range []string{keep, stale} {
+		if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+			t.Fatalf("writing %s: %v", path, err)
+		}
+	}
+
+	if removed, _, err := cleanupStaleCacheFiles(dir, keep); err != nil || removed != 1 {
+		t.Fatalf("cleanupStaleCacheFiles() = %d, %v; want 1 removed", removed, err)
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Errorf("restored database was removed: %v", err)
+	}
+}
+
+// writeCacheFile writes a cache file into dir and returns the entry describing it
+func writeCacheFile(t *testing.T, dir string, createdAt time.Time) cacheEntry {
+	t.Helper()
+	path := filepath.Join(dir, "cached-db-42.db.zst")
+	if err := os.WriteFile(path, []byte("compressed database"), 0o600); err != nil {
+		t.Fatalf("writing cache file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat cache file: %v", err)
+	}
+	return cacheEntry{
+		path:             path,
+		createdAt:        createdAt,
+		etag:             fileETag(info),
+		compressedSize:   info.Size(),
+		uncompressedSize: 1000,
+		schemaHash:       expectedSchemaHash,
+		projectCount:     3,
+		emailHashes:      testConfig.emailHashMode(),
+		dataModifiedAt:   time.Date(2024, 7, 4, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestCacheMetadataRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	entry := writeCacheFile(t, dir, time.Now().Add(-time.Minute))
+	if err := saveCacheMetadata(dir, entry); err != nil {
+		t.Fatalf("saveCacheMetadata() error: %v", err)
+	}
+
+	got, err := loadCacheMetadata(testConfig, dir, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("loadCacheMetadata() error: %v", err)
+	}
+	if got.path != entry.path || !got.createdAt.Equal(entry.createdAt) || got.uncompressedSize != 1000 || got.projectCount != 3 ||
+		!got.dataModifiedAt.Equal(entry.dataModifiedAt) || got.emailHashes != entry.emailHashes {
+		t.Errorf("loadCacheMetadata() = %+v, want %+v", got, entry)
+	}
+	// The sidecar had no checksum, so loading computes one
+	if want, _ := fileSHA256(entry.path); got.sha256 != want {
+		t.Errorf("loadCacheMetadata() sha256 = %q, want %q", got.sha256, want)
+	}
+}
+
+func TestLoadCacheMetadataRejectsInconsistentCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(t *testing.T, entry *cacheEntry)
+	}{
+		{"expired", func(t *testing.T, entry *cacheEntry) { entry.createdAt = time.Now().Add(-time.Hour) }},
+		{"different schema", func(t *testing.T, entry *cacheEntry) { entry.schemaHash = "old" }},
+		{"different email salt", func(t *testing.T, entry *cacheEntry) {
+			entry.emailHashes = (&Config{EmailSalt: "another-salt-entirely"}).emailHashMode()
+		}},
+		{"different email hash prefix", func(t *testing.T, entry *cacheEntry) { entry.emailHashes.prefixed = !emailHashPrefixed }},
+		{"no email hash mode", func(t *testing.T, entry *cacheEntry) { entry.emailHashes = emailHashMode{} }},
+		{"outside the cache dir", func(t *testing.T, entry *cacheEntry) {
+			entry.path = filepath.Join(t.TempDir(), filepath.Base(entry.path))
+		}},
+		{"missing file", func(t *testing.T, entry *cacheEntry) { os.Remove(entry.path) }},
+		{"modified file", func(t *testing.T, entry *cacheEntry) {
+			if err := os.WriteFile(entry.path, []byte("truncated"), 0o600); err != nil {
+				t.Fatalf("rewriting cache file: %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		entry := writeCacheFile(t, dir, time.Now())
+		tt.mutate(t, &entry)
+		if err := saveCacheMetadata(dir, entry); err != nil {
+			t.Fatalf("%s: saveCacheMetadata() error: %v", tt.name, err)
+		}
+		if _, err := loadCacheMetadata(testConfig, dir, 5*time.Minute); err == nil {
+			t.Errorf("%s: loadCacheMetadata() accepted the cache", tt.name)
+		}
+	}
+}
+
+func TestCheckWritableDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkWritableDir(dir); err != nil {
+		t.Errorf("checkWritableDir(temp dir) = %v, want nil", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("checkWritableDir() left %d files behind", len(entries))
+	}
+
+	file := filepath.Join(dir, "not-a-dir")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{filepath.Join(dir, "missing"), file} {
+		if err := checkWritableDir(bad); err == nil {
+			t.Errorf("checkWritableDir(%s) = nil, want an error", bad)
+		}
+	}
+}
+
+func TestWorkDirLeavesOtherInstancesAlone(t *testing.T) {
+	shared := t.TempDir()
+	other := filepath.Join(shared, "cached-db-1.db")
+	if err := os.WriteFile(other, []byte("another instance's build"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := workDir
+	t.Cleanup(func() { workDir = prev })
+	var err error
+	if workDir, err = createWorkDir(shared); err != nil {
+		t.Fatalf("createWorkDir() error: %v", err)
+	}
+	if filepath.Dir(workDir) != shared || buildDir() != workDir {
+		t.Fatalf("work directory = %s, buildDir() = %s; want a directory inside %s", workDir, buildDir(), shared)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "cached-db-2.db"), []byte("our build"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	removeWorkDir()
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Errorf("work directory still exists after removeWorkDir(): %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("another instance's build was removed: %v", err)
+	}
+}
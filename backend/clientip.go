@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// cidrList is a set of networks, e.g. from IP_ALLOWLIST or TRUSTED_PROXIES
+type cidrList []*net.IPNet
+
+// parseCIDRList parses comma-separated networks such as 10.0.0.0/8 or 2001:db8::/32.
+// A bare address is taken as a network of just that host.
+func parseCIDRList(value string) (cidrList, error) {
+	var list cidrList
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not a CIDR or IP address", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid CIDR", item)
+		}
+		list = append(list, network)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no networks given")
+	}
+	return list, nil
+}
+
+// contains reports whether ip is in any of the networks. IPv4-mapped IPv6 addresses
+// (::ffff:10.0.0.1) match IPv4 networks.
+func (l cidrList) contains(ip net.IP) bool {
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxies are the proxies whose X-Forwarded-For we believe (TRUSTED_PROXIES).
+// With none, X-Forwarded-For is ignored by resolveClientIP.
+var trustedProxies cidrList
+
+// parseHostIP parses an address that may carry a port ("1.2.3.4:80", "[::1]:80")
+func parseHostIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
+
+// resolveClientIP returns the IP of the client behind a request, or nil if it can't be
+// parsed. X-Forwarded-For is only honored when the direct peer is a trusted proxy: the
+// chain is then walked right to left, skipping trusted proxies, and the first other
+// address is the client. Entries left of an unparsable one are never believed.
+func resolveClientIP(r *http.Request) net.IP {
+	ip := parseHostIP(r.RemoteAddr)
+	if ip == nil || !trustedProxies.contains(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHostIP(hops[i])
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !trustedProxies.contains(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// clientIP returns the request's client IP as resolved by loggingMiddleware, resolving
+// it here for requests that didn't pass through it
+func clientIP(r *http.Request) net.IP {
+	if info := requestInfoFrom(r); info != nil && info.clientIP != nil {
+		return info.clientIP
+	}
+	return resolveClientIP(r)
+}
+
+// clientAddr is clientIP as a string for logs and rate-limit keys, falling back to the
+// raw RemoteAddr if it isn't an IP
+func clientAddr(r *http.Request) string {
+	if ip := clientIP(r); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
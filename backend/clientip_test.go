@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withTrustedProxies sets TRUSTED_PROXIES for the duration of a test
+func withTrustedProxies(t *testing.T, value string) {
+	t.Helper()
+	prev := trustedProxies
+	trustedProxies = nil
+	if value != "" {
+		proxies, err := parseCIDRList(value)
+		if err != nil {
+			t.Fatalf("parseCIDRList(%q) error: %v", value, err)
+		}
+		trustedProxies = proxies
+	}
+	t.Cleanup(func() { trustedProxies = prev })
+}
+
+func TestParseCIDRList(t *testing.T) {
+	list, err := parseCIDRList(" 10.0.0.0/8, 2001:db8::/32,192.0.2.7 , ::1")
+	if err != nil {
+		t.Fatalf("parseCIDRList() error: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"192.0.2.7", true},
+		{"192.0.2.8", false},
+		{"::1", true},
+	}
+	for _, tt := range tests {
+		if got := list.contains(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("contains(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", " , ", "10.0.0.0/33", "not-an-ip", "10.0.0.0/8,nope"} {
+		if _, err := parseCIDRList(value); err == nil {
+			t.Errorf("parseCIDRList(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8, fd00::/8")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"untrusted peer's header is ignored", "203.0.113.5:4000", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.2:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entries left of the real client", "10.0.0.2:4000", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:4000", []string{"198.51.100.1, 10.0.0.9", "10.0.0.3"}, "198.51.100.1"},
+		{"every hop trusted", "10.0.0.2:4000", []string{"10.0.0.5, 10.0.0.9"}, "10.0.0.5"},
+		{"garbage stops the walk", "10.0.0.2:4000", []string{"1.1.1.1, garbage, 10.0.0.9"}, "10.0.0.9"},
+		{"IPv6 proxy and client", "[fd00::2]:4000", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"forwarded entry with port", "10.0.0.2:4000", []string{"[2001:db8::7]:5000"}, "2001:db8::7"},
+		{"no header from trusted proxy", "10.0.0.2:4000", nil, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/db", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := resolveClientIP(r); !got.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("resolveClientIP() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveClientIPIgnoresForwardedWithoutTrustedProxies(t *testing.T) {
+	withTrustedProxies(t, "")
+
+	r := httptest.NewRequest("GET", "/db", nil)
+	r.RemoteAddr = "10.0.0.2:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := resolveClientIP(r); !got.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("resolveClientIP() = %v, want the peer address", got)
+	}
+}
+
+func TestLoggingMiddlewareResolvesClientIP(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+
+	var got string
+	handler := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientAddr(r)
+	}))
+	r := httptest.NewRequest("GET", "/db", nil)
+	r.RemoteAddr = "10.0.0.2:4000"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got != "198.51.100.1" {
+		t.Errorf("clientAddr() inside loggingMiddleware = %q, want 198.51.100.1", got)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+// Config holds the secrets authentication and email hashing depend on. main builds it from
+// the environment and hands it to the handlers that check keys, and through them to
+// database generation, which hashes emails; tests build their own, so several
+// configurations can coexist in one process.
+type Config struct {
+	// APIKeys holds every accepted key: API_KEY (as "default") plus any from API_KEYS /
+	// API_KEYS_FILE, all with admin scope, and READ_API_KEY / ADMIN_API_KEY (as "read" and "admin")
+	APIKeys []apiKeyEntry
+
+	// EmailSalt keys the email hash (EMAIL_SALT)
+	EmailSalt string
+}
@@ -0,0 +1,165 @@
+//go:build integration
+
+// Integration tests for the Postgres copies. They load a fixture into the Postgres at
+// INTEGRATION_POSTGRES_URL, replacing its airtable_unified_ysws_projects_db schema, and
+// are skipped without it. The integration module starts a throwaway one in Docker and
+// runs them against it:
+//
+//	cd integration && go run .
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// postgresFixture is a tiny copy of the warehouse schema the copy queries read, with
+// values chosen to exercise the transforms: emails to hash, URLs to normalize, NULLs.
+var postgresFixture = []string{
+	`DROP SCHEMA IF EXISTS airtable_unified_ysws_projects_db CASCADE`,
+	`CREATE SCHEMA airtable_unified_ysws_projects_db`,
+	`CREATE TABLE airtable_unified_ysws_projects_db.approved_projects (
+		_dlt_id TEXT PRIMARY KEY,
+		record_id TEXT,
+		first_name TEXT,
+		last_name TEXT,
+		git_hub_username TEXT,
+		geocoded_country TEXT,
+		geocoded_country_code TEXT,
+		playable_url TEXT,
+		code_url TEXT,
+		hours_spent DOUBLE PRECISION,
+		approved_at TEXT,
+		override_hours_spent_justification TEXT,
+		age_when_approved BIGINT,
+		email TEXT
+	)`,
+	`CREATE TABLE airtable_unified_ysws_projects_db.approved_projects__ysws_name (
+		_dlt_parent_id TEXT,
+		_dlt_list_idx BIGINT,
+		value TEXT
+	)`,
+	`CREATE TABLE airtable_unified_ysws_projects_db.ysws_project_mentions (
+		id TEXT PRIMARY KEY,
+		ysws_project_mentions_id TEXT,
+		ysws_project_mention_searches TEXT,
+		ysws_from_ysws_approved_project TEXT,
+		record_id TEXT,
+		ysws_approved_project TEXT,
+		source TEXT,
+		link_found_at TEXT,
+		archive_url TEXT,
+		url TEXT,
+		headline TEXT,
+		date TEXT,
+		weighted_engagement_points DOUBLE PRECISION,
+		project_url TEXT,
+		engagement_count BIGINT,
+		engagement_type TEXT,
+		mentions_hack_club BOOLEAN,
+		published_by_hack_club BOOLEAN
+	)`,
+	`INSERT INTO airtable_unified_ysws_projects_db.approved_projects VALUES
+		('dlt1', 'rec1', 'Ada', 'Lovelace', 'ada', 'United Kingdom', 'GB',
+			' HTTPS://WWW.Example.com/Play/ ', 'https://github.com/Ada/game.git', 12.5,
+			'2024-05-01T10:00:00Z', NULL, 16, ' Ada@Example.com '),
+		('dlt2', 'rec2', 'Grace', NULL, NULL, NULL, NULL,
+			'javascript:alert(1)', NULL, NULL, NULL, NULL, NULL, NULL)`,
+	`INSERT INTO airtable_unified_ysws_projects_db.approved_projects__ysws_name VALUES
+		('dlt1', 0, 'Daydream'),
+		('dlt1', 1, 'Ignored'),
+		('dlt2', 0, 'Summer of Making')`,
+	`INSERT INTO airtable_unified_ysws_projects_db.ysws_project_mentions
+		(id, ysws_approved_project, url, headline, date, weighted_engagement_points, engagement_count, mentions_hack_club, published_by_hack_club)
+	VALUES
+		('m1', 'rec1', 'news.example.com/story?utm_source=x#top', 'Teen builds a game', '2024-06-01', 4.5, 120, TRUE, FALSE),
+		('m2', 'rec1', NULL, NULL, NULL, NULL, NULL, NULL, NULL)`,
+}
+
+// withFixturePostgres loads postgresFixture into the Postgres at INTEGRATION_POSTGRES_URL
+// and returns a connection to it
+func withFixturePostgres(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("INTEGRATION_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("INTEGRATION_POSTGRES_URL is not set")
+	}
+	ctx := context.Background()
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("opening Postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, stmt := range postgresFixture {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("loading fixture: %v", err)
+		}
+	}
+
+	prevSchema := pgSchema
+	pgSchema = "airtable_unified_ysws_projects_db"
+	t.Cleanup(func() { pgSchema = prevSchema })
+	return db
+}
+
+func TestCopyFunctionsAgainstPostgres(t *testing.T) {
+	pg := withFixturePostgres(t)
+	db, _ := openGeneratedDB(t)
+	ctx := context.Background()
+
+	projects, err := copyApprovedProjects(ctx, pg, db, copyScope{cfg: testConfig})
+	if err != nil || projects != 2 {
+		t.Fatalf("copyApprovedProjects() = %d, %v; want 2 rows", projects, err)
+	}
+	mentions, err := copyProjectMentions(ctx, pg, db, copyScope{cfg: testConfig})
+	if err != nil || mentions != 2 {
+		t.Fatalf("copyProjectMentions() = %d, %v; want 2 rows", mentions, err)
+	}
+
+	got := queryRow(t, db, `SELECT first_name, playable_url, code_url, hours_spent, age_when_approved, ysws_name, email_hash
+		FROM approved_projects WHERE record_id = 'rec1'`)
+	want := []interface{}{"Ada", "https://example.com/play", "https://github.com/ada/game", "12.5", "16", "Daydream", testConfig.hashEmail("ada@example.com")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rec1 = %q, want %q", got, want)
+	}
+
+	// Missing values stay NULL, and a rejected URL is dropped rather than shipped
+	got = queryRow(t, db, `SELECT last_name, playable_url, hours_spent, ysws_name, email_hash
+		FROM approved_projects WHERE record_id = 'rec2'`)
+	want = []interface{}{nil, nil, nil, "Summer of Making", nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rec2 = %q, want %q", got, want)
+	}
+
+	got = queryRow(t, db, `SELECT ysws_approved_project, url, weighted_engagement_points, engagement_count, mentions_hack_club, published_by_hack_club
+		FROM ysws_project_mentions WHERE id = 'm1'`)
+	want = []interface{}{"rec1", "https://news.example.com/story", "4.5", "120", "1", "0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("m1 = %q, want %q", got, want)
+	}
+
+	got = queryRow(t, db, `SELECT url, headline, mentions_hack_club FROM ysws_project_mentions WHERE id = 'm2'`)
+	want = []interface{}{nil, nil, nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("m2 = %q, want %q", got, want)
+	}
+}
+
+func TestCopyFunctionsScopeToYSWSAgainstPostgres(t *testing.T) {
+	pg := withFixturePostgres(t)
+	db, _ := openGeneratedDB(t)
+	ctx := context.Background()
+
+	if n, err := copyApprovedProjects(ctx, pg, db, copyScope{cfg: testConfig, ysws: "Summer of Making"}); err != nil || n != 1 {
+		t.Errorf("copyApprovedProjects(ysws) = %d, %v; want 1 row", n, err)
+	}
+	if n, err := copyProjectMentions(ctx, pg, db, copyScope{cfg: testConfig, ysws: "Summer of Making"}); err != nil || n != 0 {
+		t.Errorf("copyProjectMentions(ysws) = %d, %v; want 0 rows", n, err)
+	}
+}
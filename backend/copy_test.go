@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// queryRow reads one row of the given columns as strings, with NULLs as nil
+func queryRow(t *testing.T, db *sql.DB, query string) []interface{} {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("querying %q: %v", query, err)
+	}
+	defer rows.Close()
+	columns, _ := rows.Columns()
+	if !rows.Next() {
+		t.Fatalf("%q returned no rows", query)
+	}
+	values := make([]interface{}, len(columns))
+	scanned := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range scanned {
+		pointers[i] = &scanned[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		t.Fatalf("scanning %q: %v", query, err)
+	}
+	for i, s := range scanned {
+		if s.Valid {
+			values[i] = s.String
+		}
+	}
+	return values
+}
+
+// newMockPostgres returns a sqlmock querier that fails the test if its expectations
+// aren't all met
+func newMockPostgres(t *testing.T) (Querier, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("creating sqlmock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet Postgres expectations: %v", err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// approvedProjectRows are rows shaped like approvedProjectsQuery's result
+func approvedProjectRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"record_id", "first_name", "last_name", "git_hub_username", "geocoded_country",
+		"geocoded_country_code", "playable_url", "code_url", "hours_spent", "approved_at",
+		"override_hours_spent_justification", "age_when_approved", "ysws_name", "email",
+	})
+}
+
+func TestCopyApprovedProjectsHashesEmails(t *testing.T) {
+	pg, mock := newMockPostgres(t)
+	mock.ExpectQuery(`FROM \S+\.approved_projects ap`).WillReturnRows(approvedProjectRows().
+		AddRow("rec1", "Ada", "Lovelace", "ada", "United Kingdom", "GB", " HTTPS://WWW.Example.com/Play/ ",
+			"https://github.com/Ada/game.git", 12.5, "2024-05-01", nil, int64(16), "Daydream", " Ada@Example.com "))
+	db, _ := openGeneratedDB(t)
+
+	if n, err := copyApprovedProjects(context.Background(), pg, db, copyScope{cfg: testConfig}); err != nil || n != 1 {
+		t.Fatalf("copyApprovedProjects() = %d, %v; want 1 row", n, err)
+	}
+
+	got := queryRow(t, db, `SELECT email_hash, playable_url, code_url, hours_spent, age_when_approved FROM approved_projects`)
+	want := []interface{}{testConfig.hashEmail("ada@example.com"), "https://example.com/play", "https://github.com/ada/game", "12.5", "16"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("copied row = %q, want %q", got, want)
+	}
+}
+
+func TestCopyApprovedProjectsKeepsNULLs(t *testing.T) {
+	pg, mock := newMockPostgres(t)
+	mock.ExpectQuery(`FROM \S+\.approved_projects ap`).WillReturnRows(approvedProjectRows().
+		AddRow("rec1", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil).
+		AddRow("rec2", "Grace", nil, nil, nil, nil, "javascript:alert(1)", "", nil, nil, nil, nil, nil, ""))
+	db, _ := openGeneratedDB(t)
+
+	if n, err := copyApprovedProjects(context.Background(), pg, db, copyScope{cfg: testConfig}); err != nil || n != 2 {
+		t.Fatalf("copyApprovedProjects() = %d, %v; want 2 rows", n, err)
+	}
+
+	// NULLs stay NULL; empty emails and URLs, and rejected URLs, become NULL too
+	for _, recordID := range []string{"rec1", "rec2"} {
+		got := queryRow(t, db, `SELECT last_name, playable_url, code_url, hours_spent, age_when_approved, ysws_name, email_hash
+			FROM approved_projects WHERE record_id = '`+recordID+`'`)
+		want := make([]interface{}, 7)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want all NULL", recordID, got)
+		}
+	}
+}
+
+func TestCopyApprovedProjectsEmailDomain(t *testing.T) {
+	for _, include := range []bool{false, true} {
+		includeEmailDomain = include
+		t.Cleanup(func() { includeEmailDomain = false })
+
+		pg, mock := newMockPostgres(t)
+		mock.ExpectQuery(`FROM \S+\.approved_projects ap`).WillReturnRows(approvedProjectRows().
+			AddRow("rec1", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, "Ada@School.EDU").
+			AddRow("rec2", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, "not-an-email"))
+		db, _ := openGeneratedDB(t)
+
+		if n, err := copyApprovedProjects(context.Background(), pg, db, copyScope{cfg: testConfig}); err != nil || n != 2 {
+			t.Fatalf("copyApprovedProjects() = %d, %v; want 2 rows", n, err)
+		}
+
+		var want interface{}
+		if include {
+			want = "school.edu"
+		}
+		if got := queryRow(t, db, `SELECT email_domain FROM approved_projects WHERE record_id = 'rec1'`); got[0] != want {
+			t.Errorf("INCLUDE_EMAIL_DOMAIN=%v: email_domain = %v, want %v", include, got[0], want)
+		}
+		if got := queryRow(t, db, `SELECT email_domain FROM approved_projects WHERE record_id = 'rec2'`); got[0] != nil {
+			t.Errorf("INCLUDE_EMAIL_DOMAIN=%v: email_domain of a malformed email = %v, want NULL", include, got[0])
+		}
+	}
+}
+
+func TestCopyApprovedProjectsIncrementalFilter(t *testing.T) {
+	pg, mock := newMockPostgres(t)
+	mock.ExpectQuery(`WHERE ap\.approved_at >= \$1 AND ysws_name\.value = \$2`).
+		WithArgs("2024-05-01", "Daydream").
+		WillReturnRows(approvedProjectRows())
+	db, _ := openGeneratedDB(t)
+
+	scope := copyScope{cfg: testConfig, since: &watermarks{approvedAt: "2024-05-01"}, ysws: "Daydream"}
+	if n, err := copyApprovedProjects(context.Background(), pg, db, scope); err != nil || n != 0 {
+		t.Errorf("copyApprovedProjects() = %d, %v; want 0 rows", n, err)
+	}
+}
+
+func TestCopyProjectMentionsIncrementalFilterUsesLinkFoundAt(t *testing.T) {
+	pg, mock := newMockPostgres(t)
+	mock.ExpectQuery(`WHERE link_found_at >= \$1`).
+		WithArgs("2024-07-01").
+		WillReturnRows(sqlmock.NewRows(projectMentionColumns))
+	db, _ := openGeneratedDB(t)
+
+	scope := copyScope{cfg: testConfig, since: &watermarks{mentionFoundAt: "2024-07-01"}}
+	if n, err := copyProjectMentions(context.Background(), pg, db, scope); err != nil || n != 0 {
+		t.Errorf("copyProjectMentions() = %d, %v; want 0 rows", n, err)
+	}
+}
+
+func TestCopyProjectMentionsKeepsNULLs(t *testing.T) {
+	pg, mock := newMockPostgres(t)
+	rows := sqlmock.NewRows(projectMentionColumns).
+		AddRow("m1", nil, nil, nil, nil, "rec1", nil, nil, nil, "news.example.com/story?utm_source=x", "Launch", "2024-06-01",
+			4.5, nil, int64(120), nil, true, false).
+		AddRow("m2", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	mock.ExpectQuery(`FROM \S+\.ysws_project_mentions`).WillReturnRows(rows)
+	db, _ := openGeneratedDB(t)
+
+	if n, err := copyProjectMentions(context.Background(), pg, db, copyScope{cfg: testConfig}); err != nil || n != 2 {
+		t.Fatalf("copyProjectMentions() = %d, %v; want 2 rows", n, err)
+	}
+
+	got := queryRow(t, db, `SELECT url, weighted_engagement_points, engagement_count, mentions_hack_club, published_by_hack_club
+		FROM ysws_project_mentions WHERE id = 'm1'`)
+	want := []interface{}{"https://news.example.com/story", "4.5", "120", "1", "0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("m1 = %q, want %q", got, want)
+	}
+
+	got = queryRow(t, db, `SELECT ysws_approved_project, url, weighted_engagement_points, engagement_count, mentions_hack_club, published_by_hack_club
+		FROM ysws_project_mentions WHERE id = 'm2'`)
+	if want := make([]interface{}, 6); !reflect.DeepEqual(got, want) {
+		t.Errorf("m2 = %q, want all NULL", got)
+	}
+}
+
+func TestCopyApprovedProjectsQueryError(t *testing.T) {
+	pg, mock := newMockPostgres(t)
+	mock.ExpectQuery(`FROM \S+\.approved_projects ap`).WillReturnError(sql.ErrConnDone)
+	db, _ := openGeneratedDB(t)
+
+	if _, err := copyApprovedProjects(context.Background(), pg, db, copyScope{cfg: testConfig}); err == nil {
+		t.Error("copyApprovedProjects() succeeded, want the query error")
+	}
+}
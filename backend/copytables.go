@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Querier is the part of *sql.DB the copy functions read Postgres through, so tests can
+// drive them with sqlmock instead of a live database
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// tableCopy is one Postgres → SQLite table copy run by copyTables
+type tableCopy struct {
+	table string
+	copy  func(ctx context.Context, pg Querier, sqliteDB *sql.DB, scope copyScope) (int, error)
+}
+
+// copyScope narrows which rows the copy functions pull from Postgres
+type copyScope struct {
+	cfg   *Config     // hashes the copied emails
+	since *watermarks // incremental mode: only newer rows, replacing existing ones
+	ysws  string      // only this YSWS program's projects and their mentions
+}
+
+// pgFilter builds a WHERE clause from conditions written with ? placeholders,
+// numbering them as $1, $2, ... for Postgres
+type pgFilter struct {
+	conds []string
+	args  []interface{}
+}
+
+func (f *pgFilter) add(cond string, arg interface{}) {
+	f.args = append(f.args, arg)
+	f.conds = append(f.conds, strings.Replace(cond, "?", fmt.Sprintf("$%d", len(f.args)), 1))
+}
+
+func (f *pgFilter) where() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conds, " AND ")
+}
+
+// The copies are merged in this order, so projects land before the mentions referencing them.
+var tableCopies = []tableCopy{
+	{table: "approved_projects", copy: copyApprovedProjects},
+	{table: "ysws_project_mentions", copy: copyProjectMentions},
+}
+
+// copyTables runs the given table copies from pg concurrently and returns the rows copied per table.
+// SQLite only allows one writer per file, so each table is written to its own side database
+// and then merged into sqliteDB (at dbPath) with ATTACH. If one copy fails, the others are
+// cancelled.
+func copyTables(ctx context.Context, pg Querier, sqliteDB *sql.DB, dbPath string, scope copyScope, copies []tableCopy) (map[string]int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	counts := make([]int, len(copies))
+	sidePaths := make([]string, len(copies))
+	defer func() {
+		for _, path := range sidePaths {
+			if path != "" {
+				os.Remove(path)
+			}
+		}
+	}()
+
+	// Only the first failure is reported; later ones are usually just the cancellation
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		firstErr error
+	)
+	for i, tc := range copies {
+		sidePaths[i] = fmt.Sprintf("%s.%s", dbPath, tc.table)
+		wg.Add(1)
+		go func(i int, tc tableCopy) {
+			defer wg.Done()
+			start := time.Now()
+			count, err := copyToSideDB(ctx, pg, sidePaths[i], tc, scope)
+			if err != nil {
+				failOnce.Do(func() {
+					firstErr = fmt.Errorf("failed to copy %s: %w", tc.table, err)
+					cancel()
+				})
+				return
+			}
+			counts[i] = count
+			appLog.Info("Copied %d %s in %s", counts[i], tc.table, time.Since(start))
+		}(i, tc)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	mergeStart := time.Now()
+	if err := mergeSideDBs(ctx, sqliteDB, copies, sidePaths, scope.since != nil); err != nil {
+		return nil, err
+	}
+	appLog.Debug("Merged table copies in %s", time.Since(mergeStart))
+
+	copied := make(map[string]int, len(copies))
+	for i, tc := range copies {
+		copied[tc.table] = counts[i]
+	}
+	return copied, nil
+}
+
+// copyToSideDB copies one table into a fresh SQLite database at path
+func copyToSideDB(ctx context.Context, pg Querier, path string, tc tableCopy, scope copyScope) (int, error) {
+	db, err := sql.Open("sqlite", buildDSN(path))
+	if err != nil {
+		return 0, fmt.Errorf("opening side database: %w", err)
+	}
+	defer db.Close()
+
+	if err := createSQLiteTables(db); err != nil {
+		return 0, err
+	}
+	return tc.copy(ctx, pg, db, scope)
+}
+
+// mergeSideDBs copies every table from the side databases into sqliteDB. Incremental
+// builds replace existing rows; full builds start from empty tables.
+func mergeSideDBs(ctx context.Context, sqliteDB *sql.DB, copies []tableCopy, sidePaths []string, replace bool) error {
+	// ATTACH is per connection, so pin one for the whole merge
+	conn, err := sqliteDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting SQLite connection: %w", err)
+	}
+	defer conn.Close()
+
+	insertVerb := "INSERT"
+	if replace {
+		insertVerb = "INSERT OR REPLACE"
+	}
+
+	for i, tc := range copies {
+		if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS side`, sidePaths[i]); err != nil {
+			return fmt.Errorf("attaching %s copy: %w", tc.table, err)
+		}
+		_, err := conn.ExecContext(ctx, insertVerb+` INTO main.`+tc.table+` SELECT * FROM side.`+tc.table)
+		if err == nil {
+			err = mergeFTS(ctx, conn, tc.table, replace)
+		}
+		if _, detachErr := conn.ExecContext(context.Background(), `DETACH DATABASE side`); err == nil && detachErr != nil {
+			err = detachErr
+		}
+		if err != nil {
+			return fmt.Errorf("merging %s: %w", tc.table, err)
+		}
+	}
+	return nil
+}
+
+// mergeFTS copies a table's search rows from the attached side database. Search tables
+// have no unique key, so replaced rows' old entries are deleted first.
+func mergeFTS(ctx context.Context, conn *sql.Conn, table string, replace bool) error {
+	fts, ok := ftsTable[table]
+	if !ok || !ftsAvailable {
+		return nil
+	}
+	if replace {
+		_, err := conn.ExecContext(ctx, `DELETE FROM main.`+fts.name+` WHERE `+fts.key+` IN (SELECT `+fts.key+` FROM side.`+fts.name+`)`)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := conn.ExecContext(ctx, `INSERT INTO main.`+fts.name+` SELECT * FROM side.`+fts.name)
+	return err
+}
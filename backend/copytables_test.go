@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withTableCopies swaps the table copy functions for the duration of a test
+func withTableCopies(t *testing.T, projects, mentions func(context.Context, Querier, *sql.DB, copyScope) (int, error)) {
+	t.Helper()
+	prev := tableCopies
+	tableCopies = []tableCopy{
+		{table: "approved_projects", copy: projects},
+		{table: "ysws_project_mentions", copy: mentions},
+	}
+	t.Cleanup(func() { tableCopies = prev })
+}
+
+func openGeneratedDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "generated.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("opening SQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+	return db, path
+}
+
+func TestCopyTablesMergesSideDatabases(t *testing.T) {
+	withTableCopies(t,
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			_, err := db.ExecContext(ctx, `INSERT INTO approved_projects (record_id) VALUES ('rec1'), ('rec2')`)
+			return 2, err
+		},
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			_, err := db.ExecContext(ctx, `INSERT INTO ysws_project_mentions (id) VALUES ('m1')`)
+			return 1, err
+		},
+	)
+	db, path := openGeneratedDB(t)
+
+	copied, err := copyTables(context.Background(), nil, db, path, copyScope{cfg: testConfig}, tableCopies)
+	if err != nil {
+		t.Fatalf("copyTables() error: %v", err)
+	}
+	if copied["approved_projects"] != 2 || copied["ysws_project_mentions"] != 1 {
+		t.Errorf("copyTables() = %v, want 2 projects and 1 mention", copied)
+	}
+	if n, _ := countRows(db, "approved_projects"); n != 2 {
+		t.Errorf("approved_projects has %d rows after merge, want 2", n)
+	}
+	if n, _ := countRows(db, "ysws_project_mentions"); n != 1 {
+		t.Errorf("ysws_project_mentions has %d rows after merge, want 1", n)
+	}
+}
+
+func TestCopyTablesCancelsOnFailure(t *testing.T) {
+	withTableCopies(t,
+		func(context.Context, Querier, *sql.DB, copyScope) (int, error) {
+			return 0, errors.New("connection reset")
+		},
+		func(ctx context.Context, _ Querier, _ *sql.DB, _ copyScope) (int, error) {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(5 * time.Second):
+				return 0, errors.New("not cancelled")
+			}
+		},
+	)
+	db, path := openGeneratedDB(t)
+
+	start := time.Now()
+	_, err := copyTables(context.Background(), nil, db, path, copyScope{cfg: testConfig}, tableCopies)
+	if err == nil || !strings.Contains(err.Error(), "approved_projects: connection reset") {
+		t.Errorf("copyTables() error = %v, want the approved_projects failure", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("copyTables() took %s; the other copy wasn't cancelled", elapsed)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyTimingLaps(t *testing.T) {
+	timing := &copyTiming{last: time.Now().Add(-2 * time.Second)}
+	timing.lap(&timing.query)
+	timing.last = timing.last.Add(-time.Second)
+	timing.lap(&timing.read)
+	timing.last = timing.last.Add(-time.Second)
+	timing.lap(&timing.read)
+
+	if timing.query < 2*time.Second || timing.read < 2*time.Second || timing.read > 3*time.Second || timing.insert != 0 {
+		t.Errorf("query %s, read %s, insert %s; want about 2s, 2s, 0", timing.query, timing.read, timing.insert)
+	}
+}
+
+func TestCopyApprovedProjectsLogsTiming(t *testing.T) {
+	var logs bytes.Buffer
+	prevOutput := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prevOutput) })
+
+	pg, mock := newMockPostgres(t)
+	mock.ExpectQuery(`FROM \S+\.approved_projects ap`).WillReturnRows(approvedProjectRows().
+		AddRow("rec1", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil))
+	db, _ := openGeneratedDB(t)
+
+	if _, err := copyApprovedProjects(context.Background(), pg, db, copyScope{cfg: testConfig}); err != nil {
+		t.Fatalf("copyApprovedProjects() error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "Copy of 1 approved_projects: Postgres query") {
+		t.Errorf("logs = %q, want the copy's timing", logs.String())
+	}
+}
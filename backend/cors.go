@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// corsAllowedOrigins holds the origins from CORS_ALLOWED_ORIGINS. When nil, any origin
+// is allowed with a wildcard, as before the allowlist existed.
+var corsAllowedOrigins map[string]bool
+
+const (
+	corsAllowedMethods = "GET, HEAD, POST, OPTIONS"
+	corsAllowedHeaders = "Content-Type, Authorization, X-API-Key, X-Request-ID"
+)
+
+// parseAllowedOrigins parses CORS_ALLOWED_ORIGINS: comma-separated origins such as
+// https://explorer.hackclub.com, compared exactly (scheme, host and port)
+func parseAllowedOrigins(value string) (map[string]bool, error) {
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return nil, fmt.Errorf("origin %q must start with http:// or https://", origin)
+		}
+		origins[strings.ToLower(origin)] = true
+	}
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("no origins given")
+	}
+	return origins, nil
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request's Origin,
+// or "" if it may not make cross-origin requests
+func allowedOrigin(origin string) string {
+	if corsAllowedOrigins == nil {
+		return "*"
+	}
+	if origin != "" && corsAllowedOrigins[strings.ToLower(origin)] {
+		return origin
+	}
+	return ""
+}
+
+// corsMethodAllowed reports whether a preflight's Access-Control-Request-Method is one we serve
+func corsMethodAllowed(method string) bool {
+	for _, allowed := range strings.Split(corsAllowedMethods, ", ") {
+		if method == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware adds CORS headers for allowed origins and answers preflight requests.
+// Requests from other origins get no CORS headers, so browsers refuse to expose the response.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowOrigin := allowedOrigin(origin)
+		if corsAllowedOrigins != nil {
+			// The response depends on the Origin, so caches must key on it
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if allowOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		}
+
+		if r.Method == http.MethodOptions {
+			// A preflight names the method the real request will use
+			if requested := r.Header.Get("Access-Control-Request-Method"); requested != "" {
+				if allowOrigin == "" || !corsMethodAllowed(requested) {
+					w.Header().Del("Access-Control-Allow-Origin")
+					w.Header().Del("Access-Control-Expose-Headers")
+					writeJSONError(w, http.StatusForbidden, "Forbidden: CORS preflight rejected")
+					return
+				}
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func withAllowedOrigins(t *testing.T, value string) {
+	t.Helper()
+	prev := corsAllowedOrigins
+	corsAllowedOrigins = nil
+	if value != "" {
+		origins, err := parseAllowedOrigins(value)
+		if err != nil {
+			t.Fatalf("parseAllowedOrigins() error: %v", err)
+		}
+		corsAllowedOrigins = origins
+	}
+	t.Cleanup(func() { corsAllowedOrigins = prev })
+}
+
+func TestParseAllowedOrigins(t *testing.T) {
+	origins, err := parseAllowedOrigins(" https://explorer.hackclub.com/, http://localhost:5173 ,")
+	if err != nil {
+		t.Fatalf("parseAllowedOrigins() error: %v", err)
+	}
+	if len(origins) != 2 || !origins["https://explorer.hackclub.com"] || !origins["http://localhost:5173"] {
+		t.Errorf("parseAllowedOrigins() = %v", origins)
+	}
+
+	for _, bad := range []string{"", " , ", "explorer.hackclub.com"} {
+		if _, err := parseAllowedOrigins(bad); err == nil {
+			t.Errorf("parseAllowedOrigins(%q) expected an error", bad)
+		}
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	withAllowedOrigins(t, "https://explorer.hackclub.com")
+	handler := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		preflight   string
+		wantStatus  int
+		wantAllowed string
+	}{
+		{"allowed origin", "GET", "https://explorer.hackclub.com", "", 200, "https://explorer.hackclub.com"},
+		{"other origin", "GET", "https://evil.example", "", 200, ""},
+		{"no origin", "GET", "", "", 200, ""},
+		{"preflight", "OPTIONS", "https://explorer.hackclub.com", "POST", 204, "https://explorer.hackclub.com"},
+		{"preflight for unsupported method", "OPTIONS", "https://explorer.hackclub.com", "DELETE", 403, ""},
+		{"preflight from other origin", "OPTIONS", "https://evil.example", "GET", 403, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/db", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.preflight != "" {
+			req.Header.Set("Access-Control-Request-Method", tt.preflight)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllowed {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.name, got, tt.wantAllowed)
+		}
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("%s: Vary = %q, want Origin", tt.name, got)
+		}
+	}
+}
+
+func TestCORSMiddlewareDefaultsToWildcard(t *testing.T) {
+	withAllowedOrigins(t, "")
+	handler := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+}
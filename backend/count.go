@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// countResponse is the body returned by GET /count
+type countResponse struct {
+	ApprovedProjects int `json:"approved_projects"`
+	Mentions         int `json:"ysws_project_mentions"`
+}
+
+// countHandler returns the row counts of the cached database, whatever its age. It never
+// queries Postgres: without a cached database it returns 503, unless ?generate=true asks
+// it to generate one like /stats would.
+func countHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry, ok := fullCache.Get()
+		if ok {
+			if _, err := os.Stat(entry.path); err != nil {
+				ok = false
+			}
+		}
+
+		path := entry.path
+		if !ok {
+			if !strings.EqualFold(r.URL.Query().Get("generate"), "true") {
+				w.Header().Set("Retry-After", "60")
+				writeJSONError(w, http.StatusServiceUnavailable, "Service Unavailable: no database has been generated yet")
+				return
+			}
+
+			var err error
+			if path, err = ensureDB(cfg); err != nil {
+				requestLog(r).Error("Failed to prepare database for count: %v", err)
+				writeGenerationFailure(w, err)
+				return
+			}
+		}
+
+		db, release, err := openQueryDB(path)
+		if err != nil {
+			requestLog(r).Error("Failed to open database for count: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		defer release()
+
+		var resp countResponse
+		err = retryIfBusy(requestLog(r), func() (err error) {
+			if resp.ApprovedProjects, err = countRows(db, "approved_projects"); err != nil {
+				return err
+			}
+			resp.Mentions, err = countRows(db, "ysws_project_mentions")
+			return err
+		})
+		if err != nil {
+			requestLog(r).Error("Failed to count rows: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
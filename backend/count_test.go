@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCountHandler(t *testing.T) {
+	withCachedDatabase(t, buildTestDatabase(t,
+		`INSERT INTO approved_projects (record_id) VALUES ('rec1'), ('rec2')`,
+		`INSERT INTO ysws_project_mentions (id, ysws_approved_project) VALUES ('m1', 'rec1')`,
+	))
+	t.Cleanup(closeQueryDB)
+
+	// An expired database is still counted rather than regenerated
+	entry, _ := fullCache.Get()
+	entry.createdAt = time.Now().Add(-cacheTTL - time.Minute)
+	fullCache.Set(entry)
+
+	prevRegenerate := regenerate
+	t.Cleanup(func() { regenerate = prevRegenerate })
+	regenerate = func(*Config) (string, error) {
+		t.Error("/count triggered a generation")
+		return "", nil
+	}
+
+	rec := httptest.NewRecorder()
+	countHandler(testConfig)(rec, httptest.NewRequest("GET", "/count", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var got countResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if want := (countResponse{ApprovedProjects: 2, Mentions: 1}); got != want {
+		t.Errorf("count = %+v, want %+v", got, want)
+	}
+}
+
+func TestCountHandlerWithoutCache(t *testing.T) {
+	withCacheEntry(t, cacheEntry{})
+
+	prevRegenerate := regenerate
+	t.Cleanup(func() { regenerate = prevRegenerate })
+	generated := false
+	regenerate = func(*Config) (string, error) {
+		generated = true
+		return "", errGenerationTimeout
+	}
+
+	rec := httptest.NewRecorder()
+	countHandler(testConfig)(rec, httptest.NewRequest("GET", "/count", nil))
+	if rec.Code != 503 || rec.Header().Get("Retry-After") == "" || generated {
+		t.Errorf("status = %d, Retry-After %q, generated %v; want 503 with Retry-After and no generation",
+			rec.Code, rec.Header().Get("Retry-After"), generated)
+	}
+
+	rec = httptest.NewRecorder()
+	countHandler(testConfig)(rec, httptest.NewRequest("GET", "/count?generate=true", nil))
+	if !generated {
+		t.Error("/count?generate=true didn't generate a database")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// conditionalGenerationEnabled (CONDITIONAL_GENERATION=true) checks a cheap data version
+// before regenerating an expired database, and keeps the cached one for another TTL if
+// Postgres hasn't changed since it was built. Edits to existing rows that don't move the
+// newest timestamp or a count go unnoticed until something else changes.
+var conditionalGenerationEnabled bool
+
+// dataVersionQuery summarizes both copied tables by their newest timestamp and row count
+const dataVersionQuery = `SELECT
+		(SELECT MAX(approved_at)::text FROM {schema}.approved_projects),
+		(SELECT COUNT(*) FROM {schema}.approved_projects),
+		(SELECT MAX(date)::text FROM {schema}.ysws_project_mentions),
+		(SELECT COUNT(*) FROM {schema}.ysws_project_mentions)`
+
+// queryDataVersion returns the current data version in pg, as an opaque string that
+// changes whenever rows are added, removed, or get a newer timestamp
+func queryDataVersion(pg Querier) (string, error) {
+	ctx, cancel := generationContext()
+	defer cancel()
+
+	rows, err := pg.QueryContext(ctx, inSchema(dataVersionQuery))
+	if err != nil {
+		return "", fmt.Errorf("querying data version: %w", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", fmt.Errorf("reading data version: %w", err)
+		}
+		return "", fmt.Errorf("data version query returned no rows")
+	}
+
+	var approvedAt, mentionDate sql.NullString
+	var projects, mentions int64
+	if err := rows.Scan(&approvedAt, &projects, &mentionDate, &mentions); err != nil {
+		return "", fmt.Errorf("reading data version: %w", err)
+	}
+	return fmt.Sprintf("approved_projects %d up to %q, ysws_project_mentions %d up to %q",
+		projects, approvedAt.String, mentions, mentionDate.String), nil
+}
+
+// extendIfUnchanged keeps previous as the cached database for another TTL if it was built
+// from the given data version and its file is still there, returning its path
+func extendIfUnchanged(previous cacheEntry, version string) (string, bool) {
+	if previous.path == "" || previous.dataVersion == "" || previous.dataVersion != version {
+		return "", false
+	}
+	if _, err := os.Stat(previous.path); err != nil {
+		return "", false
+	}
+
+	previous.createdAt = time.Now()
+	fullCache.Set(previous)
+	if err := saveCacheMetadata(cacheDir, previous); err != nil {
+		appLog.Warn("Failed to save cache metadata, the cache won't survive a restart: %v", err)
+	}
+	appLog.Info("Data unchanged since the last generation (%s), keeping the cached database", version)
+	return previous.path, true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// dataVersionRows are rows shaped like dataVersionQuery's result
+func dataVersionRows(approvedAt interface{}, projects int, mentionDate interface{}, mentions int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"max", "count", "max", "count"}).AddRow(approvedAt, projects, mentionDate, mentions)
+}
+
+func TestQueryDataVersion(t *testing.T) {
+	pg, mock := newMockPostgres(t)
+	mock.ExpectQuery(`MAX\(approved_at\)`).WillReturnRows(dataVersionRows("2024-06-01 10:00:00", 12, "2024-06-02", 40))
+	mock.ExpectQuery(`MAX\(approved_at\)`).WillReturnRows(dataVersionRows("2024-06-01 10:00:00", 13, "2024-06-02", 40))
+	mock.ExpectQuery(`MAX\(approved_at\)`).WillReturnRows(dataVersionRows(nil, 0, nil, 0))
+
+	first, err := queryDataVersion(pg)
+	if err != nil || !strings.Contains(first, "2024-06-01 10:00:00") {
+		t.Fatalf("queryDataVersion() = %q, %v; want a version naming the newest approval", first, err)
+	}
+	// One more row changes the version even with the same newest timestamp
+	if second, err := queryDataVersion(pg); err != nil || second == first {
+		t.Errorf("queryDataVersion() after a new row = %q, %v; want it to differ from %q", second, err, first)
+	}
+	// Empty tables have NULL maxima
+	if _, err := queryDataVersion(pg); err != nil {
+		t.Errorf("queryDataVersion() on empty tables: %v", err)
+	}
+}
+
+func TestConditionalGenerationKeepsUnchangedDatabase(t *testing.T) {
+	pg, mock := newMockPostgres(t)
+	prevPG, prevEnabled, prevDir := pgDB, conditionalGenerationEnabled, cacheDir
+	pgDB, conditionalGenerationEnabled, cacheDir = pg.(*sql.DB), true, t.TempDir()
+	t.Cleanup(func() { pgDB, conditionalGenerationEnabled, cacheDir = prevPG, prevEnabled, prevDir })
+
+	// The cached database was built from the data Postgres still has
+	mock.ExpectQuery(`MAX\(approved_at\)`).WillReturnRows(dataVersionRows("2024-06-01", 12, "2024-06-02", 40))
+	mock.ExpectQuery(`MAX\(approved_at\)`).WillReturnRows(dataVersionRows("2024-06-01", 12, "2024-06-02", 40))
+	version, err := queryDataVersion(pg)
+	if err != nil {
+		t.Fatalf("queryDataVersion() error: %v", err)
+	}
+	path := withCachedFile(t, "expired-database", cacheTTL+time.Minute)
+	entry, _ := fullCache.Get()
+	entry.dataVersion = version
+	fullCache.Set(entry)
+
+	got, err := generateDBIfOlderThan(testConfig, cacheTTL)
+	if err != nil || got != path {
+		t.Fatalf("generateDBIfOlderThan(testConfig, ) = %q, %v; want the cached %q kept", got, err, path)
+	}
+	if _, ok := getCachedDB(); !ok {
+		t.Error("the kept database is still expired")
+	}
+	if sidecar, err := os.ReadFile(filepath.Join(cacheDir, cacheMetadataFile)); err != nil || !strings.Contains(string(sidecar), `"data_version"`) {
+		t.Errorf("sidecar = %s, %v; want the kept entry with its data version", sidecar, err)
+	}
+}
+
+func TestExtendIfUnchangedNeedsMatchingVersion(t *testing.T) {
+	prevDir := cacheDir
+	cacheDir = t.TempDir()
+	t.Cleanup(func() { cacheDir = prevDir })
+	path := withCachedFile(t, "expired-database", cacheTTL+time.Minute)
+
+	for _, previous := range []cacheEntry{
+		{path: path, dataVersion: "v1"},
+		{path: path},
+		{path: path + ".missing", dataVersion: "v2"},
+	} {
+		if _, ok := extendIfUnchanged(previous, "v2"); ok {
+			t.Errorf("extendIfUnchanged(%+v, v2) kept the database", previous)
+		}
+	}
+	if _, ok := getCachedDB(); ok {
+		t.Error("a refused extension refreshed the cache")
+	}
+}
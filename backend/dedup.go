@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// dedupMentionsEnabled (DEDUP_MENTIONS=true) collapses mentions of the same project that
+// share a normalized URL, which otherwise count the same coverage more than once
+var dedupMentionsEnabled bool
+
+// dedupMentions deletes all but one mention per (ysws_approved_project, url), keeping the
+// one with the highest weighted_engagement_points (ties go to the lowest id), and returns
+// how many rows were removed. URLs are already normalized by scanProjectMention.
+//
+// It runs on the merged table rather than inside copyProjectMentions, so incremental
+// generations also catch new rows duplicating ones from the previous database.
+func dedupMentions(db *sql.DB) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("deduplicating mentions: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		DELETE FROM ysws_project_mentions WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (
+					PARTITION BY ysws_approved_project, url
+					ORDER BY weighted_engagement_points DESC, id
+				) AS rank
+				FROM ysws_project_mentions
+				WHERE ysws_approved_project IS NOT NULL AND url IS NOT NULL
+			)
+			WHERE rank > 1
+		)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("deduplicating mentions: %w", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("deduplicating mentions: %w", err)
+	}
+
+	// Keep the search table in step with the rows that are left
+	if ftsAvailable && removed > 0 {
+		_, err := tx.Exec(`DELETE FROM mentions_fts WHERE id NOT IN (SELECT id FROM ysws_project_mentions)`)
+		if err != nil {
+			return 0, fmt.Errorf("deduplicating mentions_fts: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("deduplicating mentions: %w", err)
+	}
+	return int(removed), nil
+}
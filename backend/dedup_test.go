@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestDedupMentionsKeepsHighestEngagement(t *testing.T) {
+	db := openTestSQLite(t)
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO ysws_project_mentions (id, ysws_approved_project, url, weighted_engagement_points) VALUES
+			('m1', 'rec1', 'https://news.example/story', 5),
+			('m2', 'rec1', 'https://news.example/story', 12),
+			('m3', 'rec1', 'https://news.example/story', NULL),
+			('m4', 'rec2', 'https://news.example/story', 1),
+			('m5', 'rec1', 'https://other.example/post', 3),
+			('m6', NULL, 'https://news.example/story', 1),
+			('m7', 'rec1', NULL, 1),
+			('m8', 'rec1', NULL, 2)
+	`)
+	if err != nil {
+		t.Fatalf("inserting mentions: %v", err)
+	}
+	if ftsAvailable {
+		if _, err := db.Exec(`INSERT INTO mentions_fts (id, headline) SELECT id, id FROM ysws_project_mentions`); err != nil {
+			t.Fatalf("filling mentions_fts: %v", err)
+		}
+	}
+
+	removed, err := dedupMentions(db)
+	if err != nil {
+		t.Fatalf("dedupMentions() error: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("dedupMentions() removed %d rows, want 2", removed)
+	}
+
+	want := []string{"m2", "m4", "m5", "m6", "m7", "m8"}
+	if got := queryIDs(t, db, `SELECT id FROM ysws_project_mentions ORDER BY id`); !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining mentions = %v, want %v", got, want)
+	}
+	if ftsAvailable {
+		if got := queryIDs(t, db, `SELECT id FROM mentions_fts ORDER BY id`); !reflect.DeepEqual(got, want) {
+			t.Errorf("remaining mentions_fts rows = %v, want %v", got, want)
+		}
+	}
+}
+
+// queryIDs returns the single text column of every row the query returns
+func queryIDs(t *testing.T, db *sql.DB, query string) []string {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("querying %q: %v", query, err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("scanning: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// derivedCache keeps a re-encoded copy of each cached zstd database that's been asked for
+// in a format the .zst file can't be sent as (Brotli, or zstd with the dictionary). The
+// copy is built on the first such request and reused until its source is replaced, so
+// nothing is recompressed per request.
+type derivedCache struct {
+	name     string // the encoding, for logs
+	build    func(log *Logger, source string) (string, error)
+	mu       sync.Mutex
+	paths    map[string]string // zstd source → its derived file
+	building map[string]bool   // sources with a build in flight; false once Remove drops one
+	group    singleflight.Group
+}
+
+func newDerivedCache(name string, build func(log *Logger, source string) (string, error)) *derivedCache {
+	return &derivedCache{name: name, build: build, paths: map[string]string{}, building: map[string]bool{}}
+}
+
+// removeDerivedFiles drops every copy built from source, when source itself is being deleted
+func removeDerivedFiles(source string) {
+	brotliFiles.Remove(source)
+	dictZstdFiles.Remove(source)
+}
+
+// Lookup returns the file built from source, if it's been built and is still there
+func (c *derivedCache) Lookup(source string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path, ok := c.paths[source]
+	if !ok {
+		return "", false
+	}
+	if _, err := os.Stat(path); err != nil {
+		delete(c.paths, source)
+		return "", false
+	}
+	return path, true
+}
+
+// Get returns the file built from source, building it first if needed and logging the
+// build to log. Concurrent requests for the same source share one build.
+func (c *derivedCache) Get(log *Logger, source string) (string, error) {
+	if path, ok := c.Lookup(source); ok {
+		return path, nil
+	}
+	path, err, _ := c.group.Do(source, func() (interface{}, error) {
+		if path, ok := c.Lookup(source); ok {
+			return path, nil
+		}
+		c.mu.Lock()
+		c.building[source] = true
+		c.mu.Unlock()
+
+		path, err := c.build(log, source)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		current := c.building[source]
+		delete(c.building, source)
+		if err != nil {
+			return "", err
+		}
+		if !current {
+			// The source was dropped while we built from it: serve this request, but
+			// don't keep the file past the grace period its source gets
+			removeAfterGrace(path)
+			return path, nil
+		}
+		c.paths[source] = path
+		c.pruneLocked()
+		return path, nil
+	})
+	return path.(string), err
+}
+
+// Open returns the file built from source opened for reading, building it first if
+// needed. If the file is removed between being looked up and opened, it's looked up
+// once more.
+func (c *derivedCache) Open(log *Logger, source string) (*os.File, error) {
+	for attempt := 1; ; attempt++ {
+		path, err := c.Get(log, source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(path)
+		if err == nil || attempt == 2 || !os.IsNotExist(err) {
+			return file, err
+		}
+	}
+}
+
+// Remove drops the file built from source, when source itself is being deleted, and
+// deletes it after fileRemovalGrace like its source. A build from source that's still
+// running is discarded when it finishes. Open readers keep their file handle.
+func (c *derivedCache) Remove(source string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if path, ok := c.paths[source]; ok {
+		removeAfterGrace(path)
+		delete(c.paths, source)
+	}
+	if _, ok := c.building[source]; ok {
+		c.building[source] = false
+	}
+}
+
+// pruneLocked drops the files whose source database has since been deleted. The caller
+// holds c.mu.
+func (c *derivedCache) pruneLocked() {
+	for source, path := range c.paths {
+		if _, err := os.Stat(source); os.IsNotExist(err) {
+			removeAfterGrace(path)
+			delete(c.paths, source)
+		}
+	}
+}
+
+// buildDerivedFile decompresses the zstd database at source and writes it through the
+// encoder newEncoder wraps around the output, to source's name with ext in place of .zst.
+// It's written under a temporary name and renamed into place, so that path only ever
+// holds a complete file. Nothing is left behind on error.
+func buildDerivedFile(source, ext string, newEncoder func(io.Writer) (io.WriteCloser, error)) (string, error) {
+	input, err := os.Open(source)
+	if err != nil {
+		return "", fmt.Errorf("opening compressed file: %w", err)
+	}
+	defer input.Close()
+
+	decoder, err := newZstdReader(input)
+	if err != nil {
+		return "", fmt.Errorf("creating zstd decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	// Named like the other cache files, so one left by a crash is cleaned up on startup
+	output, err := os.CreateTemp(filepath.Dir(source), "cached-db-*.db")
+	if err != nil {
+		return "", fmt.Errorf("creating file: %w", err)
+	}
+	tmpPath := output.Name()
+	encoder, err := newEncoder(output)
+	if err != nil {
+		output.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("creating encoder: %w", err)
+	}
+	if _, err := io.Copy(encoder, decoder); err != nil {
+		output.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("encoding database: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		output.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("finishing stream: %w", err)
+	}
+	if err := output.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("closing file: %w", err)
+	}
+
+	path := strings.TrimSuffix(source, ".zst") + ext
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("renaming file: %w", err)
+	}
+	return path, nil
+}
+
+// serveDerivedDB sends the copy of the database files builds from compressedPath. The
+// first request for a database builds it, before taking a download slot so the build
+// doesn't hold one; later ones send it straight from disk.
+func serveDerivedDB(w http.ResponseWriter, r *http.Request, files *derivedCache, compressedPath, format, disposition string, requestStart time.Time) {
+	file, err := files.Open(requestLog(r), compressedPath)
+	if err != nil {
+		requestLog(r).Error("Failed to open %s database: %v", files.name, err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	defer file.Close()
+
+	release, ok := acquireDownloadSlot()
+	if !ok {
+		writeDownloadsBusy(w, r)
+		return
+	}
+	defer release()
+
+	if err := setDBHeaders(w, compressedPath, format, disposition); err != nil {
+		requestLog(r).Error("Failed to stat file: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	// The length of the file actually being sent, whichever build it came from
+	if info, err := file.Stat(); err == nil {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+	}
+
+	bytesSent, err := sendFile(r.Context(), w, file)
+	if err != nil {
+		logStreamError(r, bytesSent, err)
+		return
+	}
+
+	requestLog(r).Info("%s database sent: %.2f MB in %s", files.name, float64(bytesSent)/(1024*1024), time.Since(requestStart))
+}
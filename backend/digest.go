@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// fileSHA256 returns the hex-encoded SHA-256 of the file at path
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening file to hash: %w", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("hashing file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// digestHeader formats a hex SHA-256 as an RFC 3230 Digest header value, which
+// carries the digest base64-encoded
+func digestHeader(hexDigest string) (string, error) {
+	sum, err := hex.DecodeString(hexDigest)
+	if err != nil {
+		return "", fmt.Errorf("invalid SHA-256 digest %q: %w", hexDigest, err)
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum), nil
+}
+
+// dbSHA256Handler serves the hex SHA-256 of the zstd-compressed database that /db
+// currently serves, generating the database first if needed. The digest covers the
+// .zst file, so it only applies to zstd downloads.
+func dbSHA256Handler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := ensureDB(cfg)
+		if err != nil {
+			requestLog(r).Error("Failed to prepare database for checksum: %v", err)
+			writeGenerationFailure(w, err)
+			return
+		}
+
+		entry, ok := fullCache.Lookup(path)
+		if !ok || entry.sha256 == "" {
+			requestLog(r).Error("No checksum recorded for cached database %s", path)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, entry.sha256)
+	}
+}
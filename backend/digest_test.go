@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSHA256(t *testing.T) {
+	contents := []byte("SQLite format 3\x00")
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, contents, 0o600); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+
+	sum := sha256.Sum256(contents)
+	got, err := fileSHA256(path)
+	if err != nil {
+		t.Fatalf("fileSHA256() error: %v", err)
+	}
+	if want := hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("fileSHA256() = %q, want %q", got, want)
+	}
+
+	header, err := digestHeader(got)
+	if err != nil {
+		t.Fatalf("digestHeader() error: %v", err)
+	}
+	if want := "sha-256=" + base64.StdEncoding.EncodeToString(sum[:]); header != want {
+		t.Errorf("digestHeader() = %q, want %q", header, want)
+	}
+	if _, err := digestHeader("not hex"); err == nil {
+		t.Error("digestHeader() accepted a non-hex digest")
+	}
+}
+
+func TestDBServesDigest(t *testing.T) {
+	withCachedDatabase(t, bytes.Repeat([]byte("SQLite format 3\x00 pretend database page "), 1000))
+	entry, _ := fullCache.Get()
+
+	rec := httptest.NewRecorder()
+	dbSHA256Handler(testConfig)(rec, httptest.NewRequest("GET", "/db.sha256", nil))
+	if rec.Code != 200 {
+		t.Fatalf("GET /db.sha256 status = %d, want 200", rec.Code)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != entry.sha256 {
+		t.Errorf("GET /db.sha256 body = %q, want %q", got, entry.sha256)
+	}
+
+	rec = httptest.NewRecorder()
+	dbHandler(testConfig)(rec, httptest.NewRequest("GET", "/db", nil))
+	compressed, err := os.ReadFile(entry.path)
+	if err != nil {
+		t.Fatalf("reading cached database: %v", err)
+	}
+	sum := sha256.Sum256(compressed)
+	if got, want := rec.Header().Get("Digest"), "sha-256="+base64.StdEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("GET /db Digest = %q, want %q", got, want)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), compressed) {
+		t.Error("GET /db body differs from the cached file")
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	dbHandler(testConfig)(rec, req)
+	if got := rec.Header().Get("Digest"); got != "" {
+		t.Errorf("gzip download sent Digest %q for the zstd file", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// errInsufficientDiskSpace is returned (wrapped) when a generation is refused because the
+// disk probably can't hold it
+var errInsufficientDiskSpace = errors.New("not enough free disk space to generate the database")
+
+// diskFree returns the bytes available to us on the filesystem holding dir; swapped out
+// in tests. ok is false where free space can't be queried.
+var diskFree = freeDiskSpace
+
+// lastBuildSizes are the sizes of the last database this process generated, the estimate
+// for the next one. Only read and written under generationMutex.
+var lastBuildSizes struct {
+	uncompressed int64
+	compressed   int64
+}
+
+// buildSpaceEstimate returns how much a generation needs in the build directory and in
+// the cache directory, based on the last generation (or the cached entry if this process
+// hasn't generated yet). The build directory needs room for the uncompressed file twice
+// over, as its WAL and VACUUM each can grow to about its size. Both are zero when there's
+// nothing to go on.
+func buildSpaceEstimate() (build, cache uint64) {
+	uncompressed, compressed := lastBuildSizes.uncompressed, lastBuildSizes.compressed
+	if uncompressed == 0 {
+		entry, _ := fullCache.Get()
+		uncompressed, compressed = entry.uncompressedSize, entry.compressedSize
+	}
+	return uint64(2 * uncompressed), uint64(compressed)
+}
+
+// checkDiskSpace returns an error wrapping errInsufficientDiskSpace if the build or cache
+// directory has less free space than a generation is estimated to need. When both are
+// the same directory, it needs room for both. Unknown estimates or free space pass.
+func checkDiskSpace(buildDir, cacheDir string) error {
+	build, cache := buildSpaceEstimate()
+	if build == 0 {
+		return nil
+	}
+
+	needs := map[string]uint64{filepath.Clean(buildDir): build}
+	needs[filepath.Clean(cacheDir)] += cache
+	for dir, needed := range needs {
+		free, ok, err := diskFree(dir)
+		if err != nil {
+			appLog.Warn("Couldn't check free disk space in %s: %v", dir, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		freeMB, neededMB := float64(free)/(1024*1024), float64(needed)/(1024*1024)
+		if free < needed {
+			appLog.Error("Refusing to generate: %.2f MB available in %s, about %.2f MB needed", freeMB, dir, neededMB)
+			return fmt.Errorf("%w: %.2f MB available in %s, about %.2f MB needed", errInsufficientDiskSpace, freeMB, dir, neededMB)
+		}
+		appLog.Info("Disk space in %s: %.2f MB available, about %.2f MB needed", dir, freeMB, neededMB)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// freeDiskSpace can't query free space on this platform, so the check is skipped
+func freeDiskSpace(dir string) (uint64, bool, error) {
+	return 0, false, nil
+}
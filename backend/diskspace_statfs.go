@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// freeDiskSpace returns the bytes available to unprivileged users on dir's filesystem
+func freeDiskSpace(dir string) (uint64, bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, false, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// withDiskFree reports free bytes per directory, and restores the real check afterwards
+func withDiskFree(t *testing.T, free map[string]uint64) {
+	t.Helper()
+	prev := diskFree
+	t.Cleanup(func() { diskFree = prev })
+	diskFree = func(dir string) (uint64, bool, error) { return free[dir], true, nil }
+}
+
+func withLastBuildSizes(t *testing.T, uncompressed, compressed int64) {
+	t.Helper()
+	prev := lastBuildSizes
+	t.Cleanup(func() { lastBuildSizes = prev })
+	lastBuildSizes.uncompressed, lastBuildSizes.compressed = uncompressed, compressed
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	withLastBuildSizes(t, 100, 20)
+	build, cache := filepath.Join(t.TempDir(), "work"), filepath.Join(t.TempDir(), "cache")
+
+	// Separate directories need the doubled uncompressed size and the compressed size
+	withDiskFree(t, map[string]uint64{build: 200, cache: 20})
+	if err := checkDiskSpace(build, cache); err != nil {
+		t.Errorf("checkDiskSpace() with just enough room = %v, want nil", err)
+	}
+	withDiskFree(t, map[string]uint64{build: 199, cache: 1 << 30})
+	if err := checkDiskSpace(build, cache); !errors.Is(err, errInsufficientDiskSpace) {
+		t.Errorf("checkDiskSpace() with a full build dir = %v, want errInsufficientDiskSpace", err)
+	}
+
+	// One directory needs room for both
+	withDiskFree(t, map[string]uint64{cache: 219})
+	if err := checkDiskSpace(cache, cache); !errors.Is(err, errInsufficientDiskSpace) {
+		t.Errorf("checkDiskSpace() sharing a directory = %v, want errInsufficientDiskSpace", err)
+	}
+
+	rec := httptest.NewRecorder()
+	writeGenerationFailure(rec, checkDiskSpace(cache, cache))
+	if rec.Code != 503 || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestCheckDiskSpaceWithoutEstimate(t *testing.T) {
+	withLastBuildSizes(t, 0, 0)
+	withCacheEntry(t, cacheEntry{})
+	withDiskFree(t, map[string]uint64{})
+
+	dir := t.TempDir()
+	if err := checkDiskSpace(dir, dir); err != nil {
+		t.Errorf("checkDiskSpace() before any generation = %v, want nil", err)
+	}
+}
+
+func TestFreeDiskSpace(t *testing.T) {
+	free, ok, err := freeDiskSpace(t.TempDir())
+	if err != nil {
+		t.Fatalf("freeDiskSpace() error: %v", err)
+	}
+	if ok && free == 0 {
+		t.Error("freeDiskSpace() reported no free space in the test's temp dir")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+)
+
+// downloadSlots bounds how many database downloads stream at once (MAX_CONCURRENT_DOWNLOADS),
+// so a burst of large downloads can't saturate the uplink. Each slot is held only while
+// bytes are sent: a request waiting on generation doesn't take one. When nil there's no limit.
+var downloadSlots chan struct{}
+
+// newDownloadSlots returns a semaphore for max concurrent downloads, or nil for no limit
+func newDownloadSlots(max int) chan struct{} {
+	if max <= 0 {
+		return nil
+	}
+	return make(chan struct{}, max)
+}
+
+// acquireDownloadSlot takes a download slot without waiting. The returned release must be
+// deferred by the caller, so the slot comes back however the handler ends, including when
+// the client goes away mid-stream.
+func acquireDownloadSlot() (release func(), ok bool) {
+	slots := downloadSlots
+	if slots == nil {
+		return func() {}, true
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		return nil, false
+	}
+}
+
+// writeDownloadsBusy rejects a download because every slot is taken
+func writeDownloadsBusy(w http.ResponseWriter, r *http.Request) {
+	requestLog(r).Warn("Rejected download: %d downloads already in progress", cap(downloadSlots))
+	w.Header().Set("Retry-After", "10")
+	writeJSONError(w, http.StatusServiceUnavailable, "Service Unavailable: too many downloads in progress")
+}
+
+// contextReader stops reading once ctx is done. Copying from it to a client ends within a
+// chunk of the client going away, instead of whenever a write to the dead connection fails.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// sendFile copies file to w. When w can read from it directly, as net/http's ResponseWriter
+// can with sendfile, the bytes don't pass through userspace and a client going away fails
+// the send. Other writers get the copy through contextReader.
+func sendFile(ctx context.Context, w io.Writer, file *os.File) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(file)
+	}
+	return io.Copy(w, contextReader{ctx, file})
+}
+
+// logStreamError logs a download that stopped partway, as a disconnect if the client went away
+func logStreamError(r *http.Request, bytesSent int64, err error) {
+	if r.Context().Err() != nil {
+		requestLog(r).Info("Client disconnected after %.2f MB sent", float64(bytesSent)/(1024*1024))
+		return
+	}
+	requestLog(r).Error("Error writing response: %v", err)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withDownloadSlots(t *testing.T, max int) {
+	t.Helper()
+	prev := downloadSlots
+	downloadSlots = newDownloadSlots(max)
+	t.Cleanup(func() { downloadSlots = prev })
+}
+
+// brokenClient is a response writer whose client has gone away
+type brokenClient struct {
+	header http.Header
+}
+
+func (b *brokenClient) Header() http.Header       { return b.header }
+func (b *brokenClient) WriteHeader(int)           {}
+func (b *brokenClient) Write([]byte) (int, error) { return 0, errors.New("connection reset by peer") }
+
+func TestServeDBRejectsWhenDownloadsFull(t *testing.T) {
+	withDownloadSlots(t, 1)
+	path := withCachedFile(t, "cached-database", 0)
+
+	release, ok := acquireDownloadSlot()
+	if !ok {
+		t.Fatal("acquireDownloadSlot() failed with a free slot")
+	}
+
+	rec := httptest.NewRecorder()
+	serveDB(rec, httptest.NewRequest("GET", "/db", nil), path, formatZstd, "attachment", time.Now())
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	release()
+	rec = httptest.NewRecorder()
+	serveDB(rec, httptest.NewRequest("GET", "/db", nil), path, formatZstd, "attachment", time.Now())
+	if rec.Code != http.StatusOK || rec.Body.String() != "cached-database" {
+		t.Errorf("after release: status = %d, body %q; want the database", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServeDBReleasesSlotWhenClientDisconnects(t *testing.T) {
+	withDownloadSlots(t, 1)
+	path := withCachedFile(t, "cached-database", 0)
+
+	serveDB(&brokenClient{header: http.Header{}}, httptest.NewRequest("GET", "/db", nil), path, formatZstd, "attachment", time.Now())
+
+	release, ok := acquireDownloadSlot()
+	if !ok {
+		t.Fatal("slot still held after the client disconnected")
+	}
+	release()
+}
+
+func TestDownloadSlotsUnlimitedByDefault(t *testing.T) {
+	withDownloadSlots(t, 0)
+	for i := 0; i < 100; i++ {
+		if _, ok := acquireDownloadSlot(); !ok {
+			t.Fatalf("acquireDownloadSlot() failed after %d downloads with no limit", i)
+		}
+	}
+}
+
+// cancelingClient cancels the request's context once it has received its first write,
+// like a client that goes away mid-download
+type cancelingClient struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (c cancelingClient) Write(p []byte) (int, error) {
+	defer c.cancel()
+	return c.ResponseRecorder.Write(p)
+}
+
+func TestServeCachedDBStopsWhenContextCanceled(t *testing.T) {
+	path := withCachedFile(t, strings.Repeat("x", 1<<20), 0)
+
+	var logs bytes.Buffer
+	prevOutput := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prevOutput) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := cancelingClient{httptest.NewRecorder(), cancel}
+	serveCachedDB(client, httptest.NewRequest("GET", "/db", nil).WithContext(ctx), path, "attachment", time.Now())
+
+	if sent := client.Body.Len(); sent == 0 || sent >= 1<<20 {
+		t.Errorf("sent %d bytes, want the copy to stop after the first chunk", sent)
+	}
+	if !strings.Contains(logs.String(), "Client disconnected") || strings.Contains(logs.String(), "[ERROR]") {
+		t.Errorf("logs = %q, want a client disconnect rather than an error", logs.String())
+	}
+}
+
+// fileReadingClient is a response writer that can read from a file directly, like
+// net/http's, recording what it was handed
+type fileReadingClient struct {
+	*httptest.ResponseRecorder
+	readFrom io.Reader
+}
+
+func (c *fileReadingClient) ReadFrom(r io.Reader) (int64, error) {
+	c.readFrom = r
+	return io.Copy(c.ResponseRecorder, r)
+}
+
+func TestServeCachedDBHandsFileToReaderFrom(t *testing.T) {
+	path := withCachedFile(t, "cached-database", 0)
+
+	client := &fileReadingClient{ResponseRecorder: httptest.NewRecorder()}
+	serveCachedDB(&responseWrapper{ResponseWriter: client}, httptest.NewRequest("GET", "/db", nil), path, "attachment", time.Now())
+
+	if _, ok := client.readFrom.(*os.File); !ok {
+		t.Errorf("ReadFrom got %T, want the *os.File so net/http can use sendfile", client.readFrom)
+	}
+	if client.Body.String() != "cached-database" {
+		t.Errorf("body = %q, want the database", client.Body.String())
+	}
+}
+
+// BenchmarkServeCachedDB downloads a 64 MB file over loopback TCP, behind responseWrapper
+// like every production request, comparing a copy through contextReader, http.ServeContent,
+// and serveCachedDB
+func BenchmarkServeCachedDB(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "bench.db.zst")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("0123456789abcdef"), 4<<20), 0o600); err != nil {
+		b.Fatal(err)
+	}
+	prevOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(prevOutput) })
+
+	handlers := map[string]http.HandlerFunc{
+		"contextReader": func(w http.ResponseWriter, r *http.Request) {
+			file, _ := os.Open(path)
+			defer file.Close()
+			w.Header().Set("Content-Length", strconv.Itoa(64<<20))
+			io.Copy(w, contextReader{r.Context(), file})
+		},
+		"ServeContent": func(w http.ResponseWriter, r *http.Request) {
+			file, _ := os.Open(path)
+			defer file.Close()
+			http.ServeContent(w, r, "", time.Time{}, file)
+		},
+		"serveCachedDB": func(w http.ResponseWriter, r *http.Request) {
+			serveCachedDB(w, r, path, "attachment", time.Now())
+		},
+	}
+	for _, name := range []string{"contextReader", "ServeContent", "serveCachedDB"} {
+		handler := handlers[name]
+		b.Run(name, func(b *testing.B) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler(&responseWrapper{ResponseWriter: w, statusCode: http.StatusOK}, r)
+			}))
+			defer server.Close()
+
+			b.SetBytes(64 << 20)
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(server.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// dryRunReport is what a dry run prints: enough for CI to assert on the generated data
+type dryRunReport struct {
+	ApprovedProjects int     `json:"approved_projects"`
+	Mentions         int     `json:"ysws_project_mentions"`
+	CompressedSize   int64   `json:"compressed_size"`
+	UncompressedSize int64   `json:"uncompressed_size"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	SHA256           string  `json:"sha256"`
+	Output           string  `json:"output,omitempty"`
+}
+
+// runDryRun generates the database once, writes it to out (if set), and prints a JSON
+// report to report. An out path ending in ".zst" gets the compressed file; anything else
+// gets the plain SQLite database. It builds in a temporary directory of its own, so the
+// cache a running server may share CACHE_DIR with is left alone.
+func runDryRun(cfg *Config, out string, report io.Writer) error {
+	dir, err := os.MkdirTemp("", "viral-project-explorer-dry-run-*")
+	if err != nil {
+		return fmt.Errorf("creating build directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	entry, err := buildAndCompress(cfg, dir, dir, cacheEntry{}, nil)
+	if err != nil {
+		return fmt.Errorf("generating database: %w", err)
+	}
+
+	if out != "" {
+		if strings.HasSuffix(out, ".zst") {
+			err = copyFile(entry.path, out)
+		} else {
+			err = decompressZstdFile(entry.path, out)
+		}
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", out, err)
+		}
+	}
+
+	encoder := json.NewEncoder(report)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dryRunReport{
+		ApprovedProjects: entry.projectCount,
+		Mentions:         entry.mentionCount,
+		CompressedSize:   entry.compressedSize,
+		UncompressedSize: entry.uncompressedSize,
+		CompressionRatio: entry.compressionRatio,
+		SHA256:           entry.sha256,
+		Output:           out,
+	})
+}
+
+// copyFile copies the file at src to dst, replacing dst
+func copyFile(src, dst string) error {
+	input, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	output, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, input); err != nil {
+		output.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := output.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
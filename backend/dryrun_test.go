@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunDryRunWritesDatabaseAndReport(t *testing.T) {
+	prevDir := cacheDir
+	cacheDir = t.TempDir()
+	t.Cleanup(func() { cacheDir = prevDir })
+	cached := withCachedFile(t, "cached-database", time.Minute)
+	withTableCopies(t,
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			_, err := db.ExecContext(ctx, `INSERT INTO approved_projects (record_id) VALUES ('rec1'), ('rec2')`)
+			return 2, err
+		},
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			_, err := db.ExecContext(ctx, `INSERT INTO ysws_project_mentions (id, ysws_approved_project) VALUES ('m1', 'rec1')`)
+			return 1, err
+		},
+	)
+
+	out := filepath.Join(t.TempDir(), "dry-run.db")
+	var report bytes.Buffer
+	if err := runDryRun(testConfig, out, &report); err != nil {
+		t.Fatalf("runDryRun() error: %v", err)
+	}
+
+	var got dryRunReport
+	if err := json.Unmarshal(report.Bytes(), &got); err != nil {
+		t.Fatalf("decoding report %q: %v", report.String(), err)
+	}
+	if got.ApprovedProjects != 2 || got.Mentions != 1 || got.CompressedSize <= 0 || got.UncompressedSize <= 0 || got.Output != out {
+		t.Errorf("report = %+v, want 2 projects, 1 mention, sizes and the output path", got)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+out+"?mode=ro")
+	if err != nil {
+		t.Fatalf("opening output: %v", err)
+	}
+	defer db.Close()
+	if n, err := countRows(db, "approved_projects"); err != nil || n != 2 {
+		t.Errorf("output has %d approved_projects (%v), want 2", n, err)
+	}
+
+	// The cache, and the directory a server keeps it in, are left as they were
+	if entry, _ := fullCache.Get(); entry.path != cached {
+		t.Errorf("cached database = %s, want %s still", entry.path, cached)
+	}
+	if files, _ := os.ReadDir(cacheDir); len(files) != 0 {
+		t.Errorf("dry run left %d files in CACHE_DIR", len(files))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Email hashes are tagged with the algorithm version that produced them ("v1:<hex>"), so a
+// future scheme can coexist with hashes downstream consumers have already stored.
+const currentEmailHashVersion = "v1"
+
+// emailHashPrefixed controls whether hashEmail tags its output with the version
+// (EMAIL_HASH_PREFIX=false emits bare hashes for consumers that expect the old format)
+var emailHashPrefixed = true
+
+// emailHashAlgorithms maps each version to its hash function over a normalized email
+var emailHashAlgorithms = map[string]func(salt, normalized string) string{
+	// v1: HMAC-SHA256 keyed with EMAIL_SALT, hex encoded
+	"v1": func(salt, normalized string) string {
+		h := hmac.New(sha256.New, []byte(salt))
+		h.Write([]byte(normalized))
+		return hex.EncodeToString(h.Sum(nil))
+	},
+}
+
+// emailSaltFingerprintInput is the fixed string HMACed with EMAIL_SALT to fingerprint it
+const emailSaltFingerprintInput = "viral-project-explorer email salt fingerprint"
+
+// emailHashMode identifies how a database's email_hash values were made: the salt that keyed
+// them (by fingerprint, never the salt itself) and whether they carry the version prefix.
+// A database whose mode differs from the running config's holds hashes it can't reproduce.
+type emailHashMode struct {
+	saltFingerprint string
+	prefixed        bool
+}
+
+// emailHashMode returns the mode hashEmail currently produces hashes in
+func (c *Config) emailHashMode() emailHashMode {
+	h := hmac.New(sha256.New, []byte(c.EmailSalt))
+	h.Write([]byte(emailSaltFingerprintInput))
+	return emailHashMode{
+		saltFingerprint: hex.EncodeToString(h.Sum(nil))[:16],
+		prefixed:        emailHashPrefixed,
+	}
+}
+
+// normalizeEmail lowercases an email and strips surrounding spaces before hashing
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// hashEmail hashes an email with the current algorithm and c.EmailSalt, prefixed with its
+// version unless EMAIL_HASH_PREFIX=false
+func (c *Config) hashEmail(email string) string {
+	if email == "" {
+		return ""
+	}
+	hash := emailHashAlgorithms[currentEmailHashVersion](c.EmailSalt, normalizeEmail(email))
+	if !emailHashPrefixed {
+		return hash
+	}
+	return currentEmailHashVersion + ":" + hash
+}
+
+// hashEmailVersion hashes an email with a specific algorithm version, returning the
+// prefixed form. It's used to recompute hashes produced by older versions.
+func (c *Config) hashEmailVersion(email, version string) (string, error) {
+	algorithm, ok := emailHashAlgorithms[version]
+	if !ok {
+		return "", fmt.Errorf("unknown email hash version %q", version)
+	}
+	return version + ":" + algorithm(c.EmailSalt, normalizeEmail(email)), nil
+}
+
+// verifyEmailHash reports whether hash was produced from email. Unprefixed hashes
+// predate versioning and are checked as v1.
+func (c *Config) verifyEmailHash(email, hash string) (bool, error) {
+	version, digest, ok := strings.Cut(hash, ":")
+	if !ok {
+		version, digest = "v1", hash
+	}
+
+	expected, err := c.hashEmailVersion(email, version)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal([]byte(expected), []byte(version+":"+digest)), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testConfig is the Config tests hand to handlers and database generation
+var testConfig = &Config{EmailSalt: "test-salt"}
+
+func TestHashEmailIsVersioned(t *testing.T) {
+	cfg := &Config{EmailSalt: "test-salt"}
+
+	hash := cfg.hashEmail(" Someone@Example.com ")
+	if !strings.HasPrefix(hash, "v1:") || len(hash) != len("v1:")+64 {
+		t.Fatalf("hashEmail() = %q, want v1: followed by a hex SHA-256", hash)
+	}
+	if again, _ := cfg.hashEmailVersion("someone@example.com", "v1"); again != hash {
+		t.Errorf("hashEmailVersion() = %q, want %q", again, hash)
+	}
+
+	emailHashPrefixed = false
+	t.Cleanup(func() { emailHashPrefixed = true })
+	if bare := cfg.hashEmail("someone@example.com"); bare != strings.TrimPrefix(hash, "v1:") {
+		t.Errorf("unprefixed hashEmail() = %q, want the bare v1 digest", bare)
+	}
+}
+
+func TestHashEmailNormalizes(t *testing.T) {
+	cfg := &Config{EmailSalt: "test-salt"}
+
+	want := cfg.hashEmail("foo@bar.com")
+	for _, email := range []string{" Foo@Bar.com ", "FOO@BAR.COM", "\tfoo@bar.com\n"} {
+		if got := cfg.hashEmail(email); got != want {
+			t.Errorf("hashEmail(%q) = %q, want %q", email, got, want)
+		}
+	}
+	if got := cfg.hashEmail(""); got != "" {
+		t.Errorf("hashEmail(\"\") = %q, want empty", got)
+	}
+}
+
+func TestHashEmailIsStableForASalt(t *testing.T) {
+	cfg := &Config{EmailSalt: "test-salt"}
+
+	// HMAC-SHA256("test-salt", "foo@bar.com"); if this changes, every shipped hash changes
+	const want = "v1:31facf27576f5247e02d4a3c314888e27cf36512174bc5c42319e39e2a600fbb"
+	if got := cfg.hashEmail("foo@bar.com"); got != want {
+		t.Errorf("hashEmail() = %q, want %q", got, want)
+	}
+
+	other := &Config{EmailSalt: "other-salt"}
+	if got := other.hashEmail("foo@bar.com"); got == want {
+		t.Error("hashEmail() is the same with a different salt")
+	}
+}
+
+func TestVerifyEmailHash(t *testing.T) {
+	cfg := &Config{EmailSalt: "test-salt"}
+	hash := cfg.hashEmail("someone@example.com")
+
+	tests := []struct {
+		name  string
+		email string
+		hash  string
+		want  bool
+	}{
+		{"prefixed match", "SOMEONE@example.com", hash, true},
+		{"legacy unprefixed match", "someone@example.com", strings.TrimPrefix(hash, "v1:"), true},
+		{"different email", "other@example.com", hash, false},
+	}
+	for _, tt := range tests {
+		got, err := cfg.verifyEmailHash(tt.email, tt.hash)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: verifyEmailHash() = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := cfg.verifyEmailHash("someone@example.com", "v9:abc"); err == nil {
+		t.Error("verifyEmailHash() accepted an unknown version")
+	}
+}
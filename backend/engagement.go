@@ -0,0 +1,39 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// buildEngagementSummary fills project_engagement_summary with one row per approved
+// project, rolled up from its mentions in a single aggregation. Projects without
+// mentions get zeros (and a NULL max_engagement), so every project can be joined.
+// It rebuilds the whole table, so it also works on an incremental base.
+func buildEngagementSummary(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("building project_engagement_summary: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM project_engagement_summary`); err != nil {
+		return fmt.Errorf("building project_engagement_summary: %w", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO project_engagement_summary
+			(record_id, total_mentions, total_weighted_engagement, max_engagement, distinct_sources)
+		SELECT
+			ap.record_id,
+			COUNT(pm.id),
+			COALESCE(SUM(pm.weighted_engagement_points), 0),
+			MAX(pm.weighted_engagement_points),
+			COUNT(DISTINCT pm.source)
+		FROM approved_projects ap
+		LEFT JOIN ysws_project_mentions pm ON pm.ysws_approved_project = ap.record_id
+		GROUP BY ap.record_id
+	`)
+	if err != nil {
+		return fmt.Errorf("building project_engagement_summary: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestBuildEngagementSummary(t *testing.T) {
+	db := openTestSQLite(t)
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+	_, err := db.Exec(`
+		INSERT INTO approved_projects (record_id) VALUES ('rec1'), ('rec2');
+		INSERT INTO ysws_project_mentions (id, ysws_approved_project, source, weighted_engagement_points) VALUES
+			('m1', 'rec1', 'Reddit', 10),
+			('m2', 'rec1', 'Reddit', 2.5),
+			('m3', 'rec1', 'YouTube', NULL),
+			('m4', 'rec-missing', 'Reddit', 100);
+	`)
+	if err != nil {
+		t.Fatalf("inserting rows: %v", err)
+	}
+
+	// Building twice must not duplicate rows
+	for i := 0; i < 2; i++ {
+		if err := buildEngagementSummary(db); err != nil {
+			t.Fatalf("buildEngagementSummary() error: %v", err)
+		}
+	}
+
+	type summary struct {
+		mentions int
+		weighted float64
+		max      sql.NullFloat64
+		sources  int
+	}
+	want := map[string]summary{
+		"rec1": {3, 12.5, sql.NullFloat64{Float64: 10, Valid: true}, 2},
+		"rec2": {0, 0, sql.NullFloat64{}, 0},
+	}
+
+	rows, err := db.Query(`SELECT record_id, total_mentions, total_weighted_engagement, max_engagement, distinct_sources FROM project_engagement_summary`)
+	if err != nil {
+		t.Fatalf("querying summary: %v", err)
+	}
+	defer rows.Close()
+	got := make(map[string]summary)
+	for rows.Next() {
+		var id string
+		var s summary
+		if err := rows.Scan(&id, &s.mentions, &s.weighted, &s.max, &s.sources); err != nil {
+			t.Fatalf("scanning summary: %v", err)
+		}
+		got[id] = s
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("summary has %d rows, want %d: %+v", len(got), len(want), got)
+	}
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("summary for %s = %+v, want %+v", id, got[id], w)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationFromEnv parses a Go duration (e.g. "30s", "5m") from an environment variable,
+// returning def when the variable is unset
+func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", name, value)
+	}
+	return d, nil
+}
+
+// intFromEnv parses a non-negative integer from an environment variable,
+// returning def when the variable is unset
+func intFromEnv(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", name, value)
+	}
+	return n, nil
+}
+
+// secretFromEnv returns the value of the environment variable name or, if name+"_FILE" is
+// set, the contents of that file (e.g. a mounted Docker or Kubernetes secret) with
+// trailing newlines trimmed. Setting both is an error. Errors never include the secret.
+func secretFromEnv(name string) (string, error) {
+	value, path := os.Getenv(name), os.Getenv(name+"_FILE")
+	if path == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("%s and %s_FILE are both set; use one", name, name)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s_FILE: %w", name, err)
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s_FILE %s is empty", name, path)
+	}
+	return secret, nil
+}
+
+// listenAddress builds the server's bind address from HOST (or its alias BIND_ADDR) and
+// PORT. An empty host listens on every interface; the port defaults to 8080.
+func listenAddress(host, port string) (string, error) {
+	if port == "" {
+		port = "8080"
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid PORT %q: must be a number from 1 to 65535", port)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(n)), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		host, port string
+		want       string
+	}{
+		{"", "", ":8080"},
+		{"", "3000", ":3000"},
+		{"127.0.0.1", "", "127.0.0.1:8080"},
+		{"::1", "9000", "[::1]:9000"},
+	}
+	for _, tt := range tests {
+		got, err := listenAddress(tt.host, tt.port)
+		if err != nil || got != tt.want {
+			t.Errorf("listenAddress(%q, %q) = %q, %v; want %q", tt.host, tt.port, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"http", "0", "65536", "-1", "80a"} {
+		if _, err := listenAddress("", bad); err == nil {
+			t.Errorf("listenAddress(\"\", %q) expected an error", bad)
+		}
+	}
+}
+
+func TestSecretFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "salt")
+	if err := os.WriteFile(secretFile, []byte("s3cret-from-file\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_SECRET", "")
+	t.Setenv("TEST_SECRET_FILE", "")
+	if got, err := secretFromEnv("TEST_SECRET"); err != nil || got != "" {
+		t.Errorf("unset: secretFromEnv() = %q, %v; want empty", got, err)
+	}
+
+	t.Setenv("TEST_SECRET", "s3cret-from-env")
+	if got, err := secretFromEnv("TEST_SECRET"); err != nil || got != "s3cret-from-env" {
+		t.Errorf("env: secretFromEnv() = %q, %v; want the variable", got, err)
+	}
+
+	t.Setenv("TEST_SECRET", "")
+	t.Setenv("TEST_SECRET_FILE", secretFile)
+	if got, err := secretFromEnv("TEST_SECRET"); err != nil || got != "s3cret-from-file" {
+		t.Errorf("file: secretFromEnv() = %q, %v; want the file's contents without newlines", got, err)
+	}
+
+	for name, setup := range map[string]func(){
+		"both set":     func() { t.Setenv("TEST_SECRET", "s3cret-from-env"); t.Setenv("TEST_SECRET_FILE", secretFile) },
+		"missing file": func() { t.Setenv("TEST_SECRET", ""); t.Setenv("TEST_SECRET_FILE", filepath.Join(dir, "missing")) },
+		"empty file":   func() { t.Setenv("TEST_SECRET", ""); t.Setenv("TEST_SECRET_FILE", emptyFile) },
+	} {
+		setup()
+		_, err := secretFromEnv("TEST_SECRET")
+		if err == nil {
+			t.Errorf("%s: secretFromEnv() succeeded, want an error", name)
+		} else if strings.Contains(err.Error(), "s3cret") {
+			t.Errorf("%s: error %q leaks the secret", name, err)
+		}
+	}
+}
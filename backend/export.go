@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// rowSource yields shipped row values one at a time, returning nil values at the end
+type rowSource func() ([]interface{}, error)
+
+// pgRowSource iterates over Postgres rows with one of the shared scan functions
+func pgRowSource(rows *sql.Rows, scan func(*sql.Rows) ([]interface{}, error)) rowSource {
+	return func() ([]interface{}, error) {
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return nil, fmt.Errorf("reading rows: %w", err)
+			}
+			return nil, nil
+		}
+		return scan(rows)
+	}
+}
+
+// rowObject turns row values into a JSON object keyed by column name
+func rowObject(columns []string, values []interface{}) map[string]interface{} {
+	object := make(map[string]interface{}, len(columns)+1)
+	for i, column := range columns {
+		object[column] = values[i]
+	}
+	return object
+}
+
+// writeProjectsJSONL writes one JSON object per project, with its mentions nested under
+// "mentions". Both sources must be sorted by project record ID (byte order); mentions of
+// projects that aren't in the projects source are skipped. Only one project's mentions
+// are held in memory at a time.
+func writeProjectsJSONL(out io.Writer, projects, mentions rowSource) (int, error) {
+	encoder := json.NewEncoder(out)
+	projectIDColumn := approvedProjectIndex["record_id"]
+	mentionProjectColumn := projectMentionIndex["ysws_approved_project"]
+
+	mention, err := mentions()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		project, err := projects()
+		if err != nil {
+			return count, err
+		}
+		if project == nil {
+			return count, nil
+		}
+		projectID, _ := project[projectIDColumn].(string)
+
+		nested := []map[string]interface{}{}
+		for mention != nil {
+			mentionProject, _ := mention[mentionProjectColumn].(string)
+			if mentionProject > projectID {
+				break
+			}
+			if mentionProject == projectID {
+				nested = append(nested, rowObject(projectMentionColumns, mention))
+			}
+			if mention, err = mentions(); err != nil {
+				return count, err
+			}
+		}
+
+		object := rowObject(approvedProjectColumns, project)
+		object["mentions"] = nested
+		if err := encoder.Encode(object); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// compressedResponse wraps w in the encoding the client prefers out of zstd and gzip
+// (zstd on a tie) and sets Content-Encoding. The returned close function flushes the encoder.
+func compressedResponse(w http.ResponseWriter, r *http.Request) (io.Writer, func() error, error) {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	switch preferredEncoding(r.Header.Get("Accept-Encoding"), "zstd", "gzip") {
+	case "zstd":
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating zstd encoder: %w", err)
+		}
+		w.Header().Set("Content-Encoding", "zstd")
+		return encoder, encoder.Close, nil
+	case "gzip":
+		encoder := gzip.NewWriter(w)
+		w.Header().Set("Content-Encoding", "gzip")
+		return encoder, encoder.Close, nil
+	}
+	return w, func() error { return nil }, nil
+}
+
+// exportJSONHandler streams approved projects with their mentions as newline-delimited
+// JSON, straight from Postgres with the same transforms as the SQLite database, emails
+// hashed with cfg
+func exportJSONHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestStart := time.Now()
+
+		disposition, err := contentDisposition(r, formatNDJSON, "export.jsonl")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
+		}
+
+		ctx := r.Context()
+		projectRows, err := pgDB.QueryContext(ctx, inSchema(approvedProjectsQuery+` ORDER BY ap.record_id COLLATE "C"`))
+		if err != nil {
+			requestLog(r).Error("Failed to query approved_projects for export: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		defer projectRows.Close()
+
+		mentionRows, err := pgDB.QueryContext(ctx, inSchema(projectMentionsQuery+` WHERE ysws_approved_project IS NOT NULL ORDER BY ysws_approved_project COLLATE "C"`))
+		if err != nil {
+			requestLog(r).Error("Failed to query ysws_project_mentions for export: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		defer mentionRows.Close()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", disposition)
+		out, closeOut, err := compressedResponse(w, r)
+		if err != nil {
+			requestLog(r).Error("Failed to set up export encoding: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		count, err := writeProjectsJSONL(out, pgRowSource(projectRows, cfg.scanApprovedProject), pgRowSource(mentionRows, scanProjectMention))
+		if err == nil {
+			err = closeOut()
+		}
+		if err != nil {
+			if ctx.Err() == context.Canceled {
+				requestLog(r).Info("Client disconnected during JSON export after %d projects", count)
+				return
+			}
+			// The status line is already out; abort so the client doesn't take a truncated export as complete
+			requestLog(r).Error("JSON export failed after %d projects: %v", count, err)
+			panic(http.ErrAbortHandler)
+		}
+
+		requestLog(r).Info("JSON export sent: %d projects in %s", count, time.Since(requestStart))
+	}
+}
+
+// csvField formats a shipped value as a CSV field; NULLs become empty fields
+func csvField(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeCSV writes a header row and then one record per row, flushing as it goes
+func writeCSV(out io.Writer, columns []string, rows rowSource) (int, error) {
+	writer := csv.NewWriter(out)
+	if err := writer.Write(columns); err != nil {
+		return 0, err
+	}
+
+	record := make([]string, len(columns))
+	count := 0
+	for {
+		values, err := rows()
+		if err != nil {
+			return count, err
+		}
+		if values == nil {
+			break
+		}
+		for i, value := range values {
+			record[i] = csvField(value)
+		}
+		if err := writer.Write(record); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	writer.Flush()
+	return count, writer.Error()
+}
+
+// exportProjectsCSVHandler streams approved_projects as CSV, straight from Postgres with
+// the same transforms as the SQLite database, emails hashed with cfg
+func exportProjectsCSVHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestStart := time.Now()
+
+		disposition, err := contentDisposition(r, formatCSV, "approved_projects.csv")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
+		}
+
+		ctx := r.Context()
+		rows, err := pgDB.QueryContext(ctx, inSchema(approvedProjectsQuery+` ORDER BY ap.record_id`))
+		if err != nil {
+			requestLog(r).Error("Failed to query approved_projects for CSV export: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		defer rows.Close()
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", disposition)
+		out, closeOut, err := compressedResponse(w, r)
+		if err != nil {
+			requestLog(r).Error("Failed to set up export encoding: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		count, err := writeCSV(out, approvedProjectColumns, pgRowSource(rows, cfg.scanApprovedProject))
+		if err == nil {
+			err = closeOut()
+		}
+		if err != nil {
+			if ctx.Err() == context.Canceled {
+				requestLog(r).Info("Client disconnected during CSV export after %d rows", count)
+				return
+			}
+			requestLog(r).Error("CSV export failed after %d rows: %v", count, err)
+			panic(http.ErrAbortHandler)
+		}
+
+		requestLog(r).Info("CSV export sent: %d approved_projects in %s", count, time.Since(requestStart))
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+// sliceRowSource yields rows built from column/value maps, in order
+func sliceRowSource(columns []string, rows ...map[string]interface{}) rowSource {
+	return func() ([]interface{}, error) {
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i] = rows[0][column]
+		}
+		rows = rows[1:]
+		return values, nil
+	}
+}
+
+func TestWriteProjectsJSONLNestsMentions(t *testing.T) {
+	projects := sliceRowSource(approvedProjectColumns,
+		map[string]interface{}{"record_id": "recA", "ysws_name": "Daydream"},
+		map[string]interface{}{"record_id": "recB"},
+		map[string]interface{}{"record_id": "recD"},
+	)
+	mentions := sliceRowSource(projectMentionColumns,
+		map[string]interface{}{"id": "m1", "ysws_approved_project": "recA"},
+		map[string]interface{}{"id": "m2", "ysws_approved_project": "recA"},
+		map[string]interface{}{"id": "m3", "ysws_approved_project": "recC"}, // not an approved project
+		map[string]interface{}{"id": "m4", "ysws_approved_project": "recD"},
+	)
+
+	var out bytes.Buffer
+	count, err := writeProjectsJSONL(&out, projects, mentions)
+	if err != nil {
+		t.Fatalf("writeProjectsJSONL() error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+
+	wantMentions := map[string][]string{"recA": {"m1", "m2"}, "recB": {}, "recD": {"m4"}}
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var line struct {
+			RecordID string `json:"record_id"`
+			Mentions []struct {
+				ID string `json:"id"`
+			} `json:"mentions"`
+		}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatalf("decoding line: %v", err)
+		}
+		var ids []string
+		for _, m := range line.Mentions {
+			ids = append(ids, m.ID)
+		}
+		if want := wantMentions[line.RecordID]; fmt.Sprint(ids) != fmt.Sprint(want) {
+			t.Errorf("%s mentions = %v, want %v", line.RecordID, ids, want)
+		}
+	}
+}
+
+func TestCompressedResponseNegotiates(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"gzip, zstd", "zstd"},
+		{"gzip", "gzip"},
+		{"zstd;q=0.5, gzip", "gzip"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/export.json", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		rec := httptest.NewRecorder()
+		_, closeOut, err := compressedResponse(rec, req)
+		if err != nil {
+			t.Fatalf("compressedResponse() error: %v", err)
+		}
+		closeOut()
+		if got := rec.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestInsertStatement(t *testing.T) {
+	got := insertStatement("INSERT OR REPLACE", "t", []string{"a", "b", "c"})
+	if want := "INSERT OR REPLACE INTO t (a, b, c) VALUES (?, ?, ?)"; got != want {
+		t.Errorf("insertStatement() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteCSVFormatsValues(t *testing.T) {
+	columns := []string{"record_id", "hours_spent", "age_when_approved", "code_url"}
+	rows := []map[string]interface{}{
+		{"record_id": "rec1", "hours_spent": 12.5, "age_when_approved": int64(16), "code_url": "https://github.com/a/b"},
+		{"record_id": "rec2, with comma"},
+	}
+
+	var out bytes.Buffer
+	count, err := writeCSV(&out, columns, sliceRowSource(columns, rows...))
+	if err != nil {
+		t.Fatalf("writeCSV() error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+
+	want := "record_id,hours_spent,age_when_approved,code_url\n" +
+		"rec1,12.5,16,https://github.com/a/b\n" +
+		"\"rec2, with comma\",,,\n"
+	if out.String() != want {
+		t.Errorf("writeCSV() wrote\n%s\nwant\n%s", out.String(), want)
+	}
+}
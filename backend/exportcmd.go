@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// exportOptions are the flags of the export subcommand
+type exportOptions struct {
+	out      string
+	compress bool
+}
+
+// parseExportArgs parses the arguments after `export`, e.g. `export -out db.sqlite`
+func parseExportArgs(args []string) (exportOptions, error) {
+	var opts exportOptions
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.StringVar(&opts.out, "out", "", "write the database to this path (required)")
+	flags.BoolVar(&opts.compress, "compress", false, "write the zstd-compressed database instead of plain SQLite")
+	if err := flags.Parse(args); err != nil {
+		return exportOptions{}, err
+	}
+	if opts.out == "" {
+		return exportOptions{}, fmt.Errorf("export: -out is required")
+	}
+	if flags.NArg() > 0 {
+		return exportOptions{}, fmt.Errorf("export: unexpected arguments %q", flags.Args())
+	}
+	return opts, nil
+}
+
+// exportDatabase builds a fresh database from PostgreSQL and writes it to out, compressed
+// with zstd if compress is set. It bypasses the cache entirely. The file is built next to
+// out and renamed into place, so a failed export never leaves a partial file behind.
+func exportDatabase(cfg *Config, out string, compress bool) (sqliteBuild, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(out), ".export-*.db")
+	if err != nil {
+		return sqliteBuild{}, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	built, err := buildSQLiteFile(cfg, tmpPath, nil)
+	if err != nil {
+		return sqliteBuild{}, err
+	}
+
+	result := tmpPath
+	if compress {
+		info, err := os.Stat(tmpPath)
+		if err != nil {
+			return sqliteBuild{}, err
+		}
+		compressedPath, err := compressWithZstd(tmpPath)
+		if err != nil {
+			return sqliteBuild{}, fmt.Errorf("failed to compress database: %w", err)
+		}
+		defer os.Remove(compressedPath)
+		if err := verifyZstd(compressedPath, info.Size()); err != nil {
+			return sqliteBuild{}, fmt.Errorf("compressed database failed verification: %w", err)
+		}
+		result = compressedPath
+	}
+
+	// CreateTemp makes the file private; an export is meant to be shared
+	if err := os.Chmod(result, 0o644); err != nil {
+		return sqliteBuild{}, err
+	}
+	if err := os.Rename(result, out); err != nil {
+		return sqliteBuild{}, fmt.Errorf("writing %s: %w", out, err)
+	}
+	return built, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseExportArgs(t *testing.T) {
+	opts, err := parseExportArgs([]string{"-out", "db.sqlite", "-compress"})
+	if err != nil || opts != (exportOptions{out: "db.sqlite", compress: true}) {
+		t.Errorf("parseExportArgs() = %+v, %v; want db.sqlite compressed", opts, err)
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"-compress"},
+		{"-out", "db.sqlite", "extra"},
+		{"-unknown"},
+	} {
+		if _, err := parseExportArgs(args); err == nil {
+			t.Errorf("parseExportArgs(%q) succeeded, want an error", args)
+		}
+	}
+}
+
+func withExportTables(t *testing.T) {
+	t.Helper()
+	withTableCopies(t,
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			_, err := db.ExecContext(ctx, `INSERT INTO approved_projects (record_id) VALUES ('rec1'), ('rec2')`)
+			return 2, err
+		},
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			_, err := db.ExecContext(ctx, `INSERT INTO ysws_project_mentions (id, ysws_approved_project) VALUES ('m1', 'rec1')`)
+			return 1, err
+		},
+	)
+}
+
+func TestExportDatabaseWritesSQLite(t *testing.T) {
+	withExportTables(t)
+	withCacheEntry(t, cacheEntry{})
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "db.sqlite")
+	built, err := exportDatabase(testConfig, out, false)
+	if err != nil {
+		t.Fatalf("exportDatabase(testConfig, ) error: %v", err)
+	}
+	if built.projectCount != 2 || built.mentionCount != 1 {
+		t.Errorf("exportDatabase(testConfig, ) = %+v, want 2 projects and 1 mention", built)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+out+"?mode=ro")
+	if err != nil {
+		t.Fatalf("opening output: %v", err)
+	}
+	defer db.Close()
+	if n, err := countRows(db, "approved_projects"); err != nil || n != 2 {
+		t.Errorf("output has %d approved_projects (%v), want 2", n, err)
+	}
+
+	// Only the output is left behind, and the cache isn't touched
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("export left %d files in the output directory, want 1", len(entries))
+	}
+	if entry, ok := fullCache.Get(); ok {
+		t.Errorf("export cached %+v, want the cache left empty", entry)
+	}
+}
+
+func TestExportDatabaseCompresses(t *testing.T) {
+	withExportTables(t)
+
+	out := filepath.Join(t.TempDir(), "db.sqlite.zst")
+	if _, err := exportDatabase(testConfig, out, true); err != nil {
+		t.Fatalf("exportDatabase(testConfig, ) error: %v", err)
+	}
+	if err := verifyZstd(out, 0); err != nil {
+		t.Errorf("output isn't valid zstd: %v", err)
+	}
+}
+
+func TestExportDatabaseFailureLeavesNoFile(t *testing.T) {
+	withTableCopies(t,
+		func(context.Context, Querier, *sql.DB, copyScope) (int, error) { return 0, os.ErrDeadlineExceeded },
+		func(context.Context, Querier, *sql.DB, copyScope) (int, error) { return 0, nil },
+	)
+
+	dir := t.TempDir()
+	if _, err := exportDatabase(testConfig, filepath.Join(dir, "db.sqlite"), false); err == nil {
+		t.Fatal("exportDatabase(testConfig, ) succeeded, want the copy error")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("failed export left %d files behind, want none", len(entries))
+	}
+}
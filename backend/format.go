@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// Download formats served by the API
+const (
+	formatZstd     = "zstd"
+	formatZstdDict = "zstd-dict"
+	formatSQLite   = "sqlite"
+	formatGzip     = "gzip"
+	formatBrotli   = "br"
+	formatJSON     = "json"
+	formatNDJSON   = "ndjson"
+	formatCSV      = "csv"
+)
+
+// defaultDispositions maps each format to its default Content-Disposition type.
+// Text formats render inline so browsers can show them; binary artifacts are downloaded.
+var defaultDispositions = map[string]string{
+	formatZstd:     "attachment",
+	formatZstdDict: "attachment",
+	formatSQLite:   "attachment",
+	formatGzip:     "attachment",
+	formatBrotli:   "attachment",
+	formatJSON:     "inline",
+	formatNDJSON:   "inline",
+	formatCSV:      "inline",
+}
+
+// unsafeFilenameChars matches anything outside the safe set allowed in download filenames
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// validFilenameParam is what ?filename= accepts: the safe set, not starting with a dot,
+// at most 128 characters
+var validFilenameParam = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}$`)
+
+// userAgentZstdAllowlist holds the regexes matched against the User-Agent header
+// to pick a default format when the client didn't negotiate one explicitly.
+// When empty, zstd stays the default for every client.
+var userAgentZstdAllowlist []*regexp.Regexp
+
+// parseUserAgentAllowlist compiles a comma-separated list of regexes
+// (from USER_AGENT_ZSTD_ALLOWLIST), ignoring empty entries
+func parseUserAgentAllowlist(value string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid User-Agent pattern %q: %w", raw, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// negotiateFormat decides which format to serve for a /db request.
+// Explicit negotiation always wins over the User-Agent heuristic:
+//  1. ?format=zstd|zstd-dict|br|gzip|sqlite
+//  2. Accept-Encoding: the listed encoding with the highest q-value out of zstd, br, gzip
+//     and identity (raw SQLite, decompressed on the fly), in that order on a tie
+//  3. USER_AGENT_ZSTD_ALLOWLIST: allowlisted clients get zstd, everyone else raw SQLite
+//
+// Without an allowlist configured, zstd is served as before.
+func negotiateFormat(r *http.Request) (string, error) {
+	if format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); format != "" {
+		switch format {
+		case formatZstd, "zst":
+			return formatZstd, nil
+		case formatZstdDict:
+			// Only clients that fetch /db.dict can read it, so it's never negotiated
+			if zstdDict == nil {
+				return "", fmt.Errorf("format %q needs a zstd dictionary, and none is configured", format)
+			}
+			return formatZstdDict, nil
+		case formatBrotli, "brotli":
+			return formatBrotli, nil
+		case formatGzip, "gz":
+			return formatGzip, nil
+		case formatSQLite, "raw", "db":
+			return formatSQLite, nil
+		default:
+			return "", fmt.Errorf("unsupported format %q", format)
+		}
+	}
+
+	// Brotli is smaller than gzip, and every current browser decodes it. Clients that will
+	// store the database decompressed can ask for it as-is with identity.
+	switch preferredEncoding(r.Header.Get("Accept-Encoding"), "zstd", "br", "gzip", "identity") {
+	case "zstd":
+		return formatZstd, nil
+	case "br":
+		return formatBrotli, nil
+	case "gzip":
+		return formatGzip, nil
+	case "identity":
+		return formatSQLite, nil
+	}
+
+	if len(userAgentZstdAllowlist) == 0 {
+		return formatZstd, nil
+	}
+
+	userAgent := r.UserAgent()
+	for _, re := range userAgentZstdAllowlist {
+		if re.MatchString(userAgent) {
+			return formatZstd, nil
+		}
+	}
+	return formatSQLite, nil
+}
+
+// preferredEncoding returns the encoding an Accept-Encoding header gives the highest
+// quality value, out of encodings, which are in the server's order of preference for
+// breaking ties. It returns "" if the header lists none of them with a non-zero q-value.
+func preferredEncoding(header string, encodings ...string) string {
+	best, bestQ := "", 0.0
+	for _, encoding := range encodings {
+		if q := encodingQuality(header, encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// encodingQuality returns the q-value an Accept-Encoding header gives encoding: 1 if it's
+// listed without one, 0 if it isn't listed
+func encodingQuality(header, encoding string) float64 {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), encoding) {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if parsed, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+				q = parsed
+			}
+		}
+		return q
+	}
+	return 0
+}
+
+// sanitizeFilename restricts a download filename to a safe character set so it can't
+// break out of the quoted Content-Disposition value or inject headers
+func sanitizeFilename(name string) string {
+	name = unsafeFilenameChars.ReplaceAllString(name, "_")
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		return "download"
+	}
+	return name
+}
+
+// contentDisposition builds the Content-Disposition header for a format, honoring a
+// ?disposition=inline|attachment override and a ?filename= replacing filename. Unlike
+// filename, which is sanitized, an unsafe ?filename= is rejected so the client knows.
+func contentDisposition(r *http.Request, format, filename string) (string, error) {
+	if param := r.URL.Query().Get("filename"); param != "" {
+		if !validFilenameParam.MatchString(param) {
+			return "", fmt.Errorf("invalid filename %q: use up to 128 letters, digits, '.', '_' and '-', not starting with '.'", param)
+		}
+		filename = param
+	}
+
+	dispositionType, ok := defaultDispositions[format]
+	if !ok {
+		dispositionType = "attachment"
+	}
+
+	if override := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("disposition"))); override != "" {
+		if override != "inline" && override != "attachment" {
+			return "", fmt.Errorf("unsupported disposition %q", override)
+		}
+		dispositionType = override
+	}
+
+	return fmt.Sprintf(`%s; filename="%s"`, dispositionType, sanitizeFilename(filename)), nil
+}
+
+// serveDecompressedDB streams the cached zstd file to the client as a plain SQLite database,
+// decompressing on the fly so we never keep a second uncompressed copy on disk
+func serveDecompressedDB(w http.ResponseWriter, r *http.Request, compressedPath, disposition string, requestStart time.Time) {
+	file, err := os.Open(compressedPath)
+	if err != nil {
+		requestLog(r).Error("Failed to open file for reading: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	defer file.Close()
+
+	decoder, err := newZstdReader(file)
+	if err != nil {
+		requestLog(r).Error("Failed to create zstd decoder: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	defer decoder.Close()
+
+	if err := setDBHeaders(w, compressedPath, formatSQLite, disposition); err != nil {
+		requestLog(r).Error("Failed to stat file: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	bytesSent, err := io.Copy(w, contextReader{r.Context(), decoder})
+	if err != nil {
+		logStreamError(r, bytesSent, err)
+		return
+	}
+
+	requestLog(r).Info("Decompressed database sent: %.2f MB in %s", float64(bytesSent)/(1024*1024), time.Since(requestStart))
+}
+
+// uncompressedSizeFor returns the uncompressed size recorded for the cached file,
+// or 0 if the path is no longer the current cache entry
+func uncompressedSizeFor(compressedPath string) int64 {
+	entry, ok := fullCache.Lookup(compressedPath)
+	if !ok {
+		return subsetUncompressedSize(compressedPath)
+	}
+	return entry.uncompressedSize
+}
+
+// compressionRatioFor returns the zstd compression ratio recorded for the cached file,
+// or 0 if it's unknown
+func compressionRatioFor(compressedPath string) float64 {
+	entry, _ := fullCache.Lookup(compressedPath)
+	return entry.compressionRatio
+}
+
+// serveGzipDB re-encodes the cached zstd file as gzip on the fly, for clients and proxies
+// that only understand gzip. It's sent with Content-Encoding: gzip, so HTTP clients
+// transparently decompress it into the plain SQLite file.
+func serveGzipDB(w http.ResponseWriter, r *http.Request, compressedPath, disposition string, requestStart time.Time) {
+	file, err := os.Open(compressedPath)
+	if err != nil {
+		requestLog(r).Error("Failed to open file for reading: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	defer file.Close()
+
+	decoder, err := newZstdReader(file)
+	if err != nil {
+		requestLog(r).Error("Failed to create zstd decoder: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	defer decoder.Close()
+
+	if err := setDBHeaders(w, compressedPath, formatGzip, disposition); err != nil {
+		requestLog(r).Error("Failed to stat file: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	counter := &countingWriter{w: w}
+	encoder := gzip.NewWriter(counter)
+	if _, err := io.Copy(encoder, contextReader{r.Context(), decoder}); err != nil {
+		logStreamError(r, counter.n, err)
+		return
+	}
+	if err := encoder.Close(); err != nil {
+		requestLog(r).Error("Error finishing gzip stream: %v", err)
+		return
+	}
+
+	requestLog(r).Info("Gzip database sent: %.2f MB in %s", float64(counter.n)/(1024*1024), time.Since(requestStart))
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
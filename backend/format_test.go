@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withUserAgentAllowlist(t *testing.T, value string) {
+	t.Helper()
+	patterns, err := parseUserAgentAllowlist(value)
+	if err != nil {
+		t.Fatalf("parseUserAgentAllowlist(%q) error: %v", value, err)
+	}
+	previous := userAgentZstdAllowlist
+	userAgentZstdAllowlist = patterns
+	t.Cleanup(func() { userAgentZstdAllowlist = previous })
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	withUserAgentAllowlist(t, `^viral-explorer-cli/, ^curl/`)
+
+	tests := []struct {
+		name           string
+		url            string
+		userAgent      string
+		acceptEncoding string
+		expected       string
+	}{
+		{
+			name:      "allowlisted user agent gets zstd",
+			url:       "/db",
+			userAgent: "viral-explorer-cli/1.2.0",
+			expected:  formatZstd,
+		},
+		{
+			name:      "non-allowlisted user agent gets raw SQLite",
+			url:       "/db",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64)",
+			expected:  formatSQLite,
+		},
+		{
+			name:      "explicit format param beats the heuristic",
+			url:       "/db?format=zstd",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64)",
+			expected:  formatZstd,
+		},
+		{
+			name:      "explicit raw format for an allowlisted client",
+			url:       "/db?format=sqlite",
+			userAgent: "curl/8.4.0",
+			expected:  formatSQLite,
+		},
+		{
+			name:           "Accept-Encoding zstd beats the heuristic",
+			url:            "/db",
+			userAgent:      "Mozilla/5.0",
+			acceptEncoding: "gzip, deflate, br, zstd",
+			expected:       formatZstd,
+		},
+		{
+			name:           "Accept-Encoding zstd with q=0 falls back to gzip",
+			url:            "/db",
+			userAgent:      "Mozilla/5.0",
+			acceptEncoding: "zstd;q=0, gzip",
+			expected:       formatGzip,
+		},
+		{
+			name:           "gzip-only client gets gzip",
+			url:            "/db",
+			userAgent:      "viral-explorer-cli/1.2.0",
+			acceptEncoding: "gzip",
+			expected:       formatGzip,
+		},
+		{
+			name:           "Accept-Encoding identity gets raw SQLite even for an allowlisted client",
+			url:            "/db",
+			userAgent:      "viral-explorer-cli/1.2.0",
+			acceptEncoding: "identity",
+			expected:       formatSQLite,
+		},
+		{
+			name:           "zstd beats identity",
+			url:            "/db",
+			userAgent:      "Mozilla/5.0",
+			acceptEncoding: "identity, zstd",
+			expected:       formatZstd,
+		},
+		{
+			name:           "identity with q=0 uses the heuristic",
+			url:            "/db",
+			userAgent:      "curl/8.4.0",
+			acceptEncoding: "identity;q=0",
+			expected:       formatZstd,
+		},
+		{
+			name:           "Accept-Encoding br beats gzip",
+			url:            "/db",
+			userAgent:      "Mozilla/5.0",
+			acceptEncoding: "gzip, deflate, br",
+			expected:       formatBrotli,
+		},
+		{
+			name:           "higher q-value beats server preference",
+			url:            "/db",
+			userAgent:      "Mozilla/5.0",
+			acceptEncoding: "gzip;q=1, br;q=0.5",
+			expected:       formatGzip,
+		},
+		{
+			name:           "server preference breaks q-value ties",
+			url:            "/db",
+			userAgent:      "Mozilla/5.0",
+			acceptEncoding: "gzip;q=0.8, zstd;q=0.8, br;q=0.5",
+			expected:       formatZstd,
+		},
+		{
+			name:           "identity preferred over zstd",
+			url:            "/db",
+			userAgent:      "curl/8.4.0",
+			acceptEncoding: "zstd;q=0.1, identity",
+			expected:       formatSQLite,
+		},
+		{
+			name:      "explicit brotli format",
+			url:       "/db?format=brotli",
+			userAgent: "curl/8.4.0",
+			expected:  formatBrotli,
+		},
+		{
+			name:           "Accept-Encoding without zstd, br or gzip uses the heuristic",
+			url:            "/db",
+			userAgent:      "Mozilla/5.0",
+			acceptEncoding: "deflate",
+			expected:       formatSQLite,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			result, err := negotiateFormat(req)
+			if err != nil {
+				t.Fatalf("negotiateFormat() error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("negotiateFormat() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNegotiateFormatDefaultsToZstdWithoutAllowlist(t *testing.T) {
+	withUserAgentAllowlist(t, "")
+
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	result, err := negotiateFormat(req)
+	if err != nil {
+		t.Fatalf("negotiateFormat() error: %v", err)
+	}
+	if result != formatZstd {
+		t.Errorf("negotiateFormat() = %q, want %q", result, formatZstd)
+	}
+}
+
+func TestDBHandlerServesIdentityDecompressed(t *testing.T) {
+	withUserAgentAllowlist(t, "")
+	plain := bytes.Repeat([]byte("SQLite format 3\x00 pretend database page "), 100)
+	withCachedDatabase(t, plain)
+
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("Accept-Encoding", "identity")
+	rec := httptest.NewRecorder()
+	dbHandler(testConfig)(rec, req)
+
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/vnd.sqlite3" {
+		t.Fatalf("status = %d, Content-Type %q; want 200 with the SQLite type", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Content-Encoding = %q, want none", rec.Header().Get("Content-Encoding"))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), plain) {
+		t.Errorf("body is %d bytes, want the %d byte decompressed database", rec.Body.Len(), len(plain))
+	}
+}
+
+func TestNegotiateFormatRejectsUnknownFormat(t *testing.T) {
+	req := httptest.NewRequest("GET", "/db?format=xml", nil)
+	if _, err := negotiateFormat(req); err == nil {
+		t.Error("negotiateFormat() expected error for unsupported format")
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		format   string
+		filename string
+		expected string
+	}{
+		{"zstd defaults to attachment", "/db", formatZstd, "database.db.zst", `attachment; filename="database.db.zst"`},
+		{"sqlite defaults to attachment", "/db.sqlite", formatSQLite, "database.db", `attachment; filename="database.db"`},
+		{"json defaults to inline", "/export.json", formatJSON, "export.json", `inline; filename="export.json"`},
+		{"ndjson defaults to inline", "/export.ndjson", formatNDJSON, "export.ndjson", `inline; filename="export.ndjson"`},
+		{"csv defaults to inline", "/export.csv", formatCSV, "export.csv", `inline; filename="export.csv"`},
+		{"override binary to inline", "/db?disposition=inline", formatZstd, "database.db.zst", `inline; filename="database.db.zst"`},
+		{"override text to attachment", "/export.csv?disposition=ATTACHMENT", formatCSV, "export.csv", `attachment; filename="export.csv"`},
+		{"filename param replaces the default", "/db?filename=daydream-2024-06-01.db.zst", formatZstd, "database.db.zst", `attachment; filename="daydream-2024-06-01.db.zst"`},
+		{"filename is sanitized", "/db", formatZstd, "../evil\"\r\nX-Injected: 1.zst", `attachment; filename="_evil___X-Injected__1.zst"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			result, err := contentDisposition(req, tt.format, tt.filename)
+			if err != nil {
+				t.Fatalf("contentDisposition() error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("contentDisposition() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestContentDispositionRejectsUnknownOverride(t *testing.T) {
+	req := httptest.NewRequest("GET", "/db?disposition=download", nil)
+	if _, err := contentDisposition(req, formatZstd, "database.db.zst"); err == nil {
+		t.Error("contentDisposition() expected error for unsupported disposition")
+	}
+}
+
+func TestContentDispositionRejectsUnsafeFilename(t *testing.T) {
+	for _, filename := range []string{
+		"../etc/passwd",
+		".hidden",
+		"a\"b.db",
+		"evil.db\r\nX-Injected: 1",
+		"space in name.db",
+		strings.Repeat("a", 129),
+	} {
+		req := httptest.NewRequest("GET", "/db?"+url.Values{"filename": {filename}}.Encode(), nil)
+		if _, err := contentDisposition(req, formatZstd, "database.db.zst"); err == nil {
+			t.Errorf("contentDisposition() accepted filename %q", filename)
+		}
+	}
+}
+
+func TestDBHandlerRejectsUnsafeFilename(t *testing.T) {
+	rec := httptest.NewRecorder()
+	dbHandler(testConfig)(rec, httptest.NewRequest("GET", "/db?filename=..%2Fdb", nil))
+	if rec.Code != 400 {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+// withCachedDatabase compresses contents with zstd and installs it as a fresh cached database
+func withCachedDatabase(t *testing.T, contents []byte) {
+	t.Helper()
+	rawPath := filepath.Join(t.TempDir(), "cached-db-1.db")
+	if err := os.WriteFile(rawPath, contents, 0o600); err != nil {
+		t.Fatalf("writing database: %v", err)
+	}
+	compressedPath, err := compressWithZstd(rawPath)
+	if err != nil {
+		t.Fatalf("compressWithZstd() error: %v", err)
+	}
+
+	info, err := os.Stat(compressedPath)
+	if err != nil {
+		t.Fatalf("stat compressed database: %v", err)
+	}
+	sum, err := fileSHA256(compressedPath)
+	if err != nil {
+		t.Fatalf("fileSHA256() error: %v", err)
+	}
+
+	withCacheEntry(t, cacheEntry{
+		path:             compressedPath,
+		createdAt:        time.Now(),
+		etag:             fileETag(info),
+		sha256:           sum,
+		compressedSize:   info.Size(),
+		uncompressedSize: int64(len(contents)),
+		compressionRatio: float64(len(contents)) / float64(info.Size()),
+		emailHashes:      testConfig.emailHashMode(),
+	})
+}
+
+func TestDBHandlerServesGzipToGzipOnlyClients(t *testing.T) {
+	contents := bytes.Repeat([]byte("SQLite format 3\x00 pretend database page "), 1000)
+	withCachedDatabase(t, contents)
+
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	dbHandler(testConfig)(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/vnd.sqlite3" {
+		t.Errorf("Content-Type = %q, want application/vnd.sqlite3", got)
+	}
+
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("response is not valid gzip: %v", err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading gzip body: %v", err)
+	}
+	if !bytes.Equal(decoded, contents) {
+		t.Errorf("gzip body decodes to %d bytes, want the %d-byte SQLite file", len(decoded), len(contents))
+	}
+}
+
+func TestDBHandlerReportsCompressionHeaders(t *testing.T) {
+	contents := bytes.Repeat([]byte("SQLite format 3\x00 pretend database page "), 1000)
+	withCachedDatabase(t, contents)
+
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("Accept-Encoding", "zstd")
+	rec := httptest.NewRecorder()
+	dbHandler(testConfig)(rec, req)
+
+	if got, want := rec.Header().Get("X-Uncompressed-Size"), fmt.Sprintf("%d", len(contents)); got != want {
+		t.Errorf("X-Uncompressed-Size = %q, want %q", got, want)
+	}
+	want := fmt.Sprintf("%.2f", float64(len(contents))/float64(rec.Body.Len()))
+	if got := rec.Header().Get("X-Compression-Ratio"); got != want {
+		t.Errorf("X-Compression-Ratio = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Full-text search tables shipped alongside the data tables, so consumers can run
+// MATCH queries without building their own index. They store their own copy of the
+// text (keyed by the row's ID) rather than pointing at the data tables' rowids,
+// which VACUUM is free to renumber.
+const (
+	createMentionsFTS = `CREATE VIRTUAL TABLE IF NOT EXISTS mentions_fts USING fts5(id UNINDEXED, headline)`
+	createProjectsFTS = `CREATE VIRTUAL TABLE IF NOT EXISTS projects_fts USING fts5(record_id UNINDEXED, ysws_name, first_name, last_name)`
+)
+
+// ftsAvailable reports whether the SQLite build supports FTS5. Without it, the search
+// tables are skipped and everything else is generated as usual.
+var ftsAvailable = detectFTS5()
+
+func detectFTS5() bool {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE VIRTUAL TABLE fts5_probe USING fts5(text)`)
+	return err == nil
+}
+
+// ftsTable names the search table holding each data table's text and its ID column
+var ftsTable = map[string]struct{ name, key string }{
+	"approved_projects":     {"projects_fts", "record_id"},
+	"ysws_project_mentions": {"mentions_fts", "id"},
+}
+
+// createFTSTables creates the search tables, if FTS5 is available
+func createFTSTables(db *sql.DB) error {
+	if !ftsAvailable {
+		return nil
+	}
+	if _, err := db.Exec(createMentionsFTS); err != nil {
+		return fmt.Errorf("creating mentions_fts table: %w", err)
+	}
+	if _, err := db.Exec(createProjectsFTS); err != nil {
+		return fmt.Errorf("creating projects_fts table: %w", err)
+	}
+	return nil
+}
+
+// prepareFTSInsert prepares an insert into a search table, or returns nil if FTS5 is
+// unavailable. The copy functions insert search rows alongside the data rows.
+func prepareFTSInsert(tx *sql.Tx, stmt string) (*sql.Stmt, error) {
+	if !ftsAvailable {
+		return nil, nil
+	}
+	return tx.Prepare(stmt)
+}
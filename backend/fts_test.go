@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestSearchTablesSupportMatchAfterMerge(t *testing.T) {
+	if !ftsAvailable {
+		t.Skip("SQLite build lacks FTS5")
+	}
+
+	insertMention := func(headline string) func(context.Context, Querier, *sql.DB, copyScope) (int, error) {
+		return func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			if _, err := db.ExecContext(ctx, `INSERT INTO ysws_project_mentions (id, headline) VALUES ('m1', ?)`, headline); err != nil {
+				return 0, err
+			}
+			_, err := db.ExecContext(ctx, `INSERT INTO mentions_fts (id, headline) VALUES ('m1', ?)`, headline)
+			return 1, err
+		}
+	}
+	noProjects := func(context.Context, Querier, *sql.DB, copyScope) (int, error) { return 0, nil }
+	db, path := openGeneratedDB(t)
+
+	withTableCopies(t, noProjects, insertMention("Teen builds a rocket"))
+	if _, err := copyTables(context.Background(), nil, db, path, copyScope{cfg: testConfig}, tableCopies); err != nil {
+		t.Fatalf("copyTables() error: %v", err)
+	}
+
+	// An incremental build replacing the mention must not leave its old search row behind
+	withTableCopies(t, noProjects, insertMention("Teen builds a submarine"))
+	if _, err := copyTables(context.Background(), nil, db, path, copyScope{cfg: testConfig, since: &watermarks{}}, tableCopies); err != nil {
+		t.Fatalf("incremental copyTables() error: %v", err)
+	}
+
+	var hits int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM mentions_fts WHERE mentions_fts MATCH 'rocket'`).Scan(&hits); err != nil {
+		t.Fatalf("MATCH query: %v", err)
+	}
+	if hits != 0 {
+		t.Errorf("found %d hits for the replaced headline, want 0", hits)
+	}
+	var id string
+	if err := db.QueryRow(`SELECT id FROM mentions_fts WHERE mentions_fts MATCH 'submarine'`).Scan(&id); err != nil || id != "m1" {
+		t.Errorf("MATCH 'submarine' = %q, %v, want m1", id, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// generationTimeout bounds how long a generation may spend copying from Postgres
+// (GENERATION_TIMEOUT), so a hung server can't hold generationMutex forever
+var generationTimeout = 10 * time.Minute
+
+// errGenerationTimeout is returned (wrapped) when a generation runs out of time
+var errGenerationTimeout = errors.New("database generation timed out")
+
+// minRows is the fewest rows each table must have for a generation to be cached (MIN_ROWS).
+// An empty copy usually means we're pointed at the wrong schema, and serving the last good
+// database beats replacing it with an empty one.
+var minRows = 1
+
+// errTooFewRows is returned (wrapped) when a generated database fails the minRows check
+var errTooFewRows = errors.New("generated database has too few rows")
+
+// checkMinRows returns an error if either table of a build has fewer than minRows rows
+func checkMinRows(built sqliteBuild) error {
+	if built.projectCount < minRows {
+		return fmt.Errorf("%w: %d approved_projects, MIN_ROWS is %d", errTooFewRows, built.projectCount, minRows)
+	}
+	if built.mentionCount < minRows {
+		return fmt.Errorf("%w: %d ysws_project_mentions, MIN_ROWS is %d", errTooFewRows, built.mentionCount, minRows)
+	}
+	return nil
+}
+
+// keptDB returns the database still cached after a generation failed with err, when the
+// failure was a build too small to replace it. That database is past cacheTTL, but it's
+// the last good data, and serving it beats a 503 until Postgres looks right again.
+func keptDB(err error) (string, bool) {
+	if !errors.Is(err, errTooFewRows) {
+		return "", false
+	}
+	entry, ok := fullCache.Get()
+	if !ok {
+		return "", false
+	}
+	if _, err := os.Stat(entry.path); err != nil {
+		return "", false
+	}
+	return entry.path, true
+}
+
+// markKeptDB sets the headers of a response serving keptDB's database
+func markKeptDB(w http.ResponseWriter) {
+	w.Header().Set("X-Cache", "STALE")
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+}
+
+// generationContext returns the context the Postgres copies of one generation run under
+func generationContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), generationTimeout)
+}
+
+// generationError marks err as a timeout if ctx's deadline passed, whatever error the
+// driver happened to report for it
+func generationError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %v", errGenerationTimeout, generationTimeout, err)
+	}
+	return err
+}
+
+// writeGenerationFailure responds to a failed generation: 503 when it timed out (the
+// database may just be slow, so retrying later can help), came back too small to trust,
+// or wouldn't fit on disk; 500 otherwise
+func writeGenerationFailure(w http.ResponseWriter, err error) {
+	if errors.Is(err, errGenerationTimeout) {
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, http.StatusServiceUnavailable, "Service Unavailable: database generation timed out")
+		return
+	}
+	if errors.Is(err, errTooFewRows) {
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, http.StatusServiceUnavailable, "Service Unavailable: generated database was empty")
+		return
+	}
+	if errors.Is(err, errInsufficientDiskSpace) {
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, http.StatusServiceUnavailable, "Service Unavailable: not enough disk space to generate the database")
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+}
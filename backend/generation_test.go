@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGenerationTimeoutAbortsCopies(t *testing.T) {
+	prev := generationTimeout
+	t.Cleanup(func() { generationTimeout = prev })
+	generationTimeout = 20 * time.Millisecond
+
+	// A Postgres query that hangs until the context gives up on it
+	hang := func(ctx context.Context, _ Querier, _ *sql.DB, _ copyScope) (int, error) {
+		<-ctx.Done()
+		return 0, errors.New("pq: canceling statement due to user request")
+	}
+	withTableCopies(t, hang, hang)
+	db, path := openGeneratedDB(t)
+
+	ctx, cancel := generationContext()
+	defer cancel()
+	_, err := copyTables(ctx, nil, db, path, copyScope{cfg: testConfig}, tableCopies)
+	err = generationError(ctx, err)
+	if !errors.Is(err, errGenerationTimeout) {
+		t.Fatalf("error = %v, want errGenerationTimeout", err)
+	}
+
+	rec := httptest.NewRecorder()
+	writeGenerationFailure(rec, err)
+	if rec.Code != 503 {
+		t.Errorf("status = %d, want 503 for a timed-out generation", rec.Code)
+	}
+}
+
+func TestWriteGenerationFailureOtherErrors(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeGenerationFailure(rec, errors.New("disk full"))
+	if rec.Code != 500 {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+}
+
+func TestEmptyGenerationIsRefused(t *testing.T) {
+	withTableCopies(t,
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			_, err := db.ExecContext(ctx, `INSERT INTO approved_projects (record_id) VALUES ('rec1')`)
+			return 1, err
+		},
+		func(context.Context, Querier, *sql.DB, copyScope) (int, error) { return 0, nil },
+	)
+
+	dir := t.TempDir()
+	_, err := buildAndCompress(testConfig, dir, dir, cacheEntry{}, nil)
+	if !errors.Is(err, errTooFewRows) {
+		t.Fatalf("buildAndCompress(testConfig, ) error = %v, want errTooFewRows", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("refused build left %d files, want none", len(files))
+	}
+
+	rec := httptest.NewRecorder()
+	writeGenerationFailure(rec, err)
+	if rec.Code != 503 || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestMinRowsThreshold(t *testing.T) {
+	prev := minRows
+	t.Cleanup(func() { minRows = prev })
+
+	minRows = 10
+	if err := checkMinRows(sqliteBuild{projectCount: 50, mentionCount: 9}); !errors.Is(err, errTooFewRows) {
+		t.Errorf("checkMinRows(9 mentions) = %v, want errTooFewRows", err)
+	}
+	if err := checkMinRows(sqliteBuild{projectCount: 10, mentionCount: 10}); err != nil {
+		t.Errorf("checkMinRows(10 of each) = %v, want nil", err)
+	}
+
+	minRows = 0
+	if err := checkMinRows(sqliteBuild{}); err != nil {
+		t.Errorf("checkMinRows() with MIN_ROWS=0 = %v, want nil", err)
+	}
+}
+
+// waitForBlockedGoroutines waits until n goroutines have stacks containing every one of
+// frames, failing the test if they don't within a few seconds
+func waitForBlockedGoroutines(t *testing.T, n int, frames ...string) {
+	t.Helper()
+	buf := make([]byte, 1<<20)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		blocked := 0
+	stacks:
+		for _, stack := range strings.Split(string(buf[:runtime.Stack(buf, true)]), "\n\n") {
+			for _, frame := range frames {
+				if !strings.Contains(stack, frame) {
+					continue stacks
+				}
+			}
+			blocked++
+		}
+		if blocked >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines blocked in %v, want %d", blocked, frames, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForJoinedGenerations waits until n goroutines are waiting on an in-flight
+// generation in generationGroup.Do
+func waitForJoinedGenerations(t *testing.T, n int) {
+	t.Helper()
+	waitForBlockedGoroutines(t, n, "sync.(*WaitGroup).Wait", "singleflight.(*Group).Do")
+}
+
+func TestSharedGenerationRunsOnceForConcurrentCallers(t *testing.T) {
+	var calls atomic.Int32
+	running := make(chan struct{})
+	release := make(chan struct{})
+	generate := func() (string, error) {
+		if calls.Add(1) == 1 {
+			close(running)
+		}
+		<-release
+		return "/cache/cached-db-1.db.zst", nil
+	}
+
+	const callers = 8
+	var finished sync.WaitGroup
+	paths := make([]string, callers)
+	finished.Add(callers)
+	call := func(i int) {
+		defer finished.Done()
+		paths[i], _ = sharedGeneration(generate)
+	}
+
+	// Start one generation, then let every other caller join it before it finishes
+	go call(0)
+	<-running
+	for i := 1; i < callers; i++ {
+		go call(i)
+	}
+	waitForJoinedGenerations(t, callers-1)
+	close(release)
+	finished.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("generation ran %d times, want 1", n)
+	}
+	for i, path := range paths {
+		if path != "/cache/cached-db-1.db.zst" {
+			t.Errorf("caller %d got path %q", i, path)
+		}
+	}
+}
+
+func TestTooFewRowsServesThePreviousDatabase(t *testing.T) {
+	prev := regenerate
+	t.Cleanup(func() { regenerate = prev })
+	regenerate = func(*Config) (string, error) {
+		return "", fmt.Errorf("%w: approved_projects has 0 rows", errTooFewRows)
+	}
+
+	// Without a previous database there's nothing to fall back on
+	withCacheEntry(t, cacheEntry{})
+	rec := httptest.NewRecorder()
+	handleDBDownload(testConfig, rec, httptest.NewRequest("GET", "/db", nil), formatZstd, "attachment", time.Now())
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("without a cached database: status = %d, want 503", rec.Code)
+	}
+
+	// An expired one is served rather than a 503, marked as stale
+	withCachedFile(t, "previous-database", cacheTTL+time.Hour)
+	rec = httptest.NewRecorder()
+	handleDBDownload(testConfig, rec, httptest.NewRequest("GET", "/db", nil), formatZstd, "attachment", time.Now())
+	if rec.Code != http.StatusOK || rec.Body.String() != "previous-database" {
+		t.Fatalf("status = %d, body = %q; want 200 with the previous database", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Cache"); got != "STALE" {
+		t.Errorf("X-Cache = %q, want STALE", got)
+	}
+	if got := rec.Header().Get("Warning"); got == "" {
+		t.Error("no Warning header on the previous database")
+	}
+
+	// Any other failure still fails the request
+	regenerate = func(*Config) (string, error) { return "", errors.New("connection refused") }
+	rec = httptest.NewRecorder()
+	handleDBDownload(testConfig, rec, httptest.NewRequest("GET", "/db", nil), formatZstd, "attachment", time.Now())
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("other error: status = %d, want 500", rec.Code)
+	}
+}
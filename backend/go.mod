@@ -3,9 +3,12 @@ module backend
 go 1.21
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/andybalholm/brotli v1.1.1
 	github.com/joho/godotenv v1.5.1
 	github.com/klauspost/compress v1.17.4
 	github.com/lib/pq v1.10.9
+	golang.org/x/sync v0.2.0
 	modernc.org/sqlite v1.28.0
 )
 
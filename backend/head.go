@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headGenerates makes HEAD /db generate the database when there's no fresh cache.
+// By default it answers 503 instead, so a cheap size check never costs a generation.
+var headGenerates = false
+
+// dbETag identifies one representation of a cached database file, preferring the
+// ETag recorded when it was cached
+func dbETag(compressedPath string, info os.FileInfo, format string) string {
+	etag := fileETag(info)
+	if entry, ok := fullCache.Lookup(compressedPath); ok && entry.etag != "" {
+		etag = entry.etag
+	}
+	return fmt.Sprintf(`"%s-%s"`, etag, format)
+}
+
+// dbLastModified is when the data in a cached database file last changed: the newest
+// timestamp recorded at generation time, or the file's modification time if there's none
+func dbLastModified(compressedPath string, info os.FileInfo) time.Time {
+	if entry, ok := fullCache.Lookup(compressedPath); ok && !entry.dataModifiedAt.IsZero() {
+		return entry.dataModifiedAt
+	}
+	return info.ModTime()
+}
+
+// etagListMatches reports whether an If-None-Match value names etag, comparing weakly
+// (a W/ prefix on either side is ignored) as RFC 9110 requires for If-None-Match
+func etagListMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// dbNotModified answers a conditional GET or HEAD with 304 Not Modified, and returns true,
+// when the client already has compressedPath in this format: If-None-Match names its
+// ETag or, if there's no If-None-Match, If-Modified-Since is no earlier than its
+// Last-Modified. Otherwise it writes nothing, so the caller serves the database.
+func dbNotModified(w http.ResponseWriter, r *http.Request, compressedPath, format string) bool {
+	info, err := os.Stat(compressedPath)
+	if err != nil {
+		return false
+	}
+	etag := dbETag(compressedPath, info, format)
+	lastModified := dbLastModified(compressedPath, info).UTC().Truncate(time.Second)
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagListMatches(match, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || lastModified.After(since) {
+		return false
+	}
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// setDBHeaders sets the response headers for serving compressedPath in the given format.
+// GET and HEAD share it so a HEAD reports exactly what the download would.
+func setDBHeaders(w http.ResponseWriter, compressedPath, format, disposition string) error {
+	info, err := os.Stat(compressedPath)
+	if err != nil {
+		return fmt.Errorf("stat cached database: %w", err)
+	}
+
+	h := w.Header()
+	h.Set("Content-Disposition", disposition)
+	h.Set("ETag", dbETag(compressedPath, info, format))
+	h.Set("Last-Modified", dbLastModified(compressedPath, info).UTC().Format(http.TimeFormat))
+	h.Set("X-Schema-Version", strconv.Itoa(schemaVersion))
+
+	switch format {
+	case formatSQLite:
+		h.Set("Content-Type", "application/vnd.sqlite3")
+		h.Set("Content-Transfer-Encoding", "binary")
+		// Use the size recorded at generation time; if it's unknown the response is sent chunked
+		if size := uncompressedSizeFor(compressedPath); size > 0 {
+			h.Set("Content-Length", fmt.Sprintf("%d", size))
+		}
+	case formatGzip:
+		// The gzip size isn't known upfront, so the response is sent chunked
+		h.Set("Content-Type", "application/vnd.sqlite3")
+		h.Set("Content-Encoding", "gzip")
+	case formatBrotli:
+		h.Set("Content-Type", "application/vnd.sqlite3")
+		h.Set("Content-Encoding", "br")
+		// The size is only known once the .br file has been built; HEAD doesn't build it
+		if path, ok := brotliFiles.Lookup(compressedPath); ok {
+			if brInfo, err := os.Stat(path); err == nil {
+				h.Set("Content-Length", fmt.Sprintf("%d", brInfo.Size()))
+			}
+		}
+	case formatZstdDict:
+		h.Set("Content-Type", "application/zstd")
+		h.Set("Content-Transfer-Encoding", "binary")
+		h.Set("X-Zstd-Dictionary-ID", strconv.FormatUint(uint64(zstdDictID), 10))
+		// As with Brotli, the size is only known once the file has been built
+		if path, ok := dictZstdFiles.Lookup(compressedPath); ok {
+			if dictInfo, err := os.Stat(path); err == nil {
+				h.Set("Content-Length", fmt.Sprintf("%d", dictInfo.Size()))
+			}
+		}
+	default:
+		h.Set("Content-Type", "application/zstd")
+		h.Set("Content-Transfer-Encoding", "binary")
+		h.Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+		// The digest covers the .zst file, so it only describes this format
+		if entry, ok := fullCache.Lookup(compressedPath); ok && entry.sha256 != "" {
+			if digest, err := digestHeader(entry.sha256); err == nil {
+				h.Set("Digest", digest)
+			}
+		}
+		// Informational, for debugging bandwidth without reading the logs
+		if size := uncompressedSizeFor(compressedPath); size > 0 {
+			h.Set("X-Uncompressed-Size", fmt.Sprintf("%d", size))
+		}
+		if ratio := compressionRatioFor(compressedPath); ratio > 0 {
+			h.Set("X-Compression-Ratio", fmt.Sprintf("%.2f", ratio))
+		}
+	}
+	return nil
+}
+
+// handleDBHead answers HEAD /db with the headers a GET would send, without a body.
+// It never generates unless HEAD_GENERATES is set; without a fresh cache it returns 503,
+// unless REQUEST_LATENCY_BUDGET would have a GET serve a stale one.
+func handleDBHead(cfg *Config, w http.ResponseWriter, r *http.Request, subset dbSubset, format, disposition string) {
+	var path string
+	var fromCache, stale bool
+	if subset.isFull() {
+		path, fromCache = getCachedDB()
+		if !fromCache && latencyBudgetEnabled {
+			path, _, stale = getStaleDB(maxStale)
+		}
+	} else {
+		path, fromCache = getSubsetDB(subset.key())
+	}
+
+	if fromCache {
+		w.Header().Set("X-Cache", "HIT")
+	} else if stale {
+		// Describe the database a GET would serve while it refreshes in the background
+		if headGenerates {
+			startBackgroundRefresh(cfg)
+		}
+		w.Header().Set("X-Cache", "STALE")
+	} else {
+		if !headGenerates {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var err error
+		if subset.isFull() {
+			path, err = regenerate(cfg)
+		} else {
+			path, err = generateSubsetDB(cfg, requestLog(r), subset)
+		}
+		if err != nil {
+			metrics.observeGenerationFailure()
+			kept, ok := keptDB(err)
+			if !ok || !subset.isFull() {
+				requestLog(r).Error("Failed to generate database for HEAD: %v", err)
+				writeGenerationFailure(w, err)
+				return
+			}
+			// As a GET would, describe the database that's still served
+			requestLog(r).Warn("Generated database was discarded, describing the previous one: %v", err)
+			markKeptDB(w)
+			path = kept
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+	}
+
+	if dbNotModified(w, r, path, format) {
+		return
+	}
+	if err := setDBHeaders(w, path, format, disposition); err != nil {
+		requestLog(r).Error("Failed to read cached database: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDBHeadMatchesGetHeaders(t *testing.T) {
+	withCachedDatabase(t, bytes.Repeat([]byte("SQLite format 3\x00 pretend database page "), 100))
+
+	for _, accept := range []string{"zstd", "identity"} {
+		get := httptest.NewRecorder()
+		getReq := httptest.NewRequest("GET", "/db", nil)
+		getReq.Header.Set("Accept-Encoding", accept)
+		dbHandler(testConfig)(get, getReq)
+
+		head := httptest.NewRecorder()
+		headReq := httptest.NewRequest("HEAD", "/db", nil)
+		headReq.Header.Set("Accept-Encoding", accept)
+		dbHandler(testConfig)(head, headReq)
+
+		if head.Code != 200 {
+			t.Fatalf("%s: HEAD status = %d, want 200", accept, head.Code)
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("%s: HEAD wrote a %d byte body", accept, head.Body.Len())
+		}
+		for _, name := range []string{"Content-Type", "Content-Length", "ETag", "Last-Modified", "X-Cache"} {
+			if got, want := head.Header().Get(name), get.Header().Get(name); got == "" || got != want {
+				t.Errorf("%s: HEAD %s = %q, GET sent %q", accept, name, got, want)
+			}
+		}
+	}
+}
+
+func TestDBLastModifiedFollowsData(t *testing.T) {
+	withCachedDatabase(t, []byte("SQLite format 3\x00"))
+	entry, _ := fullCache.Get()
+	entry.dataModifiedAt = time.Date(2024, 7, 4, 12, 0, 0, 0, time.UTC)
+	fullCache.Set(entry)
+
+	rec := httptest.NewRecorder()
+	dbHandler(testConfig)(rec, httptest.NewRequest("GET", "/db", nil))
+	if got := rec.Header().Get("Last-Modified"); got != "Thu, 04 Jul 2024 12:00:00 GMT" {
+		t.Errorf("Last-Modified = %q, want the data's newest timestamp", got)
+	}
+}
+
+func TestDBHeadDoesNotGenerateByDefault(t *testing.T) {
+	withCachedFile(t, "expired", cacheTTL+time.Minute)
+
+	prevRegenerate, prevGenerates := regenerate, headGenerates
+	t.Cleanup(func() { regenerate, headGenerates = prevRegenerate, prevGenerates })
+	regenerate = func(*Config) (string, error) {
+		t.Error("HEAD triggered a generation")
+		return "", nil
+	}
+	headGenerates = false
+
+	rec := httptest.NewRecorder()
+	dbHandler(testConfig)(rec, httptest.NewRequest("HEAD", "/db", nil))
+
+	if rec.Code != 503 {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("503 is missing Retry-After")
+	}
+}
+
+func TestDBHeadDescribesStaleWithinLatencyBudget(t *testing.T) {
+	withCachedFile(t, "stale-database", cacheTTL+time.Minute)
+
+	prevRegenerate, prevGenerates := regenerate, headGenerates
+	prevEnabled, prevMaxStale := latencyBudgetEnabled, maxStale
+	t.Cleanup(func() {
+		regenerate, headGenerates = prevRegenerate, prevGenerates
+		latencyBudgetEnabled, maxStale = prevEnabled, prevMaxStale
+	})
+	regenerate = func(*Config) (string, error) {
+		t.Error("HEAD triggered a generation")
+		return "", nil
+	}
+	headGenerates = false
+	latencyBudgetEnabled = true
+
+	// A GET would serve the stale database, so HEAD describes it instead of a 503
+	maxStale = time.Hour
+	rec := httptest.NewRecorder()
+	dbHandler(testConfig)(rec, httptest.NewRequest("HEAD", "/db", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("X-Cache"); got != "STALE" {
+		t.Errorf("X-Cache = %q, want STALE", got)
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(len("stale-database")) {
+		t.Errorf("Content-Length = %q, want the stale database's size", got)
+	}
+
+	// Past MAX_STALE a GET would block on generation, so HEAD still returns 503
+	maxStale = time.Second
+	rec = httptest.NewRecorder()
+	dbHandler(testConfig)(rec, httptest.NewRequest("HEAD", "/db", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("past MAX_STALE: status = %d, want 503", rec.Code)
+	}
+}
+
+func TestDBConditionalRequests(t *testing.T) {
+	contents := []byte("SQLite format 3\x00 pretend database")
+	withCachedDatabase(t, contents)
+
+	first := httptest.NewRecorder()
+	dbHandler(testConfig)(first, httptest.NewRequest("GET", "/db", nil))
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("GET /db sent ETag %q and Last-Modified %q, want both", etag, lastModified)
+	}
+
+	tests := []struct {
+		name, method, header, value string
+		want                        int
+	}{
+		{"matching ETag", "GET", "If-None-Match", etag, http.StatusNotModified},
+		{"ETag in a list", "GET", "If-None-Match", `"other", W/` + etag, http.StatusNotModified},
+		{"mismatched ETag", "GET", "If-None-Match", `"some-older-database-zstd"`, http.StatusOK},
+		{"unchanged since", "GET", "If-Modified-Since", lastModified, http.StatusNotModified},
+		{"changed since", "GET", "If-Modified-Since", "Mon, 01 Jan 2001 00:00:00 GMT", http.StatusOK},
+		{"HEAD with matching ETag", "HEAD", "If-None-Match", etag, http.StatusNotModified},
+		{"HEAD with mismatched ETag", "HEAD", "If-None-Match", `"some-older-database-zstd"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/db", nil)
+		req.Header.Set(tt.header, tt.value)
+		rec := httptest.NewRecorder()
+		dbHandler(testConfig)(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+			continue
+		}
+		if rec.Code == http.StatusNotModified {
+			if rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+				t.Errorf("%s: 304 with a %d byte body and ETag %q", tt.name, rec.Body.Len(), rec.Header().Get("ETag"))
+			}
+		} else if tt.method == "GET" && rec.Body.Len() == 0 {
+			t.Errorf("%s: 200 without the database", tt.name)
+		}
+	}
+
+	// Each format is its own representation, so another format's ETag doesn't match
+	req := httptest.NewRequest("GET", "/db.sqlite", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	dbSQLiteHandler(testConfig)(rec, req)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), contents) {
+		t.Errorf("/db.sqlite with the zstd ETag: status = %d, want 200 with the database", rec.Code)
+	}
+}
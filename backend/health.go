@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// healthzResponse is the body returned by /healthz
+type healthzResponse struct {
+	Status             string `json:"status"`
+	SchemaVersion      int    `json:"schema_version"`
+	ExpectedSchemaHash string `json:"expected_schema_hash"`
+	SchemaHash         string `json:"schema_hash,omitempty"`
+}
+
+// healthzHandler reports liveness along with the schema hash of the cached database.
+// It's unauthenticated and never triggers a database generation.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	entry, _ := fullCache.Get()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(healthzResponse{
+		Status:             "ok",
+		SchemaVersion:      schemaVersion,
+		ExpectedSchemaHash: expectedSchemaHash,
+		SchemaHash:         entry.schemaHash,
+	})
+}
+
+// readyResponse is the body returned by /ready
+type readyResponse struct {
+	Status string `json:"status"`
+}
+
+// isReady reports whether a database has been generated at least once, or a valid
+// cache is in place, so requests won't block on a cold generation
+func isReady() bool {
+	if fullCache.Ready() {
+		return true
+	}
+	_, ok := getCachedDB()
+	return ok
+}
+
+// readyHandler is the readiness probe: 503 until the first database is ready, then 200.
+// Like /healthz it's unauthenticated and never triggers a generation.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(readyResponse{Status: "starting"})
+		return
+	}
+	json.NewEncoder(w).Encode(readyResponse{Status: "ready"})
+}
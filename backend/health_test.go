@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyHandler(t *testing.T) {
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		readyHandler(rec, httptest.NewRequest("GET", "/ready", nil))
+		return rec.Code
+	}
+
+	setReady := func(ready bool) {
+		fullCache.mu.Lock()
+		fullCache.ready = ready
+		fullCache.mu.Unlock()
+	}
+
+	// An expired cache without a completed generation isn't ready
+	withCachedFile(t, "expired", cacheTTL+time.Minute)
+	setReady(false)
+	if code := ready(); code != 503 {
+		t.Errorf("status before the first generation = %d, want 503", code)
+	}
+
+	// A valid cache is enough on its own
+	withCachedFile(t, "fresh", time.Minute)
+	if code := ready(); code != 200 {
+		t.Errorf("status with a valid cache = %d, want 200", code)
+	}
+
+	// Once a generation has completed, an expired cache stays ready
+	withCachedFile(t, "expired", cacheTTL+time.Minute)
+	setReady(true)
+	if code := ready(); code != 200 {
+		t.Errorf("status after the first generation = %d, want 200", code)
+	}
+}
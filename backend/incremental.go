@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// incrementalEnabled (INCREMENTAL=true) builds each database from a copy of the previous one,
+// pulling only rows at or after the previous maximum timestamps. Rows that change or
+// disappear in Postgres without a newer timestamp aren't picked up, so a full
+// generation still runs whenever there's no usable previous database.
+var incrementalEnabled bool
+
+// watermarks are the newest timestamps in a previous database. Mentions are tracked by
+// when their link was found rather than their publication date, since a newly found
+// mention can be of an old post. Empty means the table had no timestamped rows, so
+// everything is copied.
+type watermarks struct {
+	approvedAt     string
+	mentionFoundAt string
+}
+
+// prepareIncremental decompresses the previous database into dbPath and reads its
+// watermarks. It fails (so the caller falls back to a full generation) if there's no
+// previous file, its schema doesn't match the current one, or its email hashes weren't
+// made in cfg's mode, since the new rows would be hashed differently from the old.
+func prepareIncremental(cfg *Config, previous cacheEntry, dbPath string) (*watermarks, error) {
+	if previous.path == "" {
+		return nil, fmt.Errorf("no previous database")
+	}
+	if previous.emailHashes != cfg.emailHashMode() {
+		return nil, fmt.Errorf("previous database's email hashes were made with a different EMAIL_SALT or EMAIL_HASH_PREFIX")
+	}
+	if _, err := os.Stat(previous.path); err != nil {
+		return nil, fmt.Errorf("previous database missing: %w", err)
+	}
+
+	if err := decompressZstdFile(previous.path, dbPath); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", buildDSN(dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("opening previous database: %w", err)
+	}
+	defer db.Close()
+
+	hash, err := schemaHash(db)
+	if err != nil {
+		return nil, err
+	}
+	if hash != expectedSchemaHash {
+		return nil, fmt.Errorf("previous database has a different schema")
+	}
+
+	return readWatermarks(db)
+}
+
+// readWatermarks returns the newest approved_at and mention link_found_at in a generated database
+func readWatermarks(db *sql.DB) (*watermarks, error) {
+	var approvedAt, mentionFoundAt sql.NullString
+	if err := db.QueryRow(`SELECT MAX(approved_at) FROM approved_projects`).Scan(&approvedAt); err != nil {
+		return nil, fmt.Errorf("reading approved_at watermark: %w", err)
+	}
+	if err := db.QueryRow(`SELECT MAX(link_found_at) FROM ysws_project_mentions`).Scan(&mentionFoundAt); err != nil {
+		return nil, fmt.Errorf("reading mention link_found_at watermark: %w", err)
+	}
+	return &watermarks{approvedAt: approvedAt.String, mentionFoundAt: mentionFoundAt.String}, nil
+}
+
+// watermarkLayouts are the timestamp formats seen in approved_at and link_found_at
+var watermarkLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// latest returns the newer of the two watermarks as a time, i.e. when the data last
+// changed, or the zero time if neither parses
+func (w watermarks) latest() time.Time {
+	var latest time.Time
+	for _, value := range []string{w.approvedAt, w.mentionFoundAt} {
+		for _, layout := range watermarkLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				if t.After(latest) {
+					latest = t
+				}
+				break
+			}
+		}
+	}
+	return latest
+}
+
+// countRows returns the number of rows in a table of the generated database
+func countRows(db *sql.DB, table string) (int, error) {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&count); err != nil {
+		return 0, fmt.Errorf("counting %s: %w", table, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cachedEntry returns the cached database installed by the test
+func cachedEntry(t *testing.T) cacheEntry {
+	t.Helper()
+	entry, ok := fullCache.Get()
+	if !ok {
+		t.Fatal("no cached database installed")
+	}
+	return entry
+}
+
+func TestPrepareIncrementalReadsWatermarks(t *testing.T) {
+	withCachedDatabase(t, buildTestDatabase(t,
+		`INSERT INTO approved_projects (record_id, approved_at) VALUES ('rec1', '2024-05-01'), ('rec2', '2024-06-15')`,
+		// m1 is an old post whose link was only just found
+		`INSERT INTO ysws_project_mentions (id, date, link_found_at) VALUES
+			('m1', '2019-02-01', '2024-07-04'), ('m2', '2024-06-30', '2024-07-01')`,
+	))
+
+	since, err := prepareIncremental(testConfig, cachedEntry(t), filepath.Join(t.TempDir(), "next.db"))
+	if err != nil {
+		t.Fatalf("prepareIncremental() error: %v", err)
+	}
+	if since.approvedAt != "2024-06-15" || since.mentionFoundAt != "2024-07-04" {
+		t.Errorf("watermarks = %+v, want approved_at 2024-06-15 and mention link_found_at 2024-07-04", *since)
+	}
+}
+
+func TestWatermarksLatest(t *testing.T) {
+	tests := []struct {
+		marks watermarks
+		want  time.Time
+	}{
+		{watermarks{approvedAt: "2024-06-15T10:30:00Z", mentionFoundAt: "2024-07-04"}, time.Date(2024, 7, 4, 0, 0, 0, 0, time.UTC)},
+		{watermarks{approvedAt: "2024-08-01 09:00:00", mentionFoundAt: "2024-07-04"}, time.Date(2024, 8, 1, 9, 0, 0, 0, time.UTC)},
+		{watermarks{approvedAt: "not a date", mentionFoundAt: "2024-07-04"}, time.Date(2024, 7, 4, 0, 0, 0, 0, time.UTC)},
+		{watermarks{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := tt.marks.latest(); !got.Equal(tt.want) {
+			t.Errorf("%+v.latest() = %s, want %s", tt.marks, got, tt.want)
+		}
+	}
+}
+
+func TestPrepareIncrementalFallsBack(t *testing.T) {
+	if _, err := prepareIncremental(testConfig, cacheEntry{}, filepath.Join(t.TempDir(), "next.db")); err == nil {
+		t.Error("prepareIncremental() succeeded without a previous database")
+	}
+
+	// A database with an extra table no longer matches the expected schema
+	withCachedDatabase(t, buildTestDatabase(t, `CREATE TABLE extra (id TEXT)`))
+	if _, err := prepareIncremental(testConfig, cachedEntry(t), filepath.Join(t.TempDir(), "next.db")); err == nil {
+		t.Error("prepareIncremental() accepted a database with a different schema")
+	}
+
+	// Nor is one whose email hashes another salt or prefix mode made
+	withCachedDatabase(t, buildTestDatabase(t))
+	if _, err := prepareIncremental(testConfig, cachedEntry(t), filepath.Join(t.TempDir(), "next.db")); err != nil {
+		t.Fatalf("prepareIncremental() error: %v", err)
+	}
+	other := &Config{EmailSalt: "another-salt-entirely"}
+	if _, err := prepareIncremental(other, cachedEntry(t), filepath.Join(t.TempDir(), "next.db")); err == nil {
+		t.Error("prepareIncremental() accepted a database hashed with another salt")
+	}
+	prev := emailHashPrefixed
+	t.Cleanup(func() { emailHashPrefixed = prev })
+	emailHashPrefixed = !prev
+	if _, err := prepareIncremental(testConfig, cachedEntry(t), filepath.Join(t.TempDir(), "next.db")); err == nil {
+		t.Error("prepareIncremental() accepted a database hashed in the other prefix mode")
+	}
+}
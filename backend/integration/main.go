@@ -0,0 +1,64 @@
+// Command integration runs the backend's integration tests against a throwaway Postgres.
+// It lives in its own module so testcontainers and the Docker client stay out of the
+// server's dependencies. From this directory:
+//
+//	go run .
+//
+// Extra arguments are passed on to go test, e.g. go run . -run ScopeToYSWS -v
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run starts Postgres, runs go test -tags integration in the backend module against it,
+// and returns the exit code to finish with
+func run(testArgs []string) int {
+	ctx := context.Background()
+
+	container, err := postgres.RunContainer(ctx,
+		testcontainers.WithImage("postgres:16-alpine"),
+		postgres.WithDatabase("warehouse"),
+		postgres.WithUsername("test"),
+		postgres.WithPassword("test"),
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+			WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Starting Postgres container: %v\n", err)
+		return 1
+	}
+	defer container.Terminate(ctx)
+
+	dsn, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Getting connection string: %v\n", err)
+		return 1
+	}
+
+	args := append([]string{"test", "-tags", "integration", "-count=1", "./..."}, testArgs...)
+	cmd := exec.Command("go", args...)
+	cmd.Dir = ".."
+	cmd.Env = append(os.Environ(), "INTEGRATION_POSTGRES_URL="+dsn)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "Running go test: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package main
+
+import "net/http"
+
+// ipAllowlist restricts the authenticated routes to these networks (IP_ALLOWLIST), as
+// defense in depth on top of API keys. When nil, every address is allowed.
+var ipAllowlist cidrList
+
+// ipAllowlistMiddleware rejects clients outside ipAllowlist with 403. The client address
+// comes from clientIP, so X-Forwarded-For counts only from trusted proxies.
+func ipAllowlistMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ipAllowlist == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		if ip == nil || !ipAllowlist.contains(ip) {
+			requestLog(r).Warn("Rejected request from %v: not in IP_ALLOWLIST", ip)
+			writeJSONError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPAllowlistMiddleware(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.1")
+	prev := ipAllowlist
+	t.Cleanup(func() { ipAllowlist = prev })
+	var err error
+	if ipAllowlist, err = parseCIDRList("192.0.2.0/24, 2001:db8::/32"); err != nil {
+		t.Fatalf("parseCIDRList() error: %v", err)
+	}
+
+	handler := ipAllowlistMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		remoteAddr, forwarded string
+		want                  int
+	}{
+		{"192.0.2.10:1234", "", http.StatusOK},
+		{"[2001:db8::5]:1234", "", http.StatusOK},
+		{"198.51.100.1:1234", "", http.StatusForbidden},
+		{"198.51.100.1:1234", "192.0.2.10", http.StatusForbidden}, // untrusted peer can't claim an address
+		{"10.0.0.1:1234", "192.0.2.10", http.StatusOK},
+		{"10.0.0.1:1234", "198.51.100.1", http.StatusForbidden},
+		{"10.0.0.1:1234", "192.0.2.10, 198.51.100.1", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/db", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("%s via %q: status %d, want %d", tt.remoteAddr, tt.forwarded, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestIPAllowlistMiddlewareAllowsAllWhenUnset(t *testing.T) {
+	prev := ipAllowlist
+	ipAllowlist = nil
+	t.Cleanup(func() { ipAllowlist = prev })
+
+	handler := ipAllowlistMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	r := httptest.NewRequest("GET", "/db", nil)
+	r.RemoteAddr = "198.51.100.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d, want 200 with no allowlist", rec.Code)
+	}
+}
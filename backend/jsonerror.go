@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// errorResponse is the JSON body of every error response
+type errorResponse struct {
+	Error     string `json:"error"`
+	Path      string `json:"path,omitempty"` // the unmatched path, on 404s
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeJSONError responds with status and {"error": message, "request_id": ...}, used for
+// every error in place of http.Error. Like http.Error it drops a Content-Length meant for
+// some other body and replaces the Content-Type.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeErrorResponse(w, status, errorResponse{Error: message})
+}
+
+// writeErrorResponse writes body with status, filling in the request ID loggingMiddleware
+// put on the response so the error can be matched to the server logs
+func writeErrorResponse(w http.ResponseWriter, status int, body errorResponse) {
+	h := w.Header()
+	body.RequestID = h.Get("X-Request-ID")
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// notFoundHandler answers paths no route matches. It's the catch-all of the authenticated
+// mux, so without a valid key an unknown path gets the same 401 as a real one.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeErrorResponse(w, http.StatusNotFound, errorResponse{Error: "not found", Path: r.URL.Path})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// decodeErrorResponse checks rec holds a JSON error with status and returns its body
+func decodeErrorResponse(t *testing.T, rec *httptest.ResponseRecorder, status int) errorResponse {
+	t.Helper()
+	if rec.Code != status || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, Content-Type %q; want %d JSON", rec.Code, rec.Header().Get("Content-Type"), status)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return body
+}
+
+func TestUnknownPathIsJSONNotFoundOnlyWhenAuthenticated(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/db", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	mux.Handle("/", http.HandlerFunc(notFoundHandler))
+	handler := authMiddleware(&Config{APIKeys: []apiKeyEntry{{Name: "default", Key: "main-key"}}}, mux)
+
+	// Without a key, unknown and real paths look the same
+	for _, path := range []string{"/nope", "/db"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if body := decodeErrorResponse(t, rec, http.StatusUnauthorized); body.Error == "" || body.Path != "" {
+			t.Errorf("%s without a key = %+v, want a 401 error", path, body)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/nope/deeper", nil)
+	req.Header.Set("X-API-Key", "main-key")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if body := decodeErrorResponse(t, rec, http.StatusNotFound); body != (errorResponse{Error: "not found", Path: "/nope/deeper"}) {
+		t.Errorf("unknown path = %+v, want not found with its path", body)
+	}
+}
+
+func TestWriteJSONErrorReplacesContentHeaders(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/zstd")
+	rec.Header().Set("Content-Length", "12345")
+	writeJSONError(rec, http.StatusServiceUnavailable, "Service Unavailable: too many downloads in progress")
+
+	body := decodeErrorResponse(t, rec, http.StatusServiceUnavailable)
+	if body.Error != "Service Unavailable: too many downloads in progress" {
+		t.Errorf("error = %q, want the message", body.Error)
+	}
+	if rec.Header().Get("Content-Length") != "" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("headers = %v, want no stale Content-Length and nosniff", rec.Header())
+	}
+}
+
+func TestUnauthorizedErrorIsJSONWithRequestID(t *testing.T) {
+	handler := loggingMiddleware(authMiddleware(&Config{APIKeys: []apiKeyEntry{{Name: "default", Key: "main-key"}}},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("X-Request-ID", "req-401")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	body := decodeErrorResponse(t, rec, http.StatusUnauthorized)
+	if body != (errorResponse{Error: "Unauthorized: API key is required", RequestID: "req-401"}) {
+		t.Errorf("401 body = %+v, want the error with the request ID", body)
+	}
+}
+
+func TestInternalServerErrorIsJSONWithRequestID(t *testing.T) {
+	// The cached file vanished between the cache lookup and serving it
+	handler := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveCachedDB(w, r, filepath.Join(t.TempDir(), "missing.db.zst"), "attachment", time.Now())
+	}))
+
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("X-Request-ID", "req-500")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	body := decodeErrorResponse(t, rec, http.StatusInternalServerError)
+	if body != (errorResponse{Error: "Internal Server Error", RequestID: "req-500"}) {
+		t.Errorf("500 body = %+v, want the error with the request ID", body)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Sizes for GET /leaderboard. The top maxLeaderboardLimit are computed once per
+// database and every request is served from a prefix of that.
+const (
+	defaultLeaderboardLimit = 20
+	maxLeaderboardLimit     = 100
+)
+
+// leaderboardEntry is one project on the leaderboard. Projects have no title of their
+// own, so they're named by maker and program, as in the search tables.
+type leaderboardEntry struct {
+	RecordID                string  `json:"record_id"`
+	FirstName               *string `json:"first_name"`
+	YSWSName                *string `json:"ysws_name"`
+	PlayableURL             *string `json:"playable_url"`
+	CodeURL                 *string `json:"code_url"`
+	TotalMentions           int     `json:"total_mentions"`
+	TotalWeightedEngagement float64 `json:"total_weighted_engagement"`
+}
+
+// The leaderboard is computed once per cached database and reused until the cache changes
+var (
+	leaderboardMutex  sync.Mutex
+	leaderboardSource string
+	leaderboardCached []leaderboardEntry
+)
+
+// computeLeaderboard returns up to limit projects by total weighted engagement, read
+// from project_engagement_summary. Ties are broken by record_id so the order is stable.
+func computeLeaderboard(db *sql.DB, limit int) ([]leaderboardEntry, error) {
+	rows, err := db.Query(`
+		SELECT
+			ap.record_id,
+			ap.first_name,
+			ap.ysws_name,
+			ap.playable_url,
+			ap.code_url,
+			s.total_mentions,
+			s.total_weighted_engagement
+		FROM project_engagement_summary s
+		JOIN approved_projects ap ON ap.record_id = s.record_id
+		ORDER BY s.total_weighted_engagement DESC, ap.record_id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying project_engagement_summary: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []leaderboardEntry{}
+	for rows.Next() {
+		var e leaderboardEntry
+		if err := rows.Scan(&e.RecordID, &e.FirstName, &e.YSWSName, &e.PlayableURL, &e.CodeURL,
+			&e.TotalMentions, &e.TotalWeightedEngagement); err != nil {
+			return nil, fmt.Errorf("reading project_engagement_summary: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading project_engagement_summary: %w", err)
+	}
+	return entries, nil
+}
+
+// getLeaderboard returns the leaderboard for the given cached database, computing it
+// on first use
+func getLeaderboard(log *Logger, compressedPath string) ([]leaderboardEntry, error) {
+	leaderboardMutex.Lock()
+	defer leaderboardMutex.Unlock()
+
+	if leaderboardCached != nil && leaderboardSource == compressedPath {
+		return leaderboardCached, nil
+	}
+
+	db, release, err := openQueryDB(compressedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var entries []leaderboardEntry
+	err = retryIfBusy(log, func() (err error) {
+		entries, err = computeLeaderboard(db, maxLeaderboardLimit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	leaderboardSource = compressedPath
+	leaderboardCached = entries
+	return entries, nil
+}
+
+// leaderboardHandler returns the most-viral projects as JSON, computed from the cached
+// SQLite database rather than Postgres
+func leaderboardHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultLeaderboardLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxLeaderboardLimit {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad Request: limit must be between 1 and %d", maxLeaderboardLimit))
+				return
+			}
+			limit = n
+		}
+
+		path, err := ensureDB(cfg)
+		if err != nil {
+			requestLog(r).Error("Failed to prepare database for leaderboard: %v", err)
+			writeGenerationFailure(w, err)
+			return
+		}
+
+		entries, err := getLeaderboard(requestLog(r), path)
+		if err != nil {
+			requestLog(r).Error("Failed to compute leaderboard: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		if len(entries) > limit {
+			entries = entries[:limit]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
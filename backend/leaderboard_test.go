@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLeaderboardHandler(t *testing.T) {
+	withCachedDatabase(t, buildTestDatabase(t,
+		`INSERT INTO approved_projects (record_id, first_name, code_url) VALUES
+			('rec1', 'Ada', 'https://github.com/ada/one'), ('rec2', 'Bo', NULL), ('rec3', 'Cy', NULL)`,
+		`INSERT INTO project_engagement_summary (record_id, total_mentions, total_weighted_engagement, distinct_sources) VALUES
+			('rec1', 2, 15.5, 1), ('rec2', 0, 0, 0), ('rec3', 5, 40, 3)`,
+	))
+	t.Cleanup(closeQueryDB)
+
+	rec := httptest.NewRecorder()
+	leaderboardHandler(testConfig)(rec, httptest.NewRequest("GET", "/leaderboard?limit=2", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	var entries []leaderboardEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(entries) != 2 || entries[0].RecordID != "rec3" || entries[1].RecordID != "rec1" {
+		t.Fatalf("leaderboard = %+v, want rec3 then rec1", entries)
+	}
+	if entries[1].TotalWeightedEngagement != 15.5 || entries[1].CodeURL == nil || *entries[1].CodeURL != "https://github.com/ada/one" {
+		t.Errorf("leaderboard entry = %+v, want rec1's engagement and code_url", entries[1])
+	}
+
+	entry, _ := fullCache.Get()
+	if leaderboardSource != entry.path || len(leaderboardCached) != 3 {
+		t.Errorf("leaderboard not cached for the current database (source %q, %d entries)", leaderboardSource, len(leaderboardCached))
+	}
+
+	for _, target := range []string{"/leaderboard?limit=0", "/leaderboard?limit=101", "/leaderboard?limit=ten"} {
+		rec := httptest.NewRecorder()
+		leaderboardHandler(testConfig)(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != 400 {
+			t.Errorf("GET %s status = %d, want 400", target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// lookupRateLimitPerMinute caps /lookup calls per API key. It's far stricter than the
+// general limit since each call confirms whether an email address is in the dataset.
+var lookupRateLimitPerMinute = 10
+
+// maxLookupBodyBytes bounds the /lookup request body; an email address is tiny
+const maxLookupBodyBytes = 1 << 10
+
+// lookupRoute accepts only a small JSON POST
+var lookupRoute = routeSpec{methods: []string{http.MethodPost}, maxBodyBytes: maxLookupBodyBytes}
+
+type lookupRequest struct {
+	Email string `json:"email"`
+}
+
+type lookupResponse struct {
+	Found        bool `json:"found"`
+	ProjectCount int  `json:"project_count"`
+}
+
+// countProjectsForEmail returns how many approved projects were submitted with the
+// given email, matched on its hash so the address itself never touches the database
+func countProjectsForEmail(cfg *Config, compressedPath, email string) (int, error) {
+	db, release, err := openQueryDB(compressedPath)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM approved_projects WHERE email_hash = ?`, cfg.hashEmail(email)).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("querying approved_projects: %w", err)
+	}
+	return count, nil
+}
+
+// lookupHandler reports whether an email has any approved projects, and how many,
+// without returning anything else about them. The email is hashed with cfg's salt and
+// never logged. It expects lookupRoute to have checked the method and bounded the body.
+func lookupHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req lookupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: expected a JSON body like {\"email\":\"...\"}")
+			return
+		}
+		if strings.TrimSpace(req.Email) == "" {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: email is required")
+			return
+		}
+
+		path, err := ensureDB(cfg)
+		if err != nil {
+			requestLog(r).Error("Failed to prepare database for lookup: %v", err)
+			writeGenerationFailure(w, err)
+			return
+		}
+
+		// Hashes made with another salt or prefix mode never match, which would look like
+		// a confident "not found"
+		if entry, ok := fullCache.Lookup(path); !ok || entry.emailHashes != cfg.emailHashMode() {
+			requestLog(r).Warn("Cached database's email hashes don't match the current EMAIL_SALT or EMAIL_HASH_PREFIX, not answering the lookup")
+			w.Header().Set("Retry-After", "60")
+			writeJSONError(w, http.StatusServiceUnavailable, "Service Unavailable: the database is being rebuilt, try again later")
+			return
+		}
+
+		count, err := countProjectsForEmail(cfg, path, req.Email)
+		if err != nil {
+			requestLog(r).Error("Failed to look up email: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(lookupResponse{Found: count > 0, ProjectCount: count})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLookupHandler(t *testing.T) {
+	cfg := testConfig
+	hash := cfg.hashEmail("maker@example.com")
+	withCachedDatabase(t, buildTestDatabase(t, fmt.Sprintf(
+		`INSERT INTO approved_projects (record_id, email_hash) VALUES ('rec1', '%s'), ('rec2', '%s'), ('rec3', 'other')`,
+		hash, hash,
+	)))
+
+	tests := []struct {
+		email string
+		want  lookupResponse
+	}{
+		{"maker@example.com", lookupResponse{Found: true, ProjectCount: 2}},
+		{" Maker@Example.COM ", lookupResponse{Found: true, ProjectCount: 2}},
+		{"nobody@example.com", lookupResponse{Found: false, ProjectCount: 0}},
+	}
+	for _, tt := range tests {
+		body := fmt.Sprintf(`{"email":%q}`, tt.email)
+		rec := httptest.NewRecorder()
+		lookupHandler(cfg)(rec, httptest.NewRequest("POST", "/lookup", strings.NewReader(body)))
+
+		if rec.Code != 200 {
+			t.Fatalf("lookup(%q) status = %d, want 200", tt.email, rec.Code)
+		}
+		var got lookupResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("lookup(%q) = %+v, want %+v", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestLookupHandlerRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"wrong method", "GET", "", 405},
+		{"not JSON", "POST", "email=a@b.c", 400},
+		{"missing email", "POST", `{}`, 400},
+		{"oversized body", "POST", `{"email":"` + strings.Repeat("a", 2*maxLookupBodyBytes) + `"}`, 413},
+	}
+	handler := lookupRoute.wrap(lookupHandler(&Config{}))
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/lookup", strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestLookupHandlerRefusesHashesFromAnotherSalt(t *testing.T) {
+	other := &Config{EmailSalt: "another-salt-entirely"}
+	withCachedDatabase(t, buildTestDatabase(t, fmt.Sprintf(
+		`INSERT INTO approved_projects (record_id, email_hash) VALUES ('rec1', '%s')`, other.hashEmail("maker@example.com"))))
+
+	// The database was built with testConfig's salt, so other can't match its hashes
+	rec := httptest.NewRecorder()
+	lookupHandler(other)(rec, httptest.NewRequest("POST", "/lookup", strings.NewReader(`{"email":"maker@example.com"}`)))
+	decodeErrorResponse(t, rec, http.StatusServiceUnavailable)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("503 is missing Retry-After")
+	}
+
+	// Nor can the same salt with the version prefix switched
+	prev := emailHashPrefixed
+	t.Cleanup(func() { emailHashPrefixed = prev })
+	emailHashPrefixed = !prev
+	rec = httptest.NewRecorder()
+	lookupHandler(testConfig)(rec, httptest.NewRequest("POST", "/lookup", strings.NewReader(`{"email":"maker@example.com"}`)))
+	decodeErrorResponse(t, rec, http.StatusServiceUnavailable)
+}
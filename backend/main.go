@@ -1,45 +1,86 @@
 package main
 
 import (
-	"crypto/hmac"
+	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"crypto/subtle"
 	"database/sql"
 	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
+	"github.com/andybalholm/brotli"
 	"github.com/joho/godotenv"
 	"github.com/klauspost/compress/zstd"
 	_ "github.com/lib/pq"
+	"golang.org/x/sync/singleflight"
 	_ "modernc.org/sqlite"
 )
 
 var (
-	apiKey    string
-	emailSalt string
-	pgDB      *sql.DB
-
-	// Cache for the generated SQLite database (zstd compressed)
-	cacheMutex           sync.RWMutex
-	cachedCompressedPath string
-	cacheCreatedAt       time.Time
-	cacheTTL             = 5 * time.Minute
+	pgDB *sql.DB
+
+	// The generated SQLite database is cached in fullCache (see cache.go).
+	// generationMutex serializes generations so readers aren't blocked while a new
+	// database is being built. Concurrent cache misses share one generation through
+	// generationGroup, so only one of them ever waits on it.
+	generationMutex sync.Mutex
+	cacheTTL        = 5 * time.Minute
 )
 
+// Log levels, in increasing order of severity
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// minLogLevel is the least severe level that gets printed. It's set once from
+// LOG_LEVEL at startup, so checking it is a plain comparison.
+var minLogLevel = levelInfo
+
+// parseLogLevel parses LOG_LEVEL: debug, info, warn, or error
+func parseLogLevel(value string) (logLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return levelDebug, nil
+	case "info":
+		return levelInfo, nil
+	case "warn", "warning":
+		return levelWarn, nil
+	case "error":
+		return levelError, nil
+	default:
+		return 0, fmt.Errorf("invalid LOG_LEVEL %q: expected debug, info, warn, or error", value)
+	}
+}
+
 // Custom logger with timestamps
 type Logger struct {
 	prefix string
 }
 
 func (l *Logger) Info(format string, args ...interface{}) {
+	if minLogLevel > levelInfo {
+		return
+	}
 	msg := fmt.Sprintf(format, args...)
 	log.Printf("[INFO]  %s%s", l.prefix, msg)
 }
@@ -50,11 +91,17 @@ func (l *Logger) Error(format string, args ...interface{}) {
 }
 
 func (l *Logger) Warn(format string, args ...interface{}) {
+	if minLogLevel > levelWarn {
+		return
+	}
 	msg := fmt.Sprintf(format, args...)
 	log.Printf("[WARN]  %s%s", l.prefix, msg)
 }
 
 func (l *Logger) Debug(format string, args ...interface{}) {
+	if minLogLevel > levelDebug {
+		return
+	}
 	msg := fmt.Sprintf(format, args...)
 	log.Printf("[DEBUG] %s%s", l.prefix, msg)
 }
@@ -69,30 +116,128 @@ func generateAPIKey() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// maxRequestIDLength bounds client-supplied X-Request-ID values
+const maxRequestIDLength = 64
+
+// validRequestID reports whether a client-supplied X-Request-ID is safe to echo back
+// and write into logs: short, and limited to letters, digits, '-', '_' and '.'
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// randRead fills request IDs with random bytes; swapped out in tests
+var randRead = rand.Read
+
+// requestIDFallbackCounter keeps fallback request IDs unique within one process
+var requestIDFallbackCounter atomic.Uint64
+
+// generateRequestID returns 8 random bytes (crypto/rand) as 16 hex characters. At 64 bits
+// the chance of any collision stays below one in a million until ~6 million IDs have been
+// issued, which is plenty for correlating logs. If the random source fails, it falls back
+// to the current time in nanoseconds plus a process-wide counter, which can't repeat.
 func generateRequestID() string {
 	bytes := make([]byte, 8)
-	rand.Read(bytes)
+	if _, err := randRead(bytes); err != nil {
+		return fmt.Sprintf("t%x-%x", time.Now().UnixNano(), requestIDFallbackCounter.Add(1))
+	}
 	return hex.EncodeToString(bytes)
 }
 
-// hashEmail normalizes an email (lowercase, strip spaces) and returns an HMAC-SHA256 hash
-// using the EMAIL_SALT as the secret key for cryptographic security
-func hashEmail(email string) string {
-	if email == "" {
-		return ""
+// printGeneratedKeyAllowed reports whether a key may be generated and printed to stdout when
+// none is configured (PRINT_GENERATED_KEY). Stdout usually ends up in log aggregators, so it
+// defaults to false with ENV=production, where the server refuses to start without a key.
+func printGeneratedKeyAllowed(env, setting string) bool {
+	if setting == "" {
+		return !strings.EqualFold(env, "production")
+	}
+	return strings.EqualFold(setting, "true")
+}
+
+// checkSaltDiffersFromAPIKey returns an error if the email HMAC salt is the same as the API key.
+// Reusing the API key as the salt means anyone holding the key can recompute email hashes.
+func checkSaltDiffersFromAPIKey(salt, key string) error {
+	if salt != "" && subtle.ConstantTimeCompare([]byte(salt), []byte(key)) == 1 {
+		return fmt.Errorf("EMAIL_SALT must not be the same as API_KEY")
+	}
+	return nil
+}
+
+// Thresholds for an explicitly set EMAIL_SALT. Emails are a small, guessable space, so a
+// short or repetitive salt lets hashes be reversed by brute force.
+const (
+	minEmailSaltLength      = 16
+	minEmailSaltEntropyBits = 48
+)
+
+// checkSaltStrength returns an error if the salt is shorter than minEmailSaltLength or its
+// estimated entropy (Shannon entropy of its characters times its length) is below
+// minEmailSaltEntropyBits. The error never includes the salt itself.
+func checkSaltStrength(salt string) error {
+	length := utf8.RuneCountInString(salt)
+	if length < minEmailSaltLength {
+		return fmt.Errorf("EMAIL_SALT is %d characters, shorter than the minimum of %d", length, minEmailSaltLength)
+	}
+
+	counts := make(map[rune]int)
+	for _, c := range salt {
+		counts[c]++
+	}
+	var bitsPerChar float64
+	for _, n := range counts {
+		p := float64(n) / float64(length)
+		bitsPerChar -= p * math.Log2(p)
 	}
-	// Normalize: lowercase and strip spaces
-	normalized := strings.ToLower(strings.TrimSpace(email))
-	// Create HMAC-SHA256 using emailSalt as the secret key
-	h := hmac.New(sha256.New, []byte(emailSalt))
-	h.Write([]byte(normalized))
-	return hex.EncodeToString(h.Sum(nil))
+	if bits := bitsPerChar * float64(length); bits < minEmailSaltEntropyBits {
+		return fmt.Errorf("EMAIL_SALT has about %.0f bits of entropy, below the minimum of %d", bits, minEmailSaltEntropyBits)
+	}
+	return nil
 }
 
 func main() {
 	// Configure log format with timestamps
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 
+	dryRun := flag.Bool("dry-run", false, "generate the database once, print a JSON report, and exit without serving")
+	dryRunOut := flag.String("out", "", "with -dry-run, also write the database here (a .zst path gets the compressed file)")
+
+	// `export` and `train` are subcommands with flags of their own; anything else is the
+	// server's flags
+	var export *exportOptions
+	var train *trainOptions
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		opts, err := parseExportArgs(os.Args[2:])
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		export = &opts
+	} else if len(os.Args) > 1 && os.Args[1] == "train" {
+		opts, err := parseTrainArgs(os.Args[2:])
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		train = &opts
+	} else {
+		flag.Parse()
+	}
+
 	appLog.Info("Starting Viral Project Explorer backend...")
 
 	// Load .env file if it exists
@@ -104,9 +249,66 @@ func main() {
 		appLog.Info("Loaded .env file")
 	}
 
-	// Get API key from environment variable, or generate one if not set
-	apiKey = os.Getenv("API_KEY")
-	if apiKey == "" {
+	// Suppress messages below LOG_LEVEL (default: info)
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		level, err := parseLogLevel(value)
+		if err != nil {
+			appLog.Error("%v", err)
+			os.Exit(1)
+		}
+		minLogLevel = level
+	}
+
+	// DRY_RUN / DRY_RUN_OUTPUT are the environment forms of -dry-run / -out
+	if strings.EqualFold(os.Getenv("DRY_RUN"), "true") {
+		*dryRun = true
+	}
+	if *dryRunOut == "" {
+		*dryRunOut = os.Getenv("DRY_RUN_OUTPUT")
+	}
+
+	// Dry runs, exports and dictionary training exit without serving requests
+	oneShot := *dryRun || export != nil || train != nil
+
+	// Load additional named API keys, if configured
+	var namedKeys []apiKeyEntry
+	if value := os.Getenv("API_KEYS"); value != "" {
+		keys, err := parseAPIKeys(value)
+		if err != nil {
+			appLog.Error("Invalid API_KEYS: %v", err)
+			os.Exit(1)
+		}
+		namedKeys = append(namedKeys, keys...)
+	}
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		keys, err := loadAPIKeysFile(path)
+		if err != nil {
+			appLog.Error("Invalid API_KEYS_FILE: %v", err)
+			os.Exit(1)
+		}
+		namedKeys = append(namedKeys, keys...)
+	}
+	adminAPIKey := os.Getenv("ADMIN_API_KEY")
+	scopedKeys, err := scopedKeysFrom(os.Getenv("READ_API_KEY"), adminAPIKey)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	namedKeys = append(namedKeys, scopedKeys...)
+	metricsRequiresAdmin = adminAPIKey != ""
+
+	// Get API key from environment variable, or generate one if no keys are configured at all
+	// and printing it is allowed. Dry runs and exports never serve requests, so they don't need one.
+	apiKey, err := secretFromEnv("API_KEY")
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if apiKey == "" && len(namedKeys) == 0 && !oneShot {
+		if !printGeneratedKeyAllowed(os.Getenv("ENV"), os.Getenv("PRINT_GENERATED_KEY")) {
+			appLog.Error("No API key configured: set API_KEY, API_KEY_FILE, API_KEYS or API_KEYS_FILE (or PRINT_GENERATED_KEY=true to generate one and print it to stdout)")
+			os.Exit(1)
+		}
 		var err error
 		apiKey, err = generateAPIKey()
 		if err != nil {
@@ -124,12 +326,29 @@ func main() {
 		fmt.Printf("   curl -H \"X-API-Key: %s\" http://localhost:8080/db\n", apiKey)
 		fmt.Println("=" + strings.Repeat("=", 70) + "=")
 		fmt.Println("")
-	} else {
+	} else if apiKey != "" {
 		appLog.Info("Using API key from environment")
 	}
 
+	var apiKeys []apiKeyEntry
+	if apiKey != "" {
+		apiKeys = append(apiKeys, apiKeyEntry{Name: "default", Key: apiKey, Scope: scopeAdmin})
+	}
+	apiKeys = append(apiKeys, namedKeys...)
+	if len(namedKeys) > 0 {
+		names := make([]string, 0, len(namedKeys))
+		for _, entry := range namedKeys {
+			names = append(names, fmt.Sprintf("%s (%s)", entry.Name, entry.Scope))
+		}
+		appLog.Info("Loaded %d named API keys: %s", len(namedKeys), strings.Join(names, ", "))
+	}
+
 	// Get email salt from environment variable, or generate one if not set
-	emailSalt = os.Getenv("EMAIL_SALT")
+	emailSalt, err := secretFromEnv("EMAIL_SALT")
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
 	if emailSalt == "" {
 		var err error
 		emailSalt, err = generateAPIKey() // Reuse the same random generator
@@ -137,109 +356,646 @@ func main() {
 			appLog.Error("Failed to generate email salt: %v", err)
 			os.Exit(1)
 		}
-		fmt.Println("")
-		fmt.Println("=" + strings.Repeat("=", 70) + "=")
-		fmt.Println("⚠️  EMAIL_SALT not set in environment")
-		fmt.Println("🧂 Generated email salt (save this if you need consistent hashes):")
-		fmt.Println("")
-		fmt.Printf("   %s\n", emailSalt)
-		fmt.Println("=" + strings.Repeat("=", 70) + "=")
-		fmt.Println("")
+		if oneShot {
+			// Keep stdout for the dry-run report, and out of export scripts
+			appLog.Warn("EMAIL_SALT not set: email_hash values use a random salt")
+		} else {
+			fmt.Println("")
+			fmt.Println("=" + strings.Repeat("=", 70) + "=")
+			fmt.Println("⚠️  EMAIL_SALT not set in environment")
+			fmt.Println("🧂 Generated email salt (save this if you need consistent hashes):")
+			fmt.Println("")
+			fmt.Printf("   %s\n", emailSalt)
+			fmt.Println("=" + strings.Repeat("=", 70) + "=")
+			fmt.Println("")
+		}
 	} else {
 		appLog.Info("Using email salt from environment")
+
+		// A generated salt is always strong; only an operator-chosen one can be weak
+		if err := checkSaltStrength(emailSalt); err != nil {
+			const guidance = "generate a strong one with `openssl rand -hex 32`"
+			if strings.EqualFold(os.Getenv("EMAIL_SALT_WEAK"), "fail") {
+				appLog.Error("%v; %s (or unset EMAIL_SALT_WEAK to start anyway)", err, guidance)
+				os.Exit(1)
+			}
+			appLog.Warn("⚠️  %v — email hashes may be reversible by brute force; %s", err, guidance)
+		}
+	}
+
+	// Catch an API key being pasted into EMAIL_SALT by mistake
+	for _, entry := range apiKeys {
+		err := checkSaltDiffersFromAPIKey(emailSalt, entry.Key)
+		if err == nil {
+			continue
+		}
+		if strings.EqualFold(os.Getenv("EMAIL_SALT_REUSE"), "warn") {
+			appLog.Warn("⚠️  %v (key %q) — email hashes can be recomputed by anyone with that key (EMAIL_SALT_REUSE=warn)", err, entry.Name)
+		} else {
+			appLog.Error("%v (key %q; set EMAIL_SALT_REUSE=warn to start anyway)", err, entry.Name)
+			os.Exit(1)
+		}
+	}
+	config := &Config{APIKeys: apiKeys, EmailSalt: emailSalt}
+
+	// Email hashes are tagged with their algorithm version unless consumers need bare hashes
+	if strings.EqualFold(os.Getenv("EMAIL_HASH_PREFIX"), "false") {
+		emailHashPrefixed = false
+		appLog.Info("Emitting unprefixed email hashes (EMAIL_HASH_PREFIX=false)")
+	}
+
+	// Email domains are only shipped when asked for; the full address never is
+	if strings.EqualFold(os.Getenv("INCLUDE_EMAIL_DOMAIN"), "true") {
+		includeEmailDomain = true
+		appLog.Info("Including email domains in approved_projects (INCLUDE_EMAIL_DOMAIN=true)")
+	}
+
+	// Optional separate key for the metrics endpoint
+	metricsKey = os.Getenv("METRICS_KEY")
+	if metricsKey != "" {
+		appLog.Info("Metrics endpoint protected by METRICS_KEY")
+	} else if metricsRequiresAdmin {
+		appLog.Info("Metrics endpoint protected by ADMIN_API_KEY")
+	} else {
+		appLog.Info("Metrics endpoint is unauthenticated (METRICS_KEY not set)")
+	}
+
+	// How long in-flight requests get to finish when shutting down
+	shutdownGracePeriod, err := durationFromEnv("SHUTDOWN_GRACE_PERIOD", 30*time.Second)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+
+	// Optional per-client rate limit
+	rateLimitPerMinute, err := intFromEnv("RATE_LIMIT_PER_MINUTE", 0)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if rateLimitPerMinute > 0 {
+		appLog.Info("Rate limiting enabled: %d requests per minute per API key/IP", rateLimitPerMinute)
+	}
+
+	// Optional cap on database downloads streaming at once
+	maxConcurrentDownloads, err := intFromEnv("MAX_CONCURRENT_DOWNLOADS", 0)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	downloadSlots = newDownloadSlots(maxConcurrentDownloads)
+	if downloadSlots != nil {
+		appLog.Info("Limiting concurrent downloads to %d", maxConcurrentDownloads)
+	}
+
+	// Separate, much stricter limit for email lookups
+	lookupRateLimitPerMinute, err = intFromEnv("LOOKUP_RATE_LIMIT_PER_MINUTE", lookupRateLimitPerMinute)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if lookupRateLimitPerMinute <= 0 {
+		appLog.Error("LOOKUP_RATE_LIMIT_PER_MINUTE must be positive")
+		os.Exit(1)
+	}
+
+	// Upper bound on the Postgres copies of one generation
+	generationTimeout, err = durationFromEnv("GENERATION_TIMEOUT", generationTimeout)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if generationTimeout == 0 {
+		appLog.Error("GENERATION_TIMEOUT must be greater than zero")
+		os.Exit(1)
+	}
+
+	// Generations with fewer rows than this in either table are never cached
+	minRows, err = intFromEnv("MIN_ROWS", minRows)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if minRows < 0 {
+		appLog.Error("MIN_ROWS must not be negative")
+		os.Exit(1)
+	}
+
+	// Reads of the query database retry this many times when SQLite reports it busy
+	sqliteBusyRetries, err = intFromEnv("SQLITE_BUSY_RETRIES", sqliteBusyRetries)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if sqliteBusyRetries < 0 {
+		appLog.Error("SQLITE_BUSY_RETRIES must not be negative")
+		os.Exit(1)
+	}
+
+	// Optional incremental generation from the previous database
+	if strings.EqualFold(os.Getenv("INCREMENTAL"), "true") {
+		incrementalEnabled = true
+		appLog.Info("Incremental generation enabled (new rows by approved_at / mention date)")
+	}
+
+	// Optional check for unchanged data before regenerating an expired database
+	if strings.EqualFold(os.Getenv("CONDITIONAL_GENERATION"), "true") {
+		conditionalGenerationEnabled = true
+		appLog.Info("Conditional generation enabled (expired databases are kept while the data version is unchanged)")
+	}
+
+	// Optional deduplication of mentions by project and normalized URL
+	if strings.EqualFold(os.Getenv("DEDUP_MENTIONS"), "true") {
+		dedupMentionsEnabled = true
+		appLog.Info("Duplicate mentions are collapsed (DEDUP_MENTIONS=true)")
+	}
+
+	// Optional streaming of freshly generated databases on a cache miss
+	if strings.EqualFold(os.Getenv("STREAM_ON_MISS"), "true") {
+		streamOnMiss = true
+		appLog.Info("Cache misses stream the database while it's compressed (STREAM_ON_MISS=true)")
+	}
+
+	// HEAD /db answers 503 on a cache miss unless it's allowed to generate
+	if strings.EqualFold(os.Getenv("HEAD_GENERATES"), "true") {
+		headGenerates = true
+		appLog.Info("HEAD /db generates the database on a cache miss (HEAD_GENERATES=true)")
+	}
+
+	// Optional background prewarming so requests rarely hit an expired cache
+	prewarm := strings.EqualFold(os.Getenv("PREWARM"), "true")
+	if prewarm {
+		prewarmLead, err = durationFromEnv("PREWARM_LEAD", prewarmLead)
+		if err != nil {
+			appLog.Error("%v", err)
+			os.Exit(1)
+		}
+		if prewarmLead >= cacheTTL {
+			appLog.Error("PREWARM_LEAD (%s) must be shorter than the cache TTL (%s)", prewarmLead, cacheTTL)
+			os.Exit(1)
+		}
+	}
+
+	// Optional one-off generation at startup; PREWARM's first pass does the same
+	warmOnStart := strings.EqualFold(os.Getenv("WARM_ON_START"), "true")
+
+	// Optional latency budget: serve stale data rather than block on regeneration.
+	// STALE_WHILE_REVALIDATE is the zero-budget case: always serve stale immediately.
+	staleWhileRevalidate := strings.EqualFold(os.Getenv("STALE_WHILE_REVALIDATE"), "true")
+	if staleWhileRevalidate || os.Getenv("REQUEST_LATENCY_BUDGET") != "" {
+		latencyBudget, err = durationFromEnv("REQUEST_LATENCY_BUDGET", 0)
+		if err != nil {
+			appLog.Error("%v", err)
+			os.Exit(1)
+		}
+		maxStale, err = durationFromEnv("MAX_STALE", maxStale)
+		if err != nil {
+			appLog.Error("%v", err)
+			os.Exit(1)
+		}
+		latencyBudgetEnabled = true
+		appLog.Info("Latency budget enabled: waiting at most %s for a refresh, serving data up to %s old", latencyBudget, maxStale)
+	}
+
+	// Schema drift fails generation unless explicitly downgraded to a warning
+	if strings.EqualFold(os.Getenv("SCHEMA_HASH_MISMATCH"), "warn") {
+		schemaMismatchFatal = false
+		appLog.Warn("Schema hash mismatches will only be logged (SCHEMA_HASH_MISMATCH=warn)")
+	}
+
+	// zstd level used for the cached database (trades generation time for download size)
+	if level := os.Getenv("ZSTD_LEVEL"); level != "" {
+		parsed, err := parseZstdLevel(level)
+		if err != nil {
+			appLog.Error("Invalid ZSTD_LEVEL: %v", err)
+			os.Exit(1)
+		}
+		zstdLevel = parsed
+	}
+	appLog.Info("zstd compression level: %s", zstdLevel)
+
+	// Brotli quality of the .br copies built for Accept-Encoding: br
+	brotliQuality, err = intFromEnv("BROTLI_QUALITY", brotliQuality)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if brotliQuality > brotli.BestCompression {
+		appLog.Error("BROTLI_QUALITY must be between 0 and %d", brotli.BestCompression)
+		os.Exit(1)
+	}
+
+	// A trained dictionary, for clients that opt in with ?format=zstd-dict
+	if path := os.Getenv("ZSTD_DICT_FILE"); path != "" {
+		data, id, err := loadZstdDict(path)
+		if err != nil {
+			appLog.Error("Invalid ZSTD_DICT_FILE: %v", err)
+			os.Exit(1)
+		}
+		zstdDict, zstdDictID = data, id
+		appLog.Info("Offering ?format=zstd-dict with zstd dictionary %d from %s", id, path)
+	}
+
+	// Training only reads sample files, so it exits before Postgres is configured
+	if train != nil {
+		results, err := trainZstdDict(*train)
+		if err != nil {
+			appLog.Error("Training failed: %v", err)
+			os.Exit(1)
+		}
+		for _, r := range results {
+			appLog.Info("%s: %d bytes, %d compressed without the dictionary (%.2fx), %d with it (%.2fx)",
+				r.path, r.size, r.withoutDict, float64(r.size)/float64(r.withoutDict), r.withDict, float64(r.size)/float64(r.withDict))
+		}
+		appLog.Info("Wrote zstd dictionary to %s", train.out)
+		return
+	}
+
+	// robots.txt turns every crawler away unless replaced
+	if path := os.Getenv("ROBOTS_TXT_FILE"); path != "" {
+		contents, err := loadRobotsTxt(path)
+		if err != nil {
+			appLog.Error("Invalid ROBOTS_TXT_FILE: %v", err)
+			os.Exit(1)
+		}
+		robotsTxt = contents
+	}
+
+	// security.txt is only served with a contact to put in it
+	if contact := strings.TrimSpace(os.Getenv("SECURITY_CONTACT")); contact != "" {
+		if err := checkSecurityContact(contact); err != nil {
+			appLog.Error("Invalid SECURITY_CONTACT: %v", err)
+			os.Exit(1)
+		}
+		securityContact = contact
+	}
+
+	// URL credentials are stripped during normalization unless they should reject the URL
+	if strings.EqualFold(os.Getenv("URL_CREDENTIALS"), "reject") {
+		rejectURLCredentials = true
+		appLog.Info("Rejecting URLs with embedded credentials (URL_CREDENTIALS=reject)")
+	}
+
+	// URL fragments are stripped during normalization unless explicitly kept
+	if strings.EqualFold(os.Getenv("URL_FRAGMENTS"), "keep") {
+		stripURLFragments = false
+		appLog.Info("Keeping #fragments in normalized URLs (URL_FRAGMENTS=keep)")
+	}
+
+	// Optional User-Agent heuristic for picking the default download format
+	if allowlist := os.Getenv("USER_AGENT_ZSTD_ALLOWLIST"); allowlist != "" {
+		patterns, err := parseUserAgentAllowlist(allowlist)
+		if err != nil {
+			appLog.Error("Invalid USER_AGENT_ZSTD_ALLOWLIST: %v", err)
+			os.Exit(1)
+		}
+		userAgentZstdAllowlist = patterns
+		appLog.Info("zstd User-Agent allowlist enabled (%d patterns)", len(patterns))
+	}
+
+	// Restrict cross-origin access to known frontends
+	if value := os.Getenv("CORS_ALLOWED_ORIGINS"); value != "" {
+		origins, err := parseAllowedOrigins(value)
+		if err != nil {
+			appLog.Error("Invalid CORS_ALLOWED_ORIGINS: %v", err)
+			os.Exit(1)
+		}
+		corsAllowedOrigins = origins
+		appLog.Info("CORS restricted to %d allowed origins", len(origins))
+	} else {
+		appLog.Warn("CORS_ALLOWED_ORIGINS not set: allowing requests from any origin")
+	}
+
+	// Only believe X-Forwarded-For from known proxies, so clients can't pick their own address
+	if value := os.Getenv("TRUSTED_PROXIES"); value != "" {
+		proxies, err := parseCIDRList(value)
+		if err != nil {
+			appLog.Error("Invalid TRUSTED_PROXIES: %v", err)
+			os.Exit(1)
+		}
+		trustedProxies = proxies
+		appLog.Info("Trusting X-Forwarded-For from %d proxy networks", len(proxies))
+	}
+
+	// Optionally restrict the API to known networks, on top of API keys
+	if value := os.Getenv("IP_ALLOWLIST"); value != "" {
+		networks, err := parseCIDRList(value)
+		if err != nil {
+			appLog.Error("Invalid IP_ALLOWLIST: %v", err)
+			os.Exit(1)
+		}
+		ipAllowlist = networks
+		appLog.Info("API restricted to %d allowed networks", len(networks))
+	}
+
+	// A dry run builds in a temporary directory and leaves CACHE_DIR and its cache alone
+	if !*dryRun {
+		// Database files and their metadata live in CACHE_DIR so they survive restarts
+		if dir := os.Getenv("CACHE_DIR"); dir != "" {
+			cacheDir = dir
+		}
+		if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+			appLog.Error("Failed to create CACHE_DIR: %v", err)
+			os.Exit(1)
+		}
+		appLog.Info("Cache directory: %s", cacheDir)
+
+		// Uncompressed databases are built in WORK_DIR (or TEMP_DIR), by default CACHE_DIR
+		sharedWorkDir := os.Getenv("WORK_DIR")
+		if sharedWorkDir == "" {
+			sharedWorkDir = os.Getenv("TEMP_DIR")
+		}
+		if sharedWorkDir == "" {
+			if err := checkWritableDir(cacheDir); err != nil {
+				appLog.Error("Can't build databases in %s: %v", cacheDir, err)
+				os.Exit(1)
+			}
+		} else {
+			// WORK_DIR may be shared, so builds go in a directory of our own that's safe to delete
+			if workDir, err = createWorkDir(sharedWorkDir); err != nil {
+				appLog.Error("Can't build databases in %s: %v", sharedWorkDir, err)
+				os.Exit(1)
+			}
+			appLog.Info("Work directory: %s", workDir)
+		}
+
+		// Pick up the database cached by the previous run, if it's still valid
+		var keepPath string
+		if entry, err := loadCacheMetadata(config, cacheDir, cacheTTL); err == nil {
+			fullCache.Set(entry)
+			keepPath = entry.path
+			appLog.Info("Restored cached database from the previous run (age: %s)", entry.age().Round(time.Second))
+		} else if !os.IsNotExist(err) {
+			appLog.Info("Not restoring the previous cache (%v), it will be regenerated", err)
+			removeCacheMetadata(cacheDir)
+		}
+
+		// Remove database files left behind by previous runs (e.g. after a crash)
+		if removed, size, err := cleanupStaleCacheFiles(cacheDir, keepPath); err != nil {
+			appLog.Warn("Failed to clean up stale cache files: %v", err)
+		} else if removed > 0 {
+			appLog.Info("Removed %d stale cache files (%.2f MB)", removed, float64(size)/(1024*1024))
+		}
 	}
 
 	// Connect to PostgreSQL
-	dbURL := os.Getenv("WAREHOUSE_READONLY_UNIFIED_YSWS_DATABASE_URL")
+	dbURL, err := secretFromEnv("WAREHOUSE_READONLY_UNIFIED_YSWS_DATABASE_URL")
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
 	if dbURL == "" {
-		appLog.Error("WAREHOUSE_READONLY_UNIFIED_YSWS_DATABASE_URL environment variable is required")
+		appLog.Error("WAREHOUSE_READONLY_UNIFIED_YSWS_DATABASE_URL (or WAREHOUSE_READONLY_UNIFIED_YSWS_DATABASE_URL_FILE) is required")
 		os.Exit(1)
 	}
 
+	// Schema holding the synced Airtable tables (e.g. a staging copy)
+	if schema := os.Getenv("PG_SCHEMA"); schema != "" {
+		if err := validatePGSchema(schema); err != nil {
+			appLog.Error("Invalid PG_SCHEMA: %v", err)
+			os.Exit(1)
+		}
+		pgSchema = schema
+	}
+	appLog.Info("Using PostgreSQL schema %s", pgSchema)
+
 	appLog.Info("Connecting to PostgreSQL...")
-	var err error
 	pgDB, err = sql.Open("postgres", dbURL)
 	if err != nil {
 		appLog.Error("Failed to open PostgreSQL connection: %v", err)
 		os.Exit(1)
 	}
-	defer pgDB.Close()
 
 	// Configure connection pool
 	pgDB.SetMaxOpenConns(10)
 	pgDB.SetMaxIdleConns(5)
 	pgDB.SetConnMaxLifetime(5 * time.Minute)
 
-	if err := pgDB.Ping(); err != nil {
+	// Rows per SQLite transaction when copying
+	batchSize, err = intFromEnv("BATCH_SIZE", batchSize)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if batchSize <= 0 {
+		appLog.Error("BATCH_SIZE must be positive")
+		os.Exit(1)
+	}
+
+	// Postgres may still be starting (e.g. in the same compose stack), so retry with backoff
+	connectAttempts, err := intFromEnv("PG_CONNECT_ATTEMPTS", 10)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	connectDelay, err := durationFromEnv("PG_CONNECT_DELAY", time.Second)
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if err := pingWithRetry(pgDB.Ping, connectAttempts, connectDelay, time.Sleep); err != nil {
 		appLog.Error("Failed to ping PostgreSQL database: %v", err)
 		os.Exit(1)
 	}
 	appLog.Info("✓ Connected to PostgreSQL database")
 
-	// Create a mux to handle all routes with authentication
-	mux := http.NewServeMux()
-	mux.HandleFunc("/db", dbHandler)
+	if *dryRun {
+		if err := runDryRun(config, *dryRunOut, os.Stdout); err != nil {
+			appLog.Error("Dry run failed: %v", err)
+			os.Exit(1)
+		}
+		appLog.Info("Dry run complete, not starting the server")
+		return
+	}
 
-	// Chain middleware: logging -> cors -> auth -> handler
-	handler := loggingMiddleware(corsMiddleware(authMiddleware(mux)))
+	if export != nil {
+		built, err := exportDatabase(config, export.out, export.compress)
+		if err != nil {
+			appLog.Error("Export failed: %v", err)
+			os.Exit(1)
+		}
+		appLog.Info("Exported %d approved_projects and %d ysws_project_mentions to %s", built.projectCount, built.mentionCount, export.out)
+		return
+	}
 
-	port := ":8080"
-	appLog.Info("Server starting on port %s", port)
+	// Create a mux to handle all routes with authentication. Each route declares the
+	// methods and body size it accepts.
+	mux := http.NewServeMux()
+	mux.Handle("/db", readRoute.wrap(dbHandler(config)))
+	mux.Handle("/db.sqlite", readRoute.wrap(dbSQLiteHandler(config)))
+	mux.Handle("/db.sha256", readRoute.wrap(dbSHA256Handler(config)))
+	mux.Handle("/db.dict", readRoute.wrap(http.HandlerFunc(dbDictHandler)))
+	mux.Handle("/export.json", readRoute.wrap(exportJSONHandler(config)))
+	mux.Handle("/export/approved_projects.csv", readRoute.wrap(exportProjectsCSVHandler(config)))
+	mux.Handle("/normalize", readRoute.wrap(http.HandlerFunc(normalizeHandler)))
+	mux.Handle("/schema", readRoute.wrap(http.HandlerFunc(schemaHandler)))
+	mux.Handle("/stats", requireScope(scopeAdmin, readRoute.wrap(statsHandler(config))))
+	mux.Handle("/projects", readRoute.wrap(projectsHandler(config)))
+	mux.Handle("/leaderboard", readRoute.wrap(leaderboardHandler(config)))
+	mux.Handle("/count", readRoute.wrap(countHandler(config)))
+	mux.Handle("/cache/invalidate", requireScope(scopeAdmin, cacheInvalidateRoute.wrap(http.HandlerFunc(cacheInvalidateHandler))))
+	mux.Handle("/cache/refresh", requireScope(scopeAdmin, cacheInvalidateRoute.wrap(cacheRefreshHandler(config))))
+
+	lookupLimiter := newRateLimiter(lookupRateLimitPerMinute)
+	go lookupLimiter.cleanupLoop(time.Minute)
+	mux.Handle("/lookup", requireScope(scopeAdmin, lookupLimiter.middleware(config, lookupRoute.wrap(lookupHandler(config)))))
+
+	// Anything else is a JSON 404, but only once authenticated, so route existence doesn't leak
+	mux.Handle("/", http.HandlerFunc(notFoundHandler))
+
+	// Chain middleware: logging -> cors -> public routes, or rate limit -> IP allowlist ->
+	// auth -> handler. Probes and scrapes aren't rate limited, so a busy client behind the
+	// same address can't get them answered with 429.
+	protected := ipAllowlistMiddleware(authMiddleware(config, mux))
+	if rateLimitPerMinute > 0 {
+		limiter := newRateLimiter(rateLimitPerMinute)
+		go limiter.cleanupLoop(time.Minute)
+		protected = limiter.middleware(config, protected)
+	}
+	handler := loggingMiddleware(corsMiddleware(publicRoutes(config, protected)))
+
+	host := os.Getenv("HOST")
+	if host == "" {
+		host = os.Getenv("BIND_ADDR")
+	}
+	addr, err := listenAddress(host, os.Getenv("PORT"))
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	tlsConfig, err := tlsConfigFor(os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"))
+	if err != nil {
+		appLog.Error("%v", err)
+		os.Exit(1)
+	}
+	if tlsConfig != nil {
+		appLog.Info("Server starting on %s (HTTPS)", addr)
+	} else {
+		appLog.Info("Server starting on %s", addr)
+	}
 	appLog.Info("API key authentication is enabled")
 	appLog.Info("Endpoint: GET /db - Download SQLite database")
+	appLog.Info("Endpoint: HEAD /db - Size and freshness of the cached database")
+	appLog.Info("Endpoint: GET /db.sqlite - Download uncompressed SQLite database")
+	appLog.Info("Endpoint: GET /db.sha256 - SHA-256 of the zstd-compressed database")
+	appLog.Info("Endpoint: GET /db.dict - zstd dictionary for ?format=zstd-dict downloads")
+	appLog.Info("Endpoint: GET /export.json - Stream projects with mentions as JSON lines")
+	appLog.Info("Endpoint: GET /export/approved_projects.csv - Stream approved projects as CSV")
+	appLog.Info("Endpoint: GET /normalize?url=... - Explain URL normalization")
+	appLog.Info("Endpoint: GET /schema - Tables, columns and indexes of the SQLite database")
+	appLog.Info("Endpoint: GET /stats - Dataset summary")
+	appLog.Info("Endpoint: GET /count - Row counts of the cached database")
+	appLog.Info("Endpoint: GET /projects?limit=&offset=&order=&country=&ysws= - Page through approved projects as JSON")
+	appLog.Info("Endpoint: GET /leaderboard?limit= - Most-viral projects by weighted engagement")
+	appLog.Info("Endpoint: POST /lookup - Check whether an email has approved projects (%d/min per key)", lookupRateLimitPerMinute)
+	appLog.Info("Endpoint: POST /cache/invalidate - Drop the cached database so the next request regenerates")
+	appLog.Info("Endpoint: POST /cache/refresh - Regenerate the database now and report on it")
+	appLog.Info("Endpoint: GET /metrics - Prometheus metrics")
+	appLog.Info("Endpoint: GET /healthz - Liveness and schema info")
+	appLog.Info("Endpoint: GET /ready - Readiness (a database has been generated)")
+	appLog.Info("Endpoint: GET /version - Build info")
+	appLog.Info("Endpoint: GET /robots.txt, /.well-known/security.txt - For crawlers and security researchers")
+	appLog.Info("Endpoint: GET /favicon.ico - 204 No Content, so browsers don't log 401s")
+
+	if prewarm {
+		appLog.Info("Prewarming enabled: refreshing the database %s before the cache expires", prewarmLead)
+		go prewarmLoop(config, prewarmInterval, nil)
+	} else if warmOnStart {
+		appLog.Info("Warming the cache in the background (WARM_ON_START=true)")
+		go warmCache(config)
+	}
 
-	if err := http.ListenAndServe(port, handler); err != nil {
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			// The certificate is already in TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	// Wait for a shutdown signal so deploys don't cut off in-flight downloads
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
 		appLog.Error("Server failed: %v", err)
 		os.Exit(1)
+	case sig := <-stop:
+		appLog.Info("Received %s, shutting down (grace period %s)...", sig, shutdownGracePeriod)
 	}
-}
 
-// corsMiddleware adds CORS headers to allow cross-origin requests
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Allow requests from any origin (for development)
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
-
-		// Handle preflight OPTIONS request
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		appLog.Warn("Shutdown grace period expired, closing remaining connections: %v", err)
+		server.Close()
+	} else {
+		appLog.Info("All in-flight requests completed")
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	pgDB.Close()
+	closeQueryDB()
+	removeSubsetDBs()
+	removePendingFiles()
+	removeWorkDir()
+	appLog.Info("Shutdown complete")
+}
+
+// removeCachedDB clears the cache and deletes the cached database file once requests
+// that already looked it up have had time to open it
+func removeCachedDB() {
+	removeCacheMetadata(cacheDir)
+	if previous := fullCache.Clear(); previous.path != "" {
+		removeAfterGrace(previous.path)
+		removeDerivedFiles(previous.path)
+	}
 }
 
 // loggingMiddleware logs all incoming requests with timing
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		requestID := generateRequestID()
+
+		// Honor the caller's request ID so their logs and ours line up
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = generateRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		r, info := withRequestInfo(r)
+		info.id = requestID
+		info.clientIP = resolveClientIP(r)
 
 		// Create a response wrapper to capture status code
 		wrapped := &responseWrapper{ResponseWriter: w, statusCode: http.StatusOK}
 
-		// Get client IP
-		clientIP := r.RemoteAddr
-		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-			clientIP = strings.Split(forwarded, ",")[0]
-		}
-
 		// Log request start
-		reqLog := &Logger{prefix: fmt.Sprintf("[%s] ", requestID)}
-		reqLog.Info("→ %s %s from %s", r.Method, r.URL.Path, clientIP)
+		reqLog := requestLog(r)
+		reqLog.Info("→ %s %s from %s", r.Method, r.URL.Path, clientAddr(r))
 
 		// Process request
 		next.ServeHTTP(wrapped, r)
 
-		// Log request completion
+		// Log request completion, including which API key identity made the request
 		duration := time.Since(start)
+		metrics.observeRequest(wrapped.statusCode)
+		identity := ""
+		if info.identity != "" {
+			identity = " as " + info.identity
+		}
 		if wrapped.statusCode >= 400 {
-			reqLog.Warn("← %d %s (%s)", wrapped.statusCode, http.StatusText(wrapped.statusCode), duration)
+			reqLog.Warn("← %d %s (%s)%s", wrapped.statusCode, http.StatusText(wrapped.statusCode), duration, identity)
 		} else {
-			reqLog.Info("← %d %s (%s)", wrapped.statusCode, http.StatusText(wrapped.statusCode), duration)
+			reqLog.Info("← %d %s (%s)%s", wrapped.statusCode, http.StatusText(wrapped.statusCode), duration, identity)
 		}
 	})
 }
@@ -255,203 +1011,339 @@ func (rw *responseWrapper) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-func authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		apiKeyHeader := r.Header.Get("X-API-Key")
-
-		var providedKey string
-		var authMethod string
-
-		if authHeader != "" {
-			parts := strings.Split(authHeader, " ")
-			if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
-				providedKey = parts[1]
-				authMethod = "Bearer"
-			} else {
-				providedKey = authHeader
-				authMethod = "Authorization"
-			}
-		} else if apiKeyHeader != "" {
-			providedKey = apiKeyHeader
-			authMethod = "X-API-Key"
+// ReadFrom passes io.Copy through to the wrapped writer's ReadFrom, which net/http
+// implements with sendfile when copying from a file. Embedding the interface alone would
+// hide it.
+func (rw *responseWrapper) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(rw.ResponseWriter, r)
+}
+
+// dbHandler serves GET and HEAD /db, generating the database with cfg when the cache
+// can't answer
+func dbHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestStart := time.Now()
+
+		// Work out the format before doing any expensive work
+		format, err := negotiateFormat(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
 		}
+		w.Header().Add("Vary", "Accept-Encoding, User-Agent")
 
-		if providedKey == "" {
-			appLog.Warn("Auth failed: no API key provided")
-			w.Header().Set("WWW-Authenticate", `Bearer realm="API"`)
-			http.Error(w, "Unauthorized: API key is required", http.StatusUnauthorized)
+		disposition, err := contentDisposition(r, format, downloadFilename(format))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
 			return
 		}
 
-		if subtle.ConstantTimeCompare([]byte(providedKey), []byte(apiKey)) != 1 {
-			appLog.Warn("Auth failed: invalid API key (method: %s)", authMethod)
-			w.Header().Set("WWW-Authenticate", `Bearer realm="API"`)
-			http.Error(w, "Unauthorized: API key is required", http.StatusUnauthorized)
+		subset, err := parseSubset(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
+		}
+		if r.Method == http.MethodHead {
+			handleDBHead(cfg, w, r, subset, format, disposition)
+			return
+		}
+		if !subset.isFull() {
+			handleSubsetDownload(cfg, w, r, subset, format, disposition, requestStart)
 			return
 		}
 
-		next.ServeHTTP(w, r)
-	})
+		handleDBDownload(cfg, w, r, format, disposition, requestStart)
+	}
 }
 
-func dbHandler(w http.ResponseWriter, r *http.Request) {
-	requestStart := time.Now()
+// dbSQLiteHandler always serves the uncompressed SQLite file, for clients
+// (e.g. sql.js in the browser) that can't easily decompress zstd, generating it with cfg
+// like dbHandler
+func dbSQLiteHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		disposition, err := contentDisposition(r, formatSQLite, downloadFilename(formatSQLite))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
+		}
+
+		subset, err := parseSubset(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
+		}
+		if r.Method == http.MethodHead {
+			handleDBHead(cfg, w, r, subset, formatSQLite, disposition)
+			return
+		}
+		if !subset.isFull() {
+			handleSubsetDownload(cfg, w, r, subset, formatSQLite, disposition, time.Now())
+			return
+		}
+
+		handleDBDownload(cfg, w, r, formatSQLite, disposition, time.Now())
+	}
+}
 
+// handleDBDownload serves the cached database in the given format, generating it first if needed
+func handleDBDownload(cfg *Config, w http.ResponseWriter, r *http.Request, format, disposition string, requestStart time.Time) {
 	// Check if we have a valid cached database
-	dbPath, fromCache := getCachedDB()
+	entry, fromCache := getCachedEntry()
+	metrics.observeCache(fromCache)
 	if fromCache {
-		appLog.Info("Serving cached database (age: %s)", time.Since(cacheCreatedAt).Round(time.Second))
-		serveCachedDB(w, dbPath, requestStart)
+		requestLog(r).Info("Serving cached database (age: %s, format: %s)", entry.age().Round(time.Second), format)
+		w.Header().Set("X-Cache", "HIT")
+		serveDB(w, r, entry.path, format, disposition, requestStart)
+		return
+	}
+
+	// Within the latency budget, prefer a slightly stale database over blocking on generation
+	if latencyBudgetEnabled {
+		if servedStale := serveWithinLatencyBudget(cfg, w, r, format, disposition, requestStart); servedStale {
+			return
+		}
+	}
+
+	// Optionally stream the compressed database to this client while it's being cached
+	if streamOnMiss && format == formatZstd {
+		serveGeneratingDB(cfg, w, r, disposition, requestStart)
 		return
 	}
 
 	// Generate a new database
-	newPath, err := generateDB()
+	newPath, err := regenerate(cfg)
 	if err != nil {
-		appLog.Error("Failed to generate database: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		metrics.observeGenerationFailure()
+		if path, ok := keptDB(err); ok {
+			requestLog(r).Warn("Generated database was discarded, serving the previous one: %v", err)
+			markKeptDB(w)
+			serveDB(w, r, path, format, disposition, requestStart)
+			return
+		}
+		requestLog(r).Error("Failed to generate database: %v", err)
+		writeGenerationFailure(w, err)
+		return
+	}
+
+	requestLog(r).Info("Generated fresh database, caching for %s", cacheTTL)
+	w.Header().Set("X-Cache", "MISS")
+	serveDB(w, r, newPath, format, disposition, requestStart)
+}
+
+// downloadFilename returns the filename offered for a database download in the given format
+func downloadFilename(format string) string {
+	// gzip and br are Content-Encodings, so clients save the decoded SQLite file
+	if format == formatSQLite || format == formatGzip || format == formatBrotli {
+		return "database.db"
+	}
+	return "database.db.zst"
+}
+
+// serveDB sends the cached database in the negotiated format, if a download slot is free
+func serveDB(w http.ResponseWriter, r *http.Request, compressedPath, format, disposition string, requestStart time.Time) {
+	// A client that already has this database needs neither a body nor a slot
+	if dbNotModified(w, r, compressedPath, format) {
+		requestLog(r).Info("Database not modified since the client's copy (format: %s)", format)
 		return
 	}
 
-	appLog.Info("Generated fresh database, caching for %s", cacheTTL)
-	serveCachedDB(w, newPath, requestStart)
+	// A Brotli or dictionary copy may need building first, which mustn't hold a slot
+	switch format {
+	case formatBrotli:
+		serveDerivedDB(w, r, brotliFiles, compressedPath, format, disposition, requestStart)
+		return
+	case formatZstdDict:
+		serveDerivedDB(w, r, dictZstdFiles, compressedPath, format, disposition, requestStart)
+		return
+	}
+
+	release, ok := acquireDownloadSlot()
+	if !ok {
+		writeDownloadsBusy(w, r)
+		return
+	}
+	defer release()
+
+	switch format {
+	case formatSQLite:
+		serveDecompressedDB(w, r, compressedPath, disposition, requestStart)
+	case formatGzip:
+		serveGzipDB(w, r, compressedPath, disposition, requestStart)
+	default:
+		serveCachedDB(w, r, compressedPath, disposition, requestStart)
+	}
 }
 
 // getCachedDB checks if we have a valid cached compressed database and returns its path
 // Returns (path, true) if cache is valid, ("", false) if cache needs refresh
 func getCachedDB() (string, bool) {
-	cacheMutex.RLock()
-	defer cacheMutex.RUnlock()
+	entry, ok := getCachedEntry()
+	return entry.path, ok
+}
+
+// getCachedEntry returns the cache entry if it's within cacheTTL and its file still exists
+func getCachedEntry() (cacheEntry, bool) {
+	entry, ok := fullCache.Get()
 
 	// Check if cache exists and is still valid
-	if cachedCompressedPath == "" || time.Since(cacheCreatedAt) > cacheTTL {
-		return "", false
+	if !ok || entry.age() > cacheTTL {
+		return cacheEntry{}, false
 	}
 
 	// Verify the cached file still exists
-	if _, err := os.Stat(cachedCompressedPath); os.IsNotExist(err) {
-		return "", false
+	if _, err := os.Stat(entry.path); os.IsNotExist(err) {
+		return cacheEntry{}, false
 	}
 
-	return cachedCompressedPath, true
+	return entry, true
 }
 
-// generateDB creates a new SQLite database from PostgreSQL data, compresses it with zstd, and caches it
-func generateDB() (string, error) {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
+// generationGroup lets concurrent cache misses share one in-flight generation
+var generationGroup singleflight.Group
 
-	// Double-check: another goroutine may have regenerated while we waited for the lock
-	if cachedCompressedPath != "" && time.Since(cacheCreatedAt) <= cacheTTL {
-		if _, err := os.Stat(cachedCompressedPath); err == nil {
-			return cachedCompressedPath, nil
-		}
-	}
+// generateDB creates a new SQLite database from PostgreSQL data, compresses it with zstd, and caches it.
+// Callers arriving while a generation is in flight wait for it and share its result, rather
+// than queueing on generationMutex one behind the other.
+func generateDB(cfg *Config) (string, error) {
+	return sharedGeneration(func() (string, error) {
+		return generateDBIfOlderThan(cfg, cacheTTL)
+	})
+}
 
-	// Remove old cached file if it exists
-	if cachedCompressedPath != "" {
-		os.Remove(cachedCompressedPath)
+// sharedGeneration runs generate, or joins a call to it that's already running
+func sharedGeneration(generate func() (string, error)) (string, error) {
+	path, err, shared := generationGroup.Do("full", func() (interface{}, error) {
+		return generate()
+	})
+	if shared {
+		appLog.Debug("Joined an in-flight database generation")
 	}
+	return path.(string), err
+}
 
-	// Create a new file for the SQLite database (not in temp, so it persists)
-	appLog.Debug("Creating SQLite database file...")
-	tmpFile, err := os.CreateTemp("", "cached-db-*.db")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
-	}
-	tmpPath := tmpFile.Name()
-	tmpFile.Close()
+// generateDBIfOlderThan regenerates the database unless the cached one is at most maxAge old.
+// The prewarmer passes less than cacheTTL to refresh ahead of expiry.
+func generateDBIfOlderThan(cfg *Config, maxAge time.Duration) (string, error) {
+	return generateDBStreaming(cfg, maxAge, nil)
+}
 
-	// Open SQLite database
-	sqliteDB, err := sql.Open("sqlite", tmpPath)
-	if err != nil {
-		os.Remove(tmpPath)
-		return "", fmt.Errorf("failed to open SQLite database: %w", err)
-	}
+// generateDBStreaming is generateDBIfOlderThan with an optional second destination for the
+// compressed output. streamTo is called once the SQLite database has been built (so failures
+// before that can still be reported normally), and everything compressed into the cache file
+// is also written to the writer it returns. It isn't called if a fresh enough database exists.
+func generateDBStreaming(cfg *Config, maxAge time.Duration, streamTo func() io.Writer) (string, error) {
+	generationMutex.Lock()
+	defer generationMutex.Unlock()
 
-	// Create tables in SQLite
-	appLog.Debug("Creating SQLite tables...")
-	tableStart := time.Now()
-	if err := createSQLiteTables(sqliteDB); err != nil {
-		sqliteDB.Close()
-		os.Remove(tmpPath)
-		return "", fmt.Errorf("failed to create tables: %w", err)
+	// Double-check: another goroutine may have regenerated while we waited for the lock
+	if path, _, ok := getStaleDB(maxAge); ok {
+		return path, nil
 	}
-	appLog.Debug("Tables created in %s", time.Since(tableStart))
 
-	// Copy data from PostgreSQL to SQLite
-	appLog.Info("Copying approved_projects from PostgreSQL...")
-	copyStart := time.Now()
-	projectCount, err := copyApprovedProjects(sqliteDB)
-	if err != nil {
-		sqliteDB.Close()
-		os.Remove(tmpPath)
-		return "", fmt.Errorf("failed to copy approved_projects: %w", err)
+	// Keep the previous database if Postgres hasn't changed since it was built. The version
+	// is read before building, so a change made during the build is caught next time. A
+	// forced rebuild (maxAge 0) skips the check, since edits to existing rows don't show.
+	var dataVersion string
+	if conditionalGenerationEnabled {
+		previous, _ := fullCache.Get()
+		version, err := queryDataVersion(pgDB)
+		if err != nil {
+			appLog.Warn("Couldn't read the data version, regenerating: %v", err)
+		} else if maxAge == 0 {
+			appLog.Debug("Forced rebuild, not comparing data versions")
+		} else if path, ok := extendIfUnchanged(previous, version); ok {
+			return path, nil
+		}
+		dataVersion = version
 	}
-	appLog.Info("Copied %d approved_projects in %s", projectCount, time.Since(copyStart))
 
-	appLog.Info("Copying ysws_project_mentions from PostgreSQL...")
-	copyStart = time.Now()
-	mentionCount, err := copyProjectMentions(sqliteDB)
-	if err != nil {
-		sqliteDB.Close()
-		os.Remove(tmpPath)
-		return "", fmt.Errorf("failed to copy ysws_project_mentions: %w", err)
+	// Don't start what the disk probably can't hold; the previous database stays cached
+	if err := checkDiskSpace(buildDir(), cacheDir); err != nil {
+		return "", err
 	}
-	appLog.Info("Copied %d ysws_project_mentions in %s", mentionCount, time.Since(copyStart))
 
-	// Close SQLite to flush all data
-	sqliteDB.Close()
+	generationStart := time.Now()
 
-	// Get uncompressed file size
-	fileInfo, err := os.Stat(tmpPath)
-	var uncompressedSize int64
-	if err == nil {
-		uncompressedSize = fileInfo.Size()
-		appLog.Info("SQLite database size (uncompressed): %.2f MB, total rows: %d", float64(uncompressedSize)/(1024*1024), projectCount+mentionCount)
+	// In incremental mode, start from the previous database and only pull newer rows
+	var incrementalFrom cacheEntry
+	if incrementalEnabled {
+		incrementalFrom, _ = fullCache.Get()
 	}
 
-	// Compress the database with zstd
-	appLog.Info("Compressing database with zstd...")
-	compressStart := time.Now()
-	compressedPath, err := compressWithZstd(tmpPath)
+	entry, err := buildAndCompress(cfg, buildDir(), cacheDir, incrementalFrom, streamTo)
 	if err != nil {
-		os.Remove(tmpPath)
-		return "", fmt.Errorf("failed to compress database: %w", err)
+		return "", err
 	}
 
-	// Remove the uncompressed file
-	os.Remove(tmpPath)
+	metrics.observeGeneration(time.Since(generationStart), entry.compressionRatio, entry.projectCount, entry.mentionCount)
 
-	// Get compressed file size
-	compressedInfo, err := os.Stat(compressedPath)
-	if err == nil {
-		compressedSize := compressedInfo.Size()
-		ratio := float64(uncompressedSize) / float64(compressedSize)
-		appLog.Info("Compressed database size: %.2f MB (%.1fx compression) in %s",
-			float64(compressedSize)/(1024*1024), ratio, time.Since(compressStart))
+	// Update cache
+	lastBuildSizes.uncompressed, lastBuildSizes.compressed = entry.uncompressedSize, entry.compressedSize
+	entry.createdAt = time.Now()
+	entry.dataVersion = dataVersion
+	old := fullCache.Set(entry)
+	if err := saveCacheMetadata(cacheDir, entry); err != nil {
+		appLog.Warn("Failed to save cache metadata, the cache won't survive a restart: %v", err)
 	}
 
-	// Update cache
-	cachedCompressedPath = compressedPath
-	cacheCreatedAt = time.Now()
+	// Remove the previous file only once the new one is in place, so it can be served
+	// as stale data during generation, and only after requests that looked it up just
+	// before the swap have opened it. Open readers keep their file handle.
+	if old.path != "" && old.path != entry.path {
+		removeAfterGrace(old.path)
+		removeDerivedFiles(old.path)
+	}
+
+	return entry.path, nil
+}
 
-	return compressedPath, nil
+// zstdLevel is the encoder level used by compressWithZstd (ZSTD_LEVEL)
+var zstdLevel = zstd.SpeedBestCompression
+
+// parseZstdLevel maps a ZSTD_LEVEL value (fastest, default, better, best) to an encoder level
+func parseZstdLevel(value string) (zstd.EncoderLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "fastest":
+		return zstd.SpeedFastest, nil
+	case "default":
+		return zstd.SpeedDefault, nil
+	case "better":
+		return zstd.SpeedBetterCompression, nil
+	case "best":
+		return zstd.SpeedBestCompression, nil
+	}
+	return 0, fmt.Errorf("unknown level %q (want fastest, default, better, or best)", value)
 }
 
 // compressWithZstd compresses a file using zstd and returns the path to the compressed file
 func compressWithZstd(inputPath string) (string, error) {
-	// Create output file
-	outputPath := inputPath + ".zst"
-	outputFile, err := os.Create(outputPath)
+	return compressWithZstdTo(inputPath, filepath.Dir(inputPath), nil)
+}
+
+// compressWithZstdTo is compressWithZstd writing into outputDir (with the input's name plus
+// ".zst"), that also writes the compressed bytes to extra, if non-nil. Errors from extra
+// are ignored, so a slow or vanished client can't break the cache file; wrap it in a
+// clientWriter to find out whether it failed.
+func compressWithZstdTo(inputPath, outputDir string, extra io.Writer) (string, error) {
+	// Create output file. The name is only unique in the input's directory, so never
+	// overwrite a file that's already in outputDir.
+	outputPath := filepath.Join(outputDir, filepath.Base(inputPath)+".zst")
+	outputFile, err := os.OpenFile(outputPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
 	if err != nil {
 		return "", fmt.Errorf("failed to create output file: %w", err)
 	}
 	defer outputFile.Close()
 
-	// Create zstd encoder with best compression
-	encoder, err := zstd.NewWriter(outputFile, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	var output io.Writer = outputFile
+	if extra != nil {
+		output = io.MultiWriter(outputFile, &clientWriter{w: extra})
+	}
+
+	// Create zstd encoder at the configured level, with the dictionary if there is one
+	encoder, err := zstd.NewWriter(output, zstdEncoderOptions()...)
 	if err != nil {
 		os.Remove(outputPath)
 		return "", fmt.Errorf("failed to create zstd encoder: %w", err)
@@ -483,300 +1375,214 @@ func compressWithZstd(inputPath string) (string, error) {
 	return outputPath, nil
 }
 
+// verifyZstd decompresses the file at path in full, discarding the output, to confirm
+// it's a valid zstd stream before it's cached. If expectedSize is positive the
+// decompressed length must match it too.
+func verifyZstd(path string, expectedSize int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening compressed file: %w", err)
+	}
+	defer file.Close()
+
+	decoder, err := newZstdReader(file)
+	if err != nil {
+		return fmt.Errorf("creating zstd decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	n, err := io.Copy(io.Discard, decoder)
+	if err != nil {
+		return fmt.Errorf("decompressing: %w", err)
+	}
+	if expectedSize > 0 && n != expectedSize {
+		return fmt.Errorf("decompressed to %d bytes, expected %d", n, expectedSize)
+	}
+	return nil
+}
+
 // serveCachedDB sends the cached zstd-compressed database file to the client
-func serveCachedDB(w http.ResponseWriter, compressedPath string, requestStart time.Time) {
+func serveCachedDB(w http.ResponseWriter, r *http.Request, compressedPath, disposition string, requestStart time.Time) {
 	// Open the file for reading
 	file, err := os.Open(compressedPath)
 	if err != nil {
-		appLog.Error("Failed to open file for reading: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		requestLog(r).Error("Failed to open file for reading: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 	defer file.Close()
 
-	// Get file info for size
-	fileInfo, err := file.Stat()
-	if err != nil {
-		appLog.Error("Failed to stat file: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// Set headers for zstd-compressed file download
+	if err := setDBHeaders(w, compressedPath, formatZstd, disposition); err != nil {
+		requestLog(r).Error("Failed to stat file: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	// Set headers for zstd-compressed file download
-	w.Header().Set("Content-Type", "application/zstd")
-	w.Header().Set("Content-Disposition", `attachment; filename="database.db.zst"`)
-	w.Header().Set("Content-Transfer-Encoding", "binary")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
-
-	// Copy file contents to response
-	bytesSent, err := io.Copy(w, file)
+	// Copy file contents to response, with sendfile where possible
+	bytesSent, err := sendFile(r.Context(), w, file)
 	if err != nil {
-		appLog.Error("Error writing response: %v", err)
+		logStreamError(r, bytesSent, err)
 		return
 	}
 
-	appLog.Info("Compressed database sent: %.2f MB in %s", float64(bytesSent)/(1024*1024), time.Since(requestStart))
+	requestLog(r).Info("Compressed database sent: %.2f MB in %s", float64(bytesSent)/(1024*1024), time.Since(requestStart))
 }
 
+// createSQLiteTables creates every table in sqliteTables, stamps the schema version, and
+// adds the full-text search tables when SQLite supports them
 func createSQLiteTables(db *sql.DB) error {
-	// Create approved_projects table
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS approved_projects (
-			record_id TEXT PRIMARY KEY,
-			first_name TEXT,
-			last_name TEXT,
-			git_hub_username TEXT,
-			geocoded_country TEXT,
-			geocoded_country_code TEXT,
-			playable_url TEXT,
-			code_url TEXT,
-			hours_spent REAL,
-			approved_at TEXT,
-			override_hours_spent_justification TEXT,
-			age_when_approved INTEGER,
-			ysws_name TEXT,
-			email_hash TEXT
-		)
-	`)
-	if err != nil {
-		return fmt.Errorf("creating approved_projects table: %w", err)
-	}
-
-	// Create ysws_project_mentions table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS ysws_project_mentions (
-			id TEXT PRIMARY KEY,
-			ysws_project_mentions_id TEXT,
-			ysws_project_mention_searches TEXT,
-			ysws_from_ysws_approved_project TEXT,
-			record_id TEXT,
-			ysws_approved_project TEXT,
-			source TEXT,
-			link_found_at TEXT,
-			archive_url TEXT,
-			url TEXT,
-			headline TEXT,
-			date TEXT,
-			weighted_engagement_points REAL,
-			project_url TEXT,
-			engagement_count INTEGER,
-			engagement_type TEXT,
-			mentions_hack_club INTEGER,
-			published_by_hack_club INTEGER
-		)
-	`)
-	if err != nil {
-		return fmt.Errorf("creating ysws_project_mentions table: %w", err)
-	}
-
-	// Create indexes for efficient queries
-	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_mentions_record_id ON ysws_project_mentions(record_id)`)
-	if err != nil {
-		return fmt.Errorf("creating record_id index: %w", err)
+	for _, table := range sqliteTables {
+		if err := table.create(db); err != nil {
+			return err
+		}
 	}
 
-	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_mentions_approved_project ON ysws_project_mentions(ysws_approved_project)`)
-	if err != nil {
-		return fmt.Errorf("creating ysws_approved_project index: %w", err)
+	// Schema version, readable via schema_meta or PRAGMA user_version
+	if err := stampSchemaVersion(db); err != nil {
+		return err
 	}
 
-	return nil
+	// Full-text search over mention headlines and project names (skipped without FTS5)
+	return createFTSTables(db)
 }
 
-func copyApprovedProjects(sqliteDB *sql.DB) (int, error) {
+// copyApprovedProjects copies approved projects from pg into SQLite, narrowed by scope: with watermarks
+// (incremental mode), only projects approved at or after the previous maximum are copied,
+// replacing existing rows; with a YSWS name, only that program's projects.
+func copyApprovedProjects(ctx context.Context, pg Querier, sqliteDB *sql.DB, scope copyScope) (int, error) {
+	var filter pgFilter
+	insertVerb := "INSERT"
+	if scope.since != nil {
+		insertVerb = "INSERT OR REPLACE"
+		if scope.since.approvedAt != "" {
+			filter.add(`ap.approved_at >= ?`, scope.since.approvedAt)
+		}
+	}
+	if scope.ysws != "" {
+		filter.add(`ysws_name.value = ?`, scope.ysws)
+	}
+
 	// Query PostgreSQL for approved_projects data with YSWS name from child table
-	rows, err := pgDB.Query(`
-		SELECT 
-			ap.record_id,
-			ap.first_name,
-			ap.last_name,
-			ap.git_hub_username,
-			ap.geocoded_country,
-			ap.geocoded_country_code,
-			ap.playable_url,
-			ap.code_url,
-			ap.hours_spent,
-			ap.approved_at,
-			ap.override_hours_spent_justification,
-			ap.age_when_approved,
-			ysws_name.value as ysws_name,
-			ap.email
-		FROM airtable_unified_ysws_projects_db.approved_projects ap
-		LEFT JOIN airtable_unified_ysws_projects_db.approved_projects__ysws_name ysws_name
-			ON ap._dlt_id = ysws_name._dlt_parent_id
-			AND ysws_name._dlt_list_idx = 0
-	`)
+	timing := newCopyTiming()
+	rows, err := pg.QueryContext(ctx, inSchema(approvedProjectsQuery+filter.where()), filter.args...)
 	if err != nil {
 		return 0, fmt.Errorf("querying PostgreSQL: %w", err)
 	}
 	defer rows.Close()
+	timing.lap(&timing.query)
 
-	// Begin transaction for faster inserts
-	tx, err := sqliteDB.Begin()
+	// Insert in transactions of batchSize rows: fast, without an unbounded journal
+	inserter, err := newBatchInserter(ctx, sqliteDB,
+		approvedProjectsTable.insertStatement(insertVerb),
+		`INSERT INTO projects_fts (record_id, ysws_name, first_name, last_name) VALUES (?, ?, ?, ?)`)
 	if err != nil {
-		return 0, fmt.Errorf("beginning transaction: %w", err)
+		return 0, err
 	}
-
-	// Prepare SQLite insert statement
-	stmt, err := tx.Prepare(`
-		INSERT INTO approved_projects (
-			record_id, first_name, last_name, git_hub_username, geocoded_country,
-			geocoded_country_code, playable_url, code_url,
-			hours_spent, approved_at, override_hours_spent_justification, age_when_approved,
-			ysws_name, email_hash
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		tx.Rollback()
-		return 0, fmt.Errorf("preparing insert statement: %w", err)
-	}
-	defer stmt.Close()
+	defer inserter.rollback()
+	timing.lap(&timing.insert)
 
 	count := 0
 	for rows.Next() {
-		var recordID, firstName, lastName, gitHubUsername, geocodedCountry sql.NullString
-		var geocodedCountryCode, playableURL, codeURL sql.NullString
-		var hoursSpent sql.NullFloat64
-		var approvedAt, overrideHoursJustification sql.NullString
-		var ageWhenApproved sql.NullInt64
-		var yswsName sql.NullString
-		var email sql.NullString
-
-		err := rows.Scan(
-			&recordID, &firstName, &lastName, &gitHubUsername, &geocodedCountry,
-			&geocodedCountryCode, &playableURL, &codeURL,
-			&hoursSpent, &approvedAt, &overrideHoursJustification, &ageWhenApproved,
-			&yswsName, &email,
-		)
+		values, err := scope.cfg.scanApprovedProject(rows)
 		if err != nil {
-			tx.Rollback()
-			return 0, fmt.Errorf("scanning row: %w", err)
-		}
-
-		// Hash the email if present
-		var emailHash *string
-		if email.Valid && email.String != "" {
-			h := hashEmail(email.String)
-			emailHash = &h
+			return 0, err
 		}
+		timing.lap(&timing.read)
 
-		_, err = stmt.Exec(
-			nullStringToPtr(recordID), nullStringToPtr(firstName),
-			nullStringToPtr(lastName), nullStringToPtr(gitHubUsername), nullStringToPtr(geocodedCountry),
-			nullStringToPtr(geocodedCountryCode),
-			normalizeURL(playableURL), normalizeURL(codeURL),
-			nullFloat64ToPtr(hoursSpent), nullStringToPtr(approvedAt),
-			nullStringToPtr(overrideHoursJustification), nullInt64ToPtr(ageWhenApproved),
-			nullStringToPtr(yswsName), emailHash,
+		err = inserter.insert(values,
+			values[approvedProjectIndex["record_id"]], values[approvedProjectIndex["ysws_name"]],
+			values[approvedProjectIndex["first_name"]], values[approvedProjectIndex["last_name"]],
 		)
 		if err != nil {
-			tx.Rollback()
-			return 0, fmt.Errorf("inserting row: %w", err)
+			return 0, err
 		}
+		timing.lap(&timing.insert)
 		count++
 	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("reading rows: %w", err)
+	}
+	timing.lap(&timing.read)
 
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("committing transaction: %w", err)
+	if err := inserter.commit(); err != nil {
+		return 0, err
 	}
+	timing.lap(&timing.insert)
+	timing.log("approved_projects", count)
 
 	return count, nil
 }
 
-func copyProjectMentions(sqliteDB *sql.DB) (int, error) {
+// yswsProjectIDs selects the record IDs of one program's projects, for filtering mentions
+const yswsProjectIDs = `(
+			SELECT ap.record_id
+			FROM {schema}.approved_projects ap
+			JOIN {schema}.approved_projects__ysws_name ysws_name
+				ON ap._dlt_id = ysws_name._dlt_parent_id
+				AND ysws_name._dlt_list_idx = 0
+			WHERE ysws_name.value = ?
+		)`
+
+// copyProjectMentions copies mentions from pg into SQLite, narrowed by scope: with watermarks
+// (incremental mode), only mentions found at or after the previous maximum are copied,
+// replacing existing rows; with a YSWS name, only mentions of that program's projects.
+func copyProjectMentions(ctx context.Context, pg Querier, sqliteDB *sql.DB, scope copyScope) (int, error) {
+	var filter pgFilter
+	insertVerb := "INSERT"
+	if scope.since != nil {
+		insertVerb = "INSERT OR REPLACE"
+		if scope.since.mentionFoundAt != "" {
+			filter.add(`link_found_at >= ?`, scope.since.mentionFoundAt)
+		}
+	}
+	if scope.ysws != "" {
+		filter.add(`ysws_approved_project IN `+yswsProjectIDs, scope.ysws)
+	}
+
 	// Query PostgreSQL for ysws_project_mentions data
-	rows, err := pgDB.Query(`
-		SELECT 
-			id,
-			ysws_project_mentions_id,
-			ysws_project_mention_searches,
-			ysws_from_ysws_approved_project,
-			record_id,
-			ysws_approved_project,
-			source,
-			link_found_at,
-			archive_url,
-			url,
-			headline,
-			date,
-			weighted_engagement_points,
-			project_url,
-			engagement_count,
-			engagement_type,
-			mentions_hack_club,
-			published_by_hack_club
-		FROM airtable_unified_ysws_projects_db.ysws_project_mentions
-	`)
+	timing := newCopyTiming()
+	rows, err := pg.QueryContext(ctx, inSchema(projectMentionsQuery+filter.where()), filter.args...)
 	if err != nil {
 		return 0, fmt.Errorf("querying PostgreSQL: %w", err)
 	}
 	defer rows.Close()
+	timing.lap(&timing.query)
 
-	// Begin transaction for faster inserts
-	tx, err := sqliteDB.Begin()
+	// Insert in transactions of batchSize rows: fast, without an unbounded journal
+	inserter, err := newBatchInserter(ctx, sqliteDB,
+		projectMentionsTable.insertStatement(insertVerb),
+		`INSERT INTO mentions_fts (id, headline) VALUES (?, ?)`)
 	if err != nil {
-		return 0, fmt.Errorf("beginning transaction: %w", err)
+		return 0, err
 	}
-
-	// Prepare SQLite insert statement
-	stmt, err := tx.Prepare(`
-		INSERT INTO ysws_project_mentions (
-			id, ysws_project_mentions_id, ysws_project_mention_searches,
-			ysws_from_ysws_approved_project, record_id, ysws_approved_project,
-			source, link_found_at, archive_url, url, headline, date,
-			weighted_engagement_points, project_url, engagement_count,
-			engagement_type, mentions_hack_club, published_by_hack_club
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		tx.Rollback()
-		return 0, fmt.Errorf("preparing insert statement: %w", err)
-	}
-	defer stmt.Close()
+	defer inserter.rollback()
+	timing.lap(&timing.insert)
 
 	count := 0
 	for rows.Next() {
-		var id, mentionsID, mentionSearches, fromApproved sql.NullString
-		var recordID, yswsApproved, source, linkFoundAt sql.NullString
-		var archiveURL, url, headline, date sql.NullString
-		var weightedEngagement sql.NullFloat64
-		var projectURL, engagementType sql.NullString
-		var engagementCount sql.NullInt64
-		var mentionsHackClub, publishedByHackClub sql.NullBool
-
-		err := rows.Scan(
-			&id, &mentionsID, &mentionSearches, &fromApproved,
-			&recordID, &yswsApproved, &source, &linkFoundAt,
-			&archiveURL, &url, &headline, &date,
-			&weightedEngagement, &projectURL, &engagementCount,
-			&engagementType, &mentionsHackClub, &publishedByHackClub,
-		)
+		values, err := scanProjectMention(rows)
 		if err != nil {
-			tx.Rollback()
-			return 0, fmt.Errorf("scanning row: %w", err)
-		}
-
-		_, err = stmt.Exec(
-			nullStringToPtr(id), nullStringToPtr(mentionsID),
-			nullStringToPtr(mentionSearches), nullStringToPtr(fromApproved),
-			nullStringToPtr(recordID), nullStringToPtr(yswsApproved),
-			nullStringToPtr(source), nullStringToPtr(linkFoundAt),
-			normalizeURL(archiveURL), normalizeURL(url),
-			nullStringToPtr(headline), nullStringToPtr(date),
-			nullFloat64ToPtr(weightedEngagement), normalizeURL(projectURL),
-			nullInt64ToPtr(engagementCount), nullStringToPtr(engagementType),
-			nullBoolToInt(mentionsHackClub), nullBoolToInt(publishedByHackClub),
-		)
-		if err != nil {
-			tx.Rollback()
-			return 0, fmt.Errorf("inserting row: %w", err)
+			return 0, err
+		}
+		timing.lap(&timing.read)
+
+		if err := inserter.insert(values, values[projectMentionIndex["id"]], values[projectMentionIndex["headline"]]); err != nil {
+			return 0, err
 		}
+		timing.lap(&timing.insert)
 		count++
 	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("reading rows: %w", err)
+	}
+	timing.lap(&timing.read)
 
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("committing transaction: %w", err)
+	if err := inserter.commit(); err != nil {
+		return 0, err
 	}
+	timing.lap(&timing.insert)
+	timing.log("ysws_project_mentions", count)
 
 	return count, nil
 }
@@ -811,68 +1617,3 @@ func nullBoolToInt(nb sql.NullBool) interface{} {
 	}
 	return nil
 }
-
-// dangerousSchemes contains URL schemes that should be rejected for security reasons.
-// These schemes can be used for XSS attacks if URLs are rendered in HTML contexts.
-var dangerousSchemes = []string{
-	"javascript:",
-	"data:",
-	"vbscript:",
-	"file:",
-}
-
-// normalizeURL normalizes a URL by:
-// - Trimming whitespace
-// - Lowercasing
-// - Rejecting dangerous URL schemes (javascript:, data:, vbscript:, file:)
-// - Adding https:// prefix if no scheme is present
-// - Removing .git suffix (for GitHub clone URLs)
-// - Removing /tree/... paths from GitHub URLs (branch references)
-// - Removing trailing slashes (so /repo and /repo/ are treated the same)
-func normalizeURL(ns sql.NullString) interface{} {
-	if !ns.Valid || ns.String == "" {
-		return nil
-	}
-
-	// Trim whitespace and normalize multiple spaces
-	url := strings.TrimSpace(ns.String)
-	// Replace multiple spaces with single space, then remove all spaces
-	url = strings.Join(strings.Fields(url), "")
-
-	// Lowercase the URL for consistent comparison
-	url = strings.ToLower(url)
-
-	// Reject dangerous URL schemes (must be done after lowercasing to catch all case variations)
-	for _, scheme := range dangerousSchemes {
-		if strings.HasPrefix(url, scheme) {
-			return nil
-		}
-	}
-
-	// Add https:// if no scheme present
-	if url != "" && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-		url = "https://" + url
-	}
-
-	// Remove trailing slashes for consistent comparison
-	// (e.g., github.com/user/repo/ and github.com/user/repo should be the same)
-	// This must happen before .git removal so that .git/ is handled correctly
-	url = strings.TrimRight(url, "/")
-
-	// Remove .git suffix (common in GitHub clone URLs)
-	url = strings.TrimSuffix(url, ".git")
-
-	// Remove /tree/... paths from GitHub URLs (these are branch/tag references, not file paths)
-	// Keep /blob/... paths intact as they reference specific files
-	if strings.Contains(url, "github.com/") {
-		if idx := strings.Index(url, "/tree/"); idx != -1 {
-			url = url[:idx]
-		}
-	}
-
-	if url == "" {
-		return nil
-	}
-
-	return url
-}
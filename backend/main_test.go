@@ -1,158 +1,252 @@
 package main
 
 import (
-	"database/sql"
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
 )
 
-func TestNormalizeURL(t *testing.T) {
+func TestCheckSaltDiffersFromAPIKey(t *testing.T) {
+	if err := checkSaltDiffersFromAPIKey("same-secret", "same-secret"); err == nil {
+		t.Error("checkSaltDiffersFromAPIKey() expected error when salt equals API key")
+	}
+	if err := checkSaltDiffersFromAPIKey("salt-value", "api-key-value"); err != nil {
+		t.Errorf("checkSaltDiffersFromAPIKey() unexpected error for distinct values: %v", err)
+	}
+}
+
+func TestPrintGeneratedKeyAllowed(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    sql.NullString
-		expected interface{}
+		env, setting string
+		want         bool
 	}{
-		{
-			name:     "lowercase GitHub URL",
-			input:    sql.NullString{String: "https://GitHub.com", Valid: true},
-			expected: "https://github.com",
-		},
-		{
-			name:     "add https prefix",
-			input:    sql.NullString{String: "github.com", Valid: true},
-			expected: "https://github.com",
-		},
-		{
-			name:     "trim whitespace and lowercase",
-			input:    sql.NullString{String: "  GITHUB.COM  ", Valid: true},
-			expected: "https://github.com",
-		},
-		{
-			name:     "preserve http prefix",
-			input:    sql.NullString{String: "http://Example.com", Valid: true},
-			expected: "http://example.com",
-		},
-		{
-			name:     "handle extra spaces in URL",
-			input:    sql.NullString{String: "github .com/user/repo", Valid: true},
-			expected: "https://github.com/user/repo",
-		},
-		{
-			name:     "full URL with path",
-			input:    sql.NullString{String: "https://GitHub.com/SomeOrg/SomeRepo", Valid: true},
-			expected: "https://github.com/someorg/somerepo",
-		},
-		{
-			name:     "null string returns nil",
-			input:    sql.NullString{String: "", Valid: false},
-			expected: nil,
-		},
-		{
-			name:     "empty string returns nil",
-			input:    sql.NullString{String: "", Valid: true},
-			expected: nil,
-		},
-		{
-			name:     "whitespace only returns nil",
-			input:    sql.NullString{String: "   ", Valid: true},
-			expected: nil,
-		},
-		{
-			name:     "remove .git suffix from GitHub URL",
-			input:    sql.NullString{String: "https://github.com/user/my-project.git", Valid: true},
-			expected: "https://github.com/user/my-project",
-		},
-		{
-			name:     "remove .git suffix without scheme",
-			input:    sql.NullString{String: "github.com/user/repo.git", Valid: true},
-			expected: "https://github.com/user/repo",
-		},
-		{
-			name:     "remove /tree/branch from GitHub URL",
-			input:    sql.NullString{String: "https://github.com/user/my-project/tree/master", Valid: true},
-			expected: "https://github.com/user/my-project",
-		},
-		{
-			name:     "remove /tree/branch with nested path from GitHub URL",
-			input:    sql.NullString{String: "https://github.com/user/repo/tree/main/src/components", Valid: true},
-			expected: "https://github.com/user/repo",
-		},
-		// Trailing slash normalization tests
-		{
-			name:     "remove trailing slash from GitHub repo URL",
-			input:    sql.NullString{String: "https://github.com/someuser/somerepo/", Valid: true},
-			expected: "https://github.com/someuser/somerepo",
-		},
-		{
-			name:     "URL without trailing slash stays the same",
-			input:    sql.NullString{String: "https://github.com/someuser/somerepo", Valid: true},
-			expected: "https://github.com/someuser/somerepo",
-		},
-		{
-			name:     "remove multiple trailing slashes",
-			input:    sql.NullString{String: "https://github.com/someuser/somerepo///", Valid: true},
-			expected: "https://github.com/someuser/somerepo",
-		},
-		{
-			name:     "trailing slash with .git suffix",
-			input:    sql.NullString{String: "https://github.com/someuser/somerepo.git/", Valid: true},
-			expected: "https://github.com/someuser/somerepo",
-		},
-		{
-			name:     "preserve /blob/ path in GitHub URL",
-			input:    sql.NullString{String: "https://github.com/user/repo/blob/main/src/file.txt", Valid: true},
-			expected: "https://github.com/user/repo/blob/main/src/file.txt",
-		},
-		// Security: Dangerous URL scheme tests
-		{
-			name:     "reject javascript: scheme",
-			input:    sql.NullString{String: "javascript:alert(1)", Valid: true},
-			expected: nil,
-		},
-		{
-			name:     "reject JavaScript: scheme (mixed case)",
-			input:    sql.NullString{String: "JavaScript:alert(document.cookie)", Valid: true},
-			expected: nil,
-		},
-		{
-			name:     "reject JAVASCRIPT: scheme (uppercase)",
-			input:    sql.NullString{String: "JAVASCRIPT:void(0)", Valid: true},
-			expected: nil,
-		},
-		{
-			name:     "reject data: scheme",
-			input:    sql.NullString{String: "data:text/html,<script>alert(1)</script>", Valid: true},
-			expected: nil,
-		},
-		{
-			name:     "reject DATA: scheme (uppercase)",
-			input:    sql.NullString{String: "DATA:text/html,<script>alert(1)</script>", Valid: true},
-			expected: nil,
-		},
-		{
-			name:     "reject vbscript: scheme",
-			input:    sql.NullString{String: "vbscript:msgbox(1)", Valid: true},
-			expected: nil,
-		},
-		{
-			name:     "reject file: scheme",
-			input:    sql.NullString{String: "file:///etc/passwd", Valid: true},
-			expected: nil,
-		},
-		{
-			name:     "reject javascript: with spaces (after normalization)",
-			input:    sql.NullString{String: "java script:alert(1)", Valid: true},
-			expected: nil,
-		},
+		{"", "", true},
+		{"development", "", true},
+		{"production", "", false},
+		{"Production", "", false},
+		{"production", "true", true},
+		{"", "false", false},
+		{"", "yes", false},
+	}
+	for _, tt := range tests {
+		if got := printGeneratedKeyAllowed(tt.env, tt.setting); got != tt.want {
+			t.Errorf("printGeneratedKeyAllowed(%q, %q) = %v, want %v", tt.env, tt.setting, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSaltStrength(t *testing.T) {
+	for _, salt := range []string{
+		"abc",
+		"aaaaaaaaaaaaaaaaaaaaaaaa",
+		"passwordpassword",
+	} {
+		if err := checkSaltStrength(salt); err == nil {
+			t.Errorf("checkSaltStrength(%q) accepted a weak salt", salt)
+		} else if strings.Contains(err.Error(), salt) {
+			t.Errorf("checkSaltStrength(%q) error %q includes the salt", salt, err)
+		}
+	}
+
+	generated, err := generateAPIKey()
+	if err != nil {
+		t.Fatalf("generateAPIKey() error: %v", err)
+	}
+	for _, salt := range []string{generated, "correct-horse-battery-staple"} {
+		if err := checkSaltStrength(salt); err != nil {
+			t.Errorf("checkSaltStrength(%q) = %v, want nil", salt, err)
+		}
+	}
+}
+
+func TestParseZstdLevel(t *testing.T) {
+	tests := []struct {
+		value string
+		want  zstd.EncoderLevel
+	}{
+		{"fastest", zstd.SpeedFastest},
+		{"default", zstd.SpeedDefault},
+		{"Better", zstd.SpeedBetterCompression},
+		{" best ", zstd.SpeedBestCompression},
+	}
+	for _, tt := range tests {
+		got, err := parseZstdLevel(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("parseZstdLevel(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+
+	if _, err := parseZstdLevel("11"); err == nil {
+		t.Error("parseZstdLevel(\"11\") succeeded, want an error")
+	}
+}
+
+func TestVerifyZstd(t *testing.T) {
+	contents := bytes.Repeat([]byte("SQLite format 3\x00 pretend database page "), 1000)
+	rawPath := filepath.Join(t.TempDir(), "cached-db-1.db")
+	if err := os.WriteFile(rawPath, contents, 0o600); err != nil {
+		t.Fatalf("writing database: %v", err)
+	}
+	compressedPath, err := compressWithZstd(rawPath)
+	if err != nil {
+		t.Fatalf("compressWithZstd() error: %v", err)
+	}
+
+	if err := verifyZstd(compressedPath, int64(len(contents))); err != nil {
+		t.Errorf("verifyZstd() error for a valid file: %v", err)
+	}
+	if err := verifyZstd(compressedPath, int64(len(contents))+1); err == nil {
+		t.Error("verifyZstd() accepted a file with the wrong decompressed size")
+	}
+
+	info, err := os.Stat(compressedPath)
+	if err != nil {
+		t.Fatalf("stat compressed file: %v", err)
+	}
+	if err := os.Truncate(compressedPath, info.Size()/2); err != nil {
+		t.Fatalf("truncating compressed file: %v", err)
+	}
+	if err := verifyZstd(compressedPath, 0); err == nil {
+		t.Error("verifyZstd() accepted a truncated file")
+	}
+}
+
+func TestLoggerRespectsLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	prevLevel, prevOutput := minLogLevel, log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		minLogLevel = prevLevel
+		log.SetOutput(prevOutput)
+	})
+
+	level, err := parseLogLevel("WARN")
+	if err != nil {
+		t.Fatalf("parseLogLevel() error: %v", err)
+	}
+	minLogLevel = level
+
+	appLog.Debug("debug message")
+	appLog.Info("info message")
+	appLog.Warn("warn message")
+	appLog.Error("error message")
+
+	out := buf.String()
+	for _, hidden := range []string{"debug message", "info message"} {
+		if strings.Contains(out, hidden) {
+			t.Errorf("output contains %q below the warn threshold", hidden)
+		}
+	}
+	for _, shown := range []string{"warn message", "error message"} {
+		if !strings.Contains(out, shown) {
+			t.Errorf("output is missing %q", shown)
+		}
+	}
+
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("parseLogLevel(\"verbose\") expected an error")
 	}
+}
+
+func TestLoggingMiddlewareSetsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	prevOutput := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(prevOutput) })
+
+	var handlerID string
+	handler := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerID = requestInfoFrom(r).id
+		requestLog(r).Info("inside handler")
+	}))
 
+	tests := []struct {
+		name     string
+		incoming string
+		honored  bool
+	}{
+		{"generated", "", false},
+		{"client supplied", "client-abc_123.4", true},
+		{"unsafe client value", "bad id\nforged line", false},
+		{"oversized client value", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := normalizeURL(tt.input)
-			if result != tt.expected {
-				t.Errorf("normalizeURL(%q) = %v, want %v", tt.input.String, result, tt.expected)
+		buf.Reset()
+		req := httptest.NewRequest("GET", "/stats", nil)
+		if tt.incoming != "" {
+			req.Header.Set("X-Request-ID", tt.incoming)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		id := rec.Header().Get("X-Request-ID")
+		if id == "" || id != handlerID {
+			t.Fatalf("%s: X-Request-ID = %q, handler saw %q", tt.name, id, handlerID)
+		}
+		if (id == tt.incoming) != tt.honored {
+			t.Errorf("%s: X-Request-ID = %q, honored client value = %v, want %v", tt.name, id, id == tt.incoming, tt.honored)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if !strings.Contains(line, "["+id+"]") {
+				t.Errorf("%s: log line %q is missing the request ID", tt.name, line)
 			}
-		})
+		}
 	}
 }
 
+func TestDBDownloadLogsWithTheRequestID(t *testing.T) {
+	withCachedDatabase(t, []byte("SQLite format 3\x00 small database"))
+	var buf bytes.Buffer
+	prevOutput := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(prevOutput) })
+
+	for _, format := range []string{formatZstd, formatSQLite} {
+		buf.Reset()
+		req := httptest.NewRequest("GET", "/db?format="+format, nil)
+		req.Header.Set("X-Request-ID", "req-db")
+		rec := httptest.NewRecorder()
+		loggingMiddleware(dbHandler(testConfig)).ServeHTTP(rec, req)
 
+		if !strings.Contains(buf.String(), "Serving cached database") {
+			t.Fatalf("%s: logs = %q, want the download logged", format, buf.String())
+		}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if !strings.Contains(line, "[req-db]") {
+				t.Errorf("%s: log line %q is missing the request ID", format, line)
+			}
+		}
+	}
+}
+
+func TestGenerateRequestIDIsUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := generateRequestID()
+		if id == "" || seen[id] {
+			t.Fatalf("generateRequestID() = %q, want a non-empty, unseen ID", id)
+		}
+		seen[id] = true
+	}
+
+	// With the random source failing, IDs must still be unique (and never all zeros)
+	prev := randRead
+	randRead = func([]byte) (int, error) { return 0, errors.New("entropy unavailable") }
+	t.Cleanup(func() { randRead = prev })
+
+	for i := 0; i < 1000; i++ {
+		id := generateRequestID()
+		if id == "" || seen[id] || !validRequestID(id) {
+			t.Fatalf("fallback generateRequestID() = %q, want a valid, unseen ID", id)
+		}
+		seen[id] = true
+	}
+}
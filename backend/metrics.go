@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metricsKey optionally protects /metrics with its own key (METRICS_KEY), the only key
+// accepted there when set. When empty the endpoint is unauthenticated so scrapers don't
+// need the API key.
+var metricsKey string
+
+// metricsRequiresAdmin protects /metrics even without METRICS_KEY, set when ADMIN_API_KEY
+// is configured. Admin-scope API keys are then accepted, unless METRICS_KEY is set.
+var metricsRequiresAdmin bool
+
+// metricsAuthorized reports whether the key presented with r may read /metrics, checking
+// API keys against cfg
+func metricsAuthorized(cfg *Config, r *http.Request) bool {
+	if metricsKey == "" && !metricsRequiresAdmin {
+		return true
+	}
+	providedKey, _ := extractAPIKey(r)
+	if providedKey == "" {
+		return false
+	}
+	if metricsKey != "" {
+		return subtle.ConstantTimeCompare([]byte(providedKey), []byte(metricsKey)) == 1
+	}
+	entry, ok := cfg.matchAPIKey(providedKey)
+	return ok && entry.Scope.allows(scopeAdmin)
+}
+
+// generationBuckets are the histogram bucket upper bounds (in seconds) for database generation
+var generationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600}
+
+// metricsRegistry is a small Prometheus-compatible metrics store.
+// Everything lives behind one mutex since updates are infrequent compared to the work they measure.
+type metricsRegistry struct {
+	mu sync.Mutex
+
+	requestsTotal      map[int]uint64 // keyed by HTTP status code
+	cacheHits          uint64
+	cacheMisses        uint64
+	generationFailures uint64
+
+	generationCount   uint64
+	generationSum     float64
+	generationBuckets []uint64 // cumulative counts, same order as generationBuckets
+
+	compressionRatio float64
+	rowCounts        map[string]int
+}
+
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		requestsTotal:     make(map[int]uint64),
+		generationBuckets: make([]uint64, len(generationBuckets)),
+		rowCounts:         make(map[string]int),
+	}
+}
+
+// observeRequest counts a completed HTTP request by status code
+func (m *metricsRegistry) observeRequest(statusCode int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requestsTotal[statusCode]++
+}
+
+// observeCache counts a database request served from cache (hit) or requiring generation (miss)
+func (m *metricsRegistry) observeCache(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// observeGenerationFailure counts a failed database generation
+func (m *metricsRegistry) observeGenerationFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generationFailures++
+}
+
+// observeGeneration records a successful database generation
+func (m *metricsRegistry) observeGeneration(duration time.Duration, ratio float64, projectCount, mentionCount int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seconds := duration.Seconds()
+	m.generationCount++
+	m.generationSum += seconds
+	for i, bound := range generationBuckets {
+		if seconds <= bound {
+			m.generationBuckets[i]++
+		}
+	}
+
+	m.compressionRatio = ratio
+	m.rowCounts["approved_projects"] = projectCount
+	m.rowCounts["ysws_project_mentions"] = mentionCount
+}
+
+// writeTo renders all metrics in the Prometheus text exposition format.
+// cacheAge is passed in so rendering never has to touch the cache lock while holding ours.
+func (m *metricsRegistry) writeTo(w io.Writer, cacheAge time.Duration, cacheValid bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP viral_explorer_http_requests_total Total HTTP requests handled, by status code.")
+	fmt.Fprintln(w, "# TYPE viral_explorer_http_requests_total counter")
+	codes := make([]int, 0, len(m.requestsTotal))
+	for code := range m.requestsTotal {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "viral_explorer_http_requests_total{code=\"%d\"} %d\n", code, m.requestsTotal[code])
+	}
+
+	fmt.Fprintln(w, "# HELP viral_explorer_cache_requests_total Database requests by cache result.")
+	fmt.Fprintln(w, "# TYPE viral_explorer_cache_requests_total counter")
+	fmt.Fprintf(w, "viral_explorer_cache_requests_total{result=\"hit\"} %d\n", m.cacheHits)
+	fmt.Fprintf(w, "viral_explorer_cache_requests_total{result=\"miss\"} %d\n", m.cacheMisses)
+
+	fmt.Fprintln(w, "# HELP viral_explorer_generation_failures_total Failed database generations.")
+	fmt.Fprintln(w, "# TYPE viral_explorer_generation_failures_total counter")
+	fmt.Fprintf(w, "viral_explorer_generation_failures_total %d\n", m.generationFailures)
+
+	fmt.Fprintln(w, "# HELP viral_explorer_generation_duration_seconds Time spent generating the database.")
+	fmt.Fprintln(w, "# TYPE viral_explorer_generation_duration_seconds histogram")
+	for i, bound := range generationBuckets {
+		fmt.Fprintf(w, "viral_explorer_generation_duration_seconds_bucket{le=\"%s\"} %d\n",
+			strconv.FormatFloat(bound, 'f', -1, 64), m.generationBuckets[i])
+	}
+	fmt.Fprintf(w, "viral_explorer_generation_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.generationCount)
+	fmt.Fprintf(w, "viral_explorer_generation_duration_seconds_sum %s\n", strconv.FormatFloat(m.generationSum, 'f', -1, 64))
+	fmt.Fprintf(w, "viral_explorer_generation_duration_seconds_count %d\n", m.generationCount)
+
+	fmt.Fprintln(w, "# HELP viral_explorer_compression_ratio Uncompressed/compressed size of the last generated database.")
+	fmt.Fprintln(w, "# TYPE viral_explorer_compression_ratio gauge")
+	fmt.Fprintf(w, "viral_explorer_compression_ratio %s\n", strconv.FormatFloat(m.compressionRatio, 'f', -1, 64))
+
+	fmt.Fprintln(w, "# HELP viral_explorer_table_rows Rows copied into each table by the last generation.")
+	fmt.Fprintln(w, "# TYPE viral_explorer_table_rows gauge")
+	for _, table := range []string{"approved_projects", "ysws_project_mentions"} {
+		fmt.Fprintf(w, "viral_explorer_table_rows{table=\"%s\"} %d\n", table, m.rowCounts[table])
+	}
+
+	fmt.Fprintln(w, "# HELP viral_explorer_cache_age_seconds Age of the cached database, or -1 if there is none.")
+	fmt.Fprintln(w, "# TYPE viral_explorer_cache_age_seconds gauge")
+	age := -1.0
+	if cacheValid {
+		age = cacheAge.Seconds()
+	}
+	fmt.Fprintf(w, "viral_explorer_cache_age_seconds %s\n", strconv.FormatFloat(age, 'f', 3, 64))
+}
+
+// metricsHandler serves the Prometheus metrics, accepting cfg's admin keys. It only reads
+// cache state and never triggers a database generation.
+func metricsHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !metricsAuthorized(cfg, r) {
+			requestLog(r).Warn("Metrics auth failed: invalid or missing metrics or admin key")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized: metrics key is required")
+			return
+		}
+
+		entry, cacheValid := fullCache.Get()
+		cacheAge := entry.age()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.writeTo(w, cacheAge, cacheValid)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m := newMetricsRegistry()
+	m.observeRequest(200)
+	m.observeRequest(200)
+	m.observeRequest(401)
+	m.observeCache(true)
+	m.observeCache(false)
+	m.observeGeneration(7*time.Second, 4.5, 120, 340)
+
+	var buf bytes.Buffer
+	m.writeTo(&buf, 90*time.Second, true)
+	out := buf.String()
+
+	expected := []string{
+		`viral_explorer_http_requests_total{code="200"} 2`,
+		`viral_explorer_http_requests_total{code="401"} 1`,
+		`viral_explorer_cache_requests_total{result="hit"} 1`,
+		`viral_explorer_cache_requests_total{result="miss"} 1`,
+		`viral_explorer_generation_duration_seconds_bucket{le="5"} 0`,
+		`viral_explorer_generation_duration_seconds_bucket{le="10"} 1`,
+		`viral_explorer_generation_duration_seconds_bucket{le="+Inf"} 1`,
+		`viral_explorer_generation_duration_seconds_count 1`,
+		`viral_explorer_compression_ratio 4.5`,
+		`viral_explorer_table_rows{table="approved_projects"} 120`,
+		`viral_explorer_table_rows{table="ysws_project_mentions"} 340`,
+		`viral_explorer_cache_age_seconds 90.000`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics output missing %q\n%s", line, out)
+		}
+	}
+}
+
+func TestMetricsCacheAgeWithoutCache(t *testing.T) {
+	var buf bytes.Buffer
+	newMetricsRegistry().writeTo(&buf, 0, false)
+	if !strings.Contains(buf.String(), "viral_explorer_cache_age_seconds -1.000\n") {
+		t.Errorf("expected cache age of -1 without a cache, got:\n%s", buf.String())
+	}
+}
+
+func TestMetricsAuthorized(t *testing.T) {
+	cfg := &Config{APIKeys: []apiKeyEntry{{Name: "read", Key: "read-key", Scope: scopeRead}, {Name: "admin", Key: "admin-key", Scope: scopeAdmin}}}
+	prevKey, prevAdmin := metricsKey, metricsRequiresAdmin
+	t.Cleanup(func() { metricsKey, metricsRequiresAdmin = prevKey, prevAdmin })
+
+	request := func(key string) *http.Request {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		return req
+	}
+
+	metricsKey, metricsRequiresAdmin = "", false
+	if !metricsAuthorized(cfg, request("")) {
+		t.Error("unprotected /metrics rejected an anonymous request")
+	}
+
+	// With METRICS_KEY set, it's the only key accepted
+	metricsKey, metricsRequiresAdmin = "scrape-key", true
+	for key, want := range map[string]bool{"": false, "scrape-key": true, "admin-key": false, "read-key": false} {
+		if got := metricsAuthorized(cfg, request(key)); got != want {
+			t.Errorf("metricsAuthorized(%q) = %v, want %v", key, got, want)
+		}
+	}
+
+	// Without it, ADMIN_API_KEY protects /metrics and admin-scope keys are accepted
+	metricsKey, metricsRequiresAdmin = "", true
+	for key, want := range map[string]bool{"": false, "scrape-key": false, "admin-key": true, "read-key": false} {
+		if got := metricsAuthorized(cfg, request(key)); got != want {
+			t.Errorf("metricsAuthorized(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// dangerousSchemes contains URL schemes that should be rejected for security reasons.
+// These schemes can be used for XSS attacks if URLs are rendered in HTML contexts.
+var dangerousSchemes = []string{
+	"javascript:",
+	"data:",
+	"vbscript:",
+	"file:",
+}
+
+// stripURLFragments controls whether normalizeURL drops #fragments (on by default;
+// URL_FRAGMENTS=keep turns it off). Fragments like #L10-L20 or #readme don't change
+// which resource a URL points at, so keeping them creates spurious duplicates.
+var stripURLFragments = true
+
+// rejectURLCredentials makes normalizeURL reject URLs with a user:password@ part instead
+// of stripping it (URL_CREDENTIALS=reject). Either way the credentials never reach the
+// database, where they'd leak and split one link into several.
+var rejectURLCredentials bool
+
+// normalizeStep records one transformation applied while normalizing a URL
+type normalizeStep struct {
+	Step  string `json:"step"`
+	Value string `json:"value"`
+}
+
+// normalizeTrace collects the steps taken by normalizeURLWithTrace
+type normalizeTrace struct {
+	Steps []normalizeStep
+}
+
+// record appends a step to the trace. Safe to call on a nil trace, so the
+// normal (untraced) path pays nothing for it.
+func (t *normalizeTrace) record(step, value string) {
+	if t == nil {
+		return
+	}
+	t.Steps = append(t.Steps, normalizeStep{Step: step, Value: value})
+}
+
+// normalizeURL normalizes a nullable database value for insertion, returning the cleaned
+// URL as a string or nil if it's missing or invalid. The pipeline is:
+// - Trimming whitespace
+// - Lowercasing
+// - Rejecting dangerous URL schemes (javascript:, data:, vbscript:, file:)
+// - Adding https:// prefix if no scheme is present
+// - Removing user:password@ credentials from the host (or rejecting the URL if URL_CREDENTIALS=reject)
+// - Removing a leading www. from the host and percent-decoding unreserved characters (%2d → -)
+// - Removing #fragments (unless URL_FRAGMENTS=keep) and utm_* tracking parameters (except on GitHub)
+// - Removing .git suffix (for GitHub clone URLs)
+// - Removing branch references (GitHub /tree/..., GitLab /-/tree/..., Bitbucket bare /src/<ref>)
+// - Removing trailing slashes (so /repo and /repo/ are treated the same)
+func normalizeURL(ns sql.NullString) interface{} {
+	if !ns.Valid || ns.String == "" {
+		return nil
+	}
+
+	url, ok := normalizeURLString(ns.String)
+	if !ok {
+		return nil
+	}
+	return url
+}
+
+// normalizeURLString normalizes a URL as described on normalizeURL, returning the cleaned
+// URL and whether it's valid (false for empty input or a rejected scheme)
+func normalizeURLString(s string) (string, bool) {
+	return normalizeURLWithTrace(s, nil)
+}
+
+// normalizeURLWithTrace runs the normalization pipeline described on normalizeURL.
+// When trace is non-nil, every step that changes the URL is recorded, ending with
+// either the result or the reason the URL was rejected.
+func normalizeURLWithTrace(raw string, trace *normalizeTrace) (string, bool) {
+	// Remove all whitespace, surrounding and interior
+	url := removeSpaces(raw)
+	if url != raw {
+		trace.record("trimmed", url)
+	}
+
+	// Lowercase the URL for consistent comparison
+	if lower := strings.ToLower(url); lower != url {
+		url = lower
+		trace.record("lowercased", url)
+	}
+
+	// Reject dangerous URL schemes (must be done after lowercasing to catch all case variations)
+	for _, scheme := range dangerousSchemes {
+		if strings.HasPrefix(url, scheme) {
+			trace.record("rejected: dangerous scheme", url)
+			return "", false
+		}
+	}
+
+	// Add https:// if no scheme present
+	if url != "" && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "https://" + url
+		trace.record("scheme-added", url)
+	}
+
+	// https://token@github.com/u/r is https://github.com/u/r with a secret attached
+	if stripped := stripUserinfo(url); stripped != url {
+		if rejectURLCredentials {
+			trace.record("rejected: credentials", url)
+			return "", false
+		}
+		url = stripped
+		trace.record("credentials-stripped", url)
+	}
+
+	// www.github.com and github.com are the same site
+	if stripped := stripWWW(url); stripped != url {
+		url = stripped
+		trace.record("www-stripped", url)
+	}
+
+	// %2d and - are the same character; reserved characters like %2f stay encoded
+	if decoded := decodeUnreserved(url); decoded != url {
+		url = decoded
+		trace.record("percent-decoded", url)
+	}
+
+	// Drop the fragment: it doesn't affect resource identity (e.g. #L5 on a file)
+	if stripURLFragments {
+		if idx := strings.IndexByte(url, '#'); idx != -1 {
+			url = url[:idx]
+			trace.record("fragment-stripped", url)
+		}
+	}
+
+	// Drop utm_* tracking parameters, which vary between shares of the same page
+	if !strings.Contains(url, "github.com/") {
+		if stripped := stripTrackingParams(url); stripped != url {
+			url = stripped
+			trace.record("tracking-params-stripped", url)
+		}
+	}
+
+	// Remove trailing slashes for consistent comparison
+	// (e.g., github.com/user/repo/ and github.com/user/repo should be the same)
+	// This must happen before .git removal so that .git/ is handled correctly
+	if trimmed := strings.TrimRight(url, "/"); trimmed != url {
+		url = trimmed
+		trace.record("trailing-slash-stripped", url)
+	}
+
+	// Remove .git suffix (common in GitHub clone URLs)
+	if trimmed := strings.TrimSuffix(url, ".git"); trimmed != url {
+		url = trimmed
+		trace.record("git-stripped", url)
+	}
+
+	// Remove branch/tag references (not file paths) from code host URLs
+	if stripped := stripBranchRef(url); stripped != url {
+		url = stripped
+		trace.record("tree-stripped", url)
+	}
+
+	if url == "" {
+		trace.record("rejected: empty", url)
+		return "", false
+	}
+
+	// Inputs like "/" or "https://" leave nothing that points anywhere
+	if !hasHost(url) {
+		trace.record("rejected: no host", url)
+		return "", false
+	}
+
+	trace.record("result", url)
+	return url, true
+}
+
+// removeSpaces removes every Unicode whitespace character from s, leaving other bytes
+// (including invalid UTF-8) as they are. Most URLs have none, so that case doesn't allocate.
+func removeSpaces(s string) string {
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if unicode.IsSpace(r) {
+				break
+			}
+			i += size
+			continue
+		}
+		if asciiSpace[c] {
+			break
+		}
+		i++
+	}
+	if i == len(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	b.WriteString(s[:i])
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !unicode.IsSpace(r) {
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// asciiSpace marks the ASCII bytes unicode.IsSpace accepts
+var asciiSpace = [utf8.RuneSelf]bool{'\t': true, '\n': true, '\v': true, '\f': true, '\r': true, ' ': true}
+
+// hasHost reports whether a URL has something between "://" and the path
+func hasHost(url string) bool {
+	_, rest, ok := strings.Cut(url, "://")
+	return ok && rest != "" && rest[0] != '/' && rest[0] != '?' && rest[0] != '#'
+}
+
+// stripUserinfo removes the "user:password@" part from the host of a URL with a scheme.
+// Only the authority is searched, so paths like medium.com/@user are left alone.
+func stripUserinfo(url string) string {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok {
+		return url
+	}
+	authority := rest
+	if end := strings.IndexAny(rest, "/?#"); end != -1 {
+		authority = rest[:end]
+	}
+	at := strings.LastIndexByte(authority, '@')
+	if at == -1 {
+		return url
+	}
+	return scheme + "://" + rest[at+1:]
+}
+
+// stripWWW removes a leading "www." from the host of a URL with a scheme
+func stripWWW(url string) string {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok || !strings.HasPrefix(rest, "www.") {
+		return url
+	}
+	return scheme + "://" + strings.TrimPrefix(rest, "www.")
+}
+
+// decodeUnreserved percent-decodes the unreserved characters of RFC 3986 (letters, digits,
+// "-", ".", "_", "~"), which mean the same thing encoded or not. Everything else, like
+// %2f ("/") or %3f ("?"), stays encoded since decoding it would change the URL's meaning.
+// Decoded letters are lowercased to match the rest of the normalized URL.
+func decodeUnreserved(url string) string {
+	if !strings.Contains(url, "%") {
+		return url
+	}
+
+	var b strings.Builder
+	b.Grow(len(url))
+	for i := 0; i < len(url); i++ {
+		if url[i] == '%' && i+2 < len(url) {
+			if c, ok := unhex(url[i+1], url[i+2]); ok && isUnreserved(c) {
+				if 'A' <= c && c <= 'Z' {
+					c += 'a' - 'A'
+				}
+				b.WriteByte(c)
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(url[i])
+	}
+	return b.String()
+}
+
+func unhex(hi, lo byte) (byte, bool) {
+	h, ok1 := hexValue(hi)
+	l, ok2 := hexValue(lo)
+	return h<<4 | l, ok1 && ok2
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// stripTrackingParams removes utm_* parameters from a URL's query string, dropping the
+// "?" if nothing is left. Other parameters keep their order and encoding.
+func stripTrackingParams(url string) string {
+	base, query, ok := strings.Cut(url, "?")
+	if !ok {
+		return url
+	}
+	fragment := ""
+	if idx := strings.IndexByte(query, '#'); idx != -1 {
+		query, fragment = query[:idx], query[idx:]
+	}
+
+	// Nothing to drop: no tracking parameters and no empty ones
+	if query != "" && query[0] != '&' && query[len(query)-1] != '&' &&
+		!strings.Contains(query, "utm_") && !strings.Contains(query, "&&") {
+		return url
+	}
+
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		if param != "" && !strings.HasPrefix(param, "utm_") {
+			kept = append(kept, param)
+		}
+	}
+	if len(kept) == 0 {
+		return base + fragment
+	}
+	return base + "?" + strings.Join(kept, "&") + fragment
+}
+
+// stripBranchRef removes branch/tag references from repository URLs on the code hosts we
+// recognize, keeping paths that point at specific files:
+//   - GitHub: /tree/<ref>/... is stripped, /blob/<ref>/<file> is kept
+//   - GitLab: /-/tree/<ref>/... is stripped, /-/blob/<ref>/<file> is kept
+//   - Bitbucket: /src/<ref> alone is stripped, /src/<ref>/<file> is kept (Bitbucket uses
+//     /src/ for both, so only a bare ref is known to be a branch view)
+func stripBranchRef(url string) string {
+	switch {
+	case strings.Contains(url, "github.com/"):
+		if idx := strings.Index(url, "/tree/"); idx != -1 {
+			return url[:idx]
+		}
+	case strings.Contains(url, "gitlab.com/"):
+		if idx := strings.Index(url, "/-/tree/"); idx != -1 {
+			return url[:idx]
+		}
+	case strings.Contains(url, "bitbucket.org/"):
+		host := strings.Index(url, "bitbucket.org/") + len("bitbucket.org/")
+		parts := strings.Split(url[host:], "/")
+		if len(parts) == 4 && parts[2] == "src" {
+			return url[:len(url)-len("/src/")-len(parts[3])]
+		}
+	}
+	return url
+}
+
+// normalizeResponse is the body returned by /normalize
+type normalizeResponse struct {
+	Input    string          `json:"input"`
+	Steps    []normalizeStep `json:"steps"`
+	Result   *string         `json:"result"`
+	Rejected string          `json:"rejected,omitempty"`
+}
+
+// normalizeHandler explains how normalizeURL treats a given URL, step by step,
+// to answer "why did this link dedup/disappear?" without digging through logs
+func normalizeHandler(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("url")
+	if raw == "" {
+		writeJSONError(w, http.StatusBadRequest, "Bad Request: url parameter is required")
+		return
+	}
+
+	trace := &normalizeTrace{}
+	result, ok := normalizeURLWithTrace(raw, trace)
+
+	resp := normalizeResponse{Input: raw, Steps: trace.Steps}
+	if ok {
+		resp.Result = &result
+	} else if len(trace.Steps) > 0 {
+		resp.Rejected = strings.TrimPrefix(trace.Steps[len(trace.Steps)-1].Step, "rejected: ")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
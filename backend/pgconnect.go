@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxPingDelay caps the backoff between connection attempts
+const maxPingDelay = 30 * time.Second
+
+// pingWithRetry calls ping until it succeeds or attempts run out, doubling the delay
+// between attempts (starting at initialDelay, capped at maxPingDelay). This lets the
+// service wait out Postgres starting up alongside it instead of crash-looping.
+func pingWithRetry(ping func() error, attempts int, initialDelay time.Duration, sleep func(time.Duration)) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := initialDelay
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = ping(); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		appLog.Warn("PostgreSQL not reachable (attempt %d/%d): %v; retrying in %s", attempt, attempts, err, delay)
+		sleep(delay)
+		delay *= 2
+		if delay > maxPingDelay {
+			delay = maxPingDelay
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPingWithRetryBacksOff(t *testing.T) {
+	calls := 0
+	ping := func() error {
+		calls++
+		if calls < 4 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	var slept []time.Duration
+	sleep := func(d time.Duration) { slept = append(slept, d) }
+
+	if err := pingWithRetry(ping, 5, time.Second, sleep); err != nil {
+		t.Fatalf("pingWithRetry() error: %v", err)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}; !reflect.DeepEqual(slept, want) {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+}
+
+func TestPingWithRetryGivesUp(t *testing.T) {
+	calls := 0
+	ping := func() error {
+		calls++
+		return errors.New("connection refused")
+	}
+	var slept []time.Duration
+	sleep := func(d time.Duration) { slept = append(slept, d) }
+
+	if err := pingWithRetry(ping, 3, 20*time.Second, sleep); err == nil {
+		t.Fatal("pingWithRetry() succeeded, want an error")
+	}
+	if calls != 3 {
+		t.Errorf("ping called %d times, want 3", calls)
+	}
+	if want := []time.Duration{20 * time.Second, maxPingDelay}; !reflect.DeepEqual(slept, want) {
+		t.Errorf("slept %v, want %v (capped)", slept, want)
+	}
+}
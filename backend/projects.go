@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Page sizes for GET /projects
+const (
+	defaultProjectsLimit = 50
+	maxProjectsLimit     = 200
+)
+
+// projectOrderColumns are the columns /projects can be ordered by. The order parameter
+// is interpolated into the query, so it must be one of these.
+var projectOrderColumns = map[string]bool{
+	"approved_at": true,
+	"hours_spent": true,
+	"record_id":   true,
+	"ysws_name":   true,
+}
+
+// projectsQuery is a parsed GET /projects request
+type projectsQuery struct {
+	limit  int
+	offset int
+	order  string // one of projectOrderColumns
+	desc   bool
+
+	country string // upper-case ISO 3166-1 alpha-2 code; empty for every country
+	ysws    string // exact program name; empty for every program
+}
+
+// projectsPage is the body returned by GET /projects
+type projectsPage struct {
+	Projects   []map[string]interface{} `json:"projects"`
+	Total      int                      `json:"total"`
+	Limit      int                      `json:"limit"`
+	Offset     int                      `json:"offset"`
+	NextOffset *int                     `json:"next_offset"`
+}
+
+// parseProjectsQuery reads ?limit=, ?offset=, ?order=, ?country= and ?ysws= from a
+// request. A leading '-' on order sorts descending.
+func parseProjectsQuery(r *http.Request) (projectsQuery, error) {
+	params := r.URL.Query()
+	q := projectsQuery{limit: defaultProjectsLimit, order: "approved_at"}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxProjectsLimit {
+			return projectsQuery{}, fmt.Errorf("limit must be between 1 and %d", maxProjectsLimit)
+		}
+		q.limit = limit
+	}
+
+	if value := params.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return projectsQuery{}, fmt.Errorf("offset must be a non-negative integer")
+		}
+		q.offset = offset
+	}
+
+	if value := params.Get("order"); value != "" {
+		column := strings.TrimPrefix(value, "-")
+		if !projectOrderColumns[column] {
+			return projectsQuery{}, fmt.Errorf("unsupported order %q", value)
+		}
+		q.order = column
+		q.desc = column != value
+	}
+
+	if value := strings.TrimSpace(params.Get("country")); value != "" {
+		if !isCountryCode(value) {
+			return projectsQuery{}, fmt.Errorf("country must be a two-letter ISO country code")
+		}
+		q.country = strings.ToUpper(value)
+	}
+
+	ysws, err := parseYSWSName(params.Get("ysws"))
+	if err != nil {
+		return projectsQuery{}, err
+	}
+	q.ysws = ysws
+
+	return q, nil
+}
+
+// isCountryCode reports whether value looks like an ISO 3166-1 alpha-2 code
+func isCountryCode(value string) bool {
+	if len(value) != 2 {
+		return false
+	}
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// where returns the WHERE clause (possibly empty) and arguments for the query's filters
+func (q projectsQuery) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if q.country != "" {
+		conditions = append(conditions, "UPPER(geocoded_country_code) = ?")
+		args = append(args, q.country)
+	}
+	if q.ysws != "" {
+		conditions = append(conditions, "ysws_name = ?")
+		args = append(args, q.ysws)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// queryProjects returns one page of approved projects. Ties are broken by record_id so
+// pages don't overlap or skip rows.
+func queryProjects(db *sql.DB, q projectsQuery) (projectsPage, error) {
+	page := projectsPage{Projects: []map[string]interface{}{}, Limit: q.limit, Offset: q.offset}
+
+	where, args := q.where()
+	if err := db.QueryRow(`SELECT COUNT(*) FROM approved_projects`+where, args...).Scan(&page.Total); err != nil {
+		return projectsPage{}, fmt.Errorf("counting approved_projects: %w", err)
+	}
+
+	direction := "ASC"
+	if q.desc {
+		direction = "DESC"
+	}
+	rows, err := db.Query(fmt.Sprintf(
+		`SELECT %s FROM approved_projects%s ORDER BY %s %s, record_id LIMIT ? OFFSET ?`,
+		strings.Join(approvedProjectColumns, ", "), where, q.order, direction,
+	), append(args, q.limit, q.offset)...)
+	if err != nil {
+		return projectsPage{}, fmt.Errorf("querying approved_projects: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values := make([]interface{}, len(approvedProjectColumns))
+		dest := make([]interface{}, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return projectsPage{}, fmt.Errorf("reading approved_projects: %w", err)
+		}
+		page.Projects = append(page.Projects, rowObject(approvedProjectColumns, values))
+	}
+	if err := rows.Err(); err != nil {
+		return projectsPage{}, fmt.Errorf("reading approved_projects: %w", err)
+	}
+
+	if next := q.offset + len(page.Projects); next < page.Total {
+		page.NextOffset = &next
+	}
+	return page, nil
+}
+
+// projectsHandler serves a page of approved projects as JSON, read from the cached
+// SQLite database rather than Postgres so browsing stays cheap
+func projectsHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseProjectsQuery(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
+		}
+
+		path, err := ensureDB(cfg)
+		if err != nil {
+			requestLog(r).Error("Failed to prepare database for projects: %v", err)
+			writeGenerationFailure(w, err)
+			return
+		}
+
+		db, release, err := openQueryDB(path)
+		if err != nil {
+			requestLog(r).Error("Failed to open database for projects: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		defer release()
+
+		page, err := queryProjects(db, q)
+		if err != nil {
+			requestLog(r).Error("Failed to query projects: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
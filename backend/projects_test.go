@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// getProjects calls the /projects handler and decodes the page it returns
+func getProjects(t *testing.T, target string) (int, projectsPage) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	projectsHandler(testConfig)(rec, httptest.NewRequest("GET", target, nil))
+
+	var page projectsPage
+	if rec.Code == 200 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decoding %s response: %v", target, err)
+		}
+	}
+	return rec.Code, page
+}
+
+// projectIDs lists the record IDs on a page, in order
+func projectIDs(page projectsPage) []string {
+	ids := make([]string, 0, len(page.Projects))
+	for _, project := range page.Projects {
+		id, _ := project["record_id"].(string)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestProjectsHandlerPaginates(t *testing.T) {
+	withCachedDatabase(t, buildTestDatabase(t,
+		`INSERT INTO approved_projects (record_id, approved_at) VALUES
+			('rec1', '2024-05-01'), ('rec2', '2024-03-10'), ('rec3', '2024-06-15'), ('rec4', '2024-03-10')`,
+	))
+	t.Cleanup(closeQueryDB)
+
+	tests := []struct {
+		target string
+		want   []string
+		next   *int
+	}{
+		{"/projects?limit=2", []string{"rec2", "rec4"}, intPtr(2)},
+		{"/projects?limit=2&offset=2", []string{"rec1", "rec3"}, nil},
+		{"/projects?order=-approved_at&limit=3", []string{"rec3", "rec1", "rec2"}, intPtr(3)},
+		{"/projects?order=record_id&offset=10", []string{}, nil},
+	}
+	for _, tt := range tests {
+		code, page := getProjects(t, tt.target)
+		if code != 200 {
+			t.Fatalf("GET %s status = %d, want 200", tt.target, code)
+		}
+		if got := projectIDs(page); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET %s projects = %v, want %v", tt.target, got, tt.want)
+		}
+		if page.Total != 4 {
+			t.Errorf("GET %s total = %d, want 4", tt.target, page.Total)
+		}
+		if (page.NextOffset == nil) != (tt.next == nil) || (tt.next != nil && *page.NextOffset != *tt.next) {
+			t.Errorf("GET %s next_offset = %v, want %v", tt.target, page.NextOffset, tt.next)
+		}
+	}
+}
+
+func TestProjectsHandlerRejectsBadParameters(t *testing.T) {
+	for _, target := range []string{
+		"/projects?limit=0",
+		"/projects?limit=201",
+		"/projects?offset=-1",
+		"/projects?order=email_hash",
+		"/projects?order=approved_at%3BDROP%20TABLE%20approved_projects",
+	} {
+		if code, _ := getProjects(t, target); code != 400 {
+			t.Errorf("GET %s status = %d, want 400", target, code)
+		}
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestProjectsHandlerFiltersByCountry(t *testing.T) {
+	withCachedDatabase(t, buildTestDatabase(t,
+		`INSERT INTO approved_projects (record_id, geocoded_country_code, ysws_name, approved_at) VALUES
+			('rec1', 'US', 'Daydream', '2024-05-01'),
+			('rec2', 'us', 'Summer of Making', '2024-05-02'),
+			('rec3', 'IN', 'Daydream', '2024-05-03'),
+			('rec4', NULL, 'Daydream', '2024-05-04')`,
+	))
+	t.Cleanup(closeQueryDB)
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/projects?country=US", []string{"rec1", "rec2"}},
+		{"/projects?country=us", []string{"rec1", "rec2"}},
+		{"/projects?country=in", []string{"rec3"}},
+		{"/projects?country=US&ysws=Daydream", []string{"rec1"}},
+		{"/projects?country=FR", []string{}},
+	}
+	for _, tt := range tests {
+		code, page := getProjects(t, tt.target)
+		if code != 200 {
+			t.Fatalf("GET %s status = %d, want 200", tt.target, code)
+		}
+		if got := projectIDs(page); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET %s projects = %v, want %v", tt.target, got, tt.want)
+		}
+		if page.Total != len(tt.want) {
+			t.Errorf("GET %s total = %d, want %d", tt.target, page.Total, len(tt.want))
+		}
+	}
+
+	for _, target := range []string{"/projects?country=USA", "/projects?country=U1", "/projects?country=%C3%9CS"} {
+		if code, _ := getProjects(t, target); code != 400 {
+			t.Errorf("GET %s status = %d, want 400", target, code)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The query endpoints read from a decompressed, read-only copy of the cached database.
+// It's created lazily the first time it's needed and replaced when the cache changes,
+// so deployments that only serve /db never keep a second copy on disk.
+var (
+	queryDBMutex sync.Mutex
+	queryDB      *queryHandle // handle on the current cache entry, nil until needed
+)
+
+// queryHandle is a read-only handle on one decompressed copy. It's counted so that a
+// handle replaced while requests are still using it is closed, and its file removed,
+// only once the last of them releases it.
+type queryHandle struct {
+	db      *sql.DB
+	source  string // compressed cache file the copy was made from
+	file    string // decompressed copy on disk
+	refs    int
+	retired bool
+}
+
+// ensureDB returns the path of a fresh cached database, generating one if needed
+func ensureDB(cfg *Config) (string, error) {
+	if path, ok := getCachedDB(); ok {
+		return path, nil
+	}
+	return regenerate(cfg)
+}
+
+// openQueryDB returns a read-only handle on the decompressed copy of compressedPath and
+// a function the caller must call once it's done with the handle. The handle is shared
+// and must not be closed by callers.
+func openQueryDB(compressedPath string) (*sql.DB, func(), error) {
+	queryDBMutex.Lock()
+	defer queryDBMutex.Unlock()
+
+	if queryDB == nil || queryDB.source != compressedPath {
+		// The cache moved on: drop the copy of the previous database once it's unused
+		retireQueryDBLocked()
+
+		handle, err := newQueryHandle(compressedPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		queryDB = handle
+	}
+
+	handle := queryDB
+	handle.refs++
+	var once sync.Once
+	return handle.db, func() { once.Do(func() { releaseQueryHandle(handle) }) }, nil
+}
+
+// newQueryHandle decompresses compressedPath next to it, under a name of its own so an
+// older handle on the same source can still be reading its copy, and opens it read-only
+func newQueryHandle(compressedPath string) (*queryHandle, error) {
+	temp, err := os.CreateTemp(filepath.Dir(compressedPath), "cached-db-*.db")
+	if err != nil {
+		return nil, fmt.Errorf("creating query database: %w", err)
+	}
+	temp.Close()
+	decompressedPath := temp.Name()
+
+	if err := decompressZstdFile(compressedPath, decompressedPath); err != nil {
+		os.Remove(decompressedPath)
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", "file:"+decompressedPath+"?mode=ro")
+	if err != nil {
+		os.Remove(decompressedPath)
+		return nil, fmt.Errorf("opening query database: %w", err)
+	}
+	return &queryHandle{db: db, source: compressedPath, file: decompressedPath}, nil
+}
+
+// releaseQueryHandle drops one use of handle, closing it if it's been retired and this
+// was the last one
+func releaseQueryHandle(handle *queryHandle) {
+	queryDBMutex.Lock()
+	defer queryDBMutex.Unlock()
+	handle.refs--
+	if handle.retired && handle.refs == 0 {
+		handle.close()
+	}
+}
+
+// close closes the handle, then removes its decompressed file
+func (h *queryHandle) close() {
+	h.db.Close()
+	os.Remove(h.file)
+}
+
+// closeQueryDB retires the shared query handle. It's closed, and its decompressed file
+// removed, as soon as no request is using it.
+func closeQueryDB() {
+	queryDBMutex.Lock()
+	defer queryDBMutex.Unlock()
+	retireQueryDBLocked()
+}
+
+func retireQueryDBLocked() {
+	if queryDB == nil {
+		return
+	}
+	queryDB.retired = true
+	if queryDB.refs == 0 {
+		queryDB.close()
+	}
+	queryDB = nil
+}
+
+// decompressZstdFile writes the decompressed contents of a zstd file to outputPath
+func decompressZstdFile(inputPath, outputPath string) error {
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("opening compressed file: %w", err)
+	}
+	defer input.Close()
+
+	decoder, err := newZstdReader(input)
+	if err != nil {
+		return fmt.Errorf("creating zstd decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("creating decompressed file: %w", err)
+	}
+
+	if _, err := io.Copy(output, decoder); err != nil {
+		output.Close()
+		os.Remove(outputPath)
+		return fmt.Errorf("decompressing: %w", err)
+	}
+	if err := output.Close(); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("closing decompressed file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// compressTestDatabase writes contents to a fresh directory and returns its zstd copy
+func compressTestDatabase(t *testing.T, contents []byte) string {
+	t.Helper()
+	rawPath := filepath.Join(t.TempDir(), "cached-db-1.db")
+	if err := os.WriteFile(rawPath, contents, 0o600); err != nil {
+		t.Fatalf("writing database: %v", err)
+	}
+	compressedPath, err := compressWithZstd(rawPath)
+	if err != nil {
+		t.Fatalf("compressWithZstd() error: %v", err)
+	}
+	return compressedPath
+}
+
+func TestQueryDBStaysOpenUntilReleasedAfterTheCacheMovesOn(t *testing.T) {
+	t.Cleanup(closeQueryDB)
+	first := compressTestDatabase(t, buildTestDatabase(t,
+		`INSERT INTO approved_projects (record_id) VALUES ('rec1')`))
+	second := compressTestDatabase(t, buildTestDatabase(t))
+
+	db, release, err := openQueryDB(first)
+	if err != nil {
+		t.Fatalf("openQueryDB() error: %v", err)
+	}
+	firstFile := queryDB.file
+
+	// A request for the new database retires the old handle while db is still in use
+	_, releaseSecond, err := openQueryDB(second)
+	if err != nil {
+		t.Fatalf("openQueryDB() of the new database error: %v", err)
+	}
+	defer releaseSecond()
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM approved_projects`).Scan(&count); err != nil || count != 1 {
+		t.Fatalf("query on the retired handle = %d, %v; want 1 row", count, err)
+	}
+	if _, err := os.Stat(firstFile); err != nil {
+		t.Fatalf("retired handle's file removed while in use: %v", err)
+	}
+
+	release()
+	release() // releasing twice is harmless
+	if _, err := os.Stat(firstFile); !os.IsNotExist(err) {
+		t.Errorf("retired handle's file still exists after release (%v)", err)
+	}
+	if err := db.Ping(); err == nil {
+		t.Error("retired handle still open after release")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket tracks the remaining request allowance for one client
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// rateLimiter is a token-bucket limiter keyed by API key identity (or client IP when no valid
+// key is presented).
+// Each client may burst up to a full minute's allowance, refilling continuously.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow consumes a token for key, returning false and how long to wait if none is left
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = b
+	}
+
+	// Refill for the time elapsed since the last request
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*l.rate)
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// cleanup drops buckets that have been idle long enough to refill completely,
+// since they're indistinguishable from a new client. Returns how many were removed.
+func (l *rateLimiter) cleanup(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	refillTime := time.Duration(l.burst / l.rate * float64(time.Second))
+	removed := 0
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= refillTime {
+			delete(l.buckets, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// cleanupLoop periodically removes idle buckets so the map doesn't grow without bound
+func (l *rateLimiter) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if removed := l.cleanup(now); removed > 0 {
+			appLog.Debug("Rate limiter: removed %d idle clients", removed)
+		}
+	}
+}
+
+// middleware rejects clients that exceed their allowance with 429 and a Retry-After header.
+// It runs before authMiddleware, so a presented key only gets its own bucket once it's
+// matched against cfg; otherwise a client could send a new made-up key with each request
+// and never run out.
+func (l *rateLimiter) middleware(cfg *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + clientAddr(r)
+		if providedKey, _ := extractAPIKey(r); providedKey != "" {
+			if entry, ok := cfg.matchAPIKey(providedKey); ok {
+				key = "key:" + entry.Name
+			}
+		}
+
+		if ok, wait := l.allow(key, time.Now()); !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			requestLog(r).Warn("Rate limit exceeded, retry after %ds", retryAfter)
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
+			writeJSONError(w, http.StatusTooManyRequests, "Too Many Requests")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
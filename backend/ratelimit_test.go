@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllowsBurstThenLimits(t *testing.T) {
+	l := newRateLimiter(3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("key:a", now); !ok {
+			t.Fatalf("request %d denied within burst", i+1)
+		}
+	}
+	ok, wait := l.allow("key:a", now)
+	if ok {
+		t.Fatal("request beyond burst was allowed")
+	}
+	if wait <= 0 || wait > 20*time.Second {
+		t.Errorf("wait = %s, want about 20s for 3/minute", wait)
+	}
+
+	// Other clients have their own bucket
+	if ok, _ := l.allow("key:b", now); !ok {
+		t.Error("a different key was limited by another key's usage")
+	}
+
+	// One token refills after 20s
+	if ok, _ := l.allow("key:a", now.Add(20*time.Second)); !ok {
+		t.Error("request denied after the bucket refilled")
+	}
+}
+
+func TestRateLimiterCleanupRemovesIdleBuckets(t *testing.T) {
+	l := newRateLimiter(60)
+	now := time.Now()
+	l.allow("key:idle", now)
+	l.allow("key:active", now.Add(50*time.Second))
+
+	if removed := l.cleanup(now.Add(61 * time.Second)); removed != 1 {
+		t.Errorf("cleanup removed %d buckets, want 1", removed)
+	}
+	if _, ok := l.buckets["key:active"]; !ok {
+		t.Error("active bucket was removed")
+	}
+}
+
+func TestRateLimiterMiddlewareReturns429(t *testing.T) {
+	cfg := &Config{APIKeys: []apiKeyEntry{{Name: "client", Key: "client-key"}}}
+	handler := newRateLimiter(1).middleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.Header.Set("X-API-Key", "client-key")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 response is missing Retry-After")
+	}
+}
+
+func TestRateLimiterMiddlewareIgnoresSpoofedForwardedFor(t *testing.T) {
+	withTrustedProxies(t, "")
+	handler := newRateLimiter(1).middleware(&Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// A client rotating X-Forwarded-For values still shares one bucket
+	for i, forwarded := range []string{"198.51.100.1", "198.51.100.2"} {
+		req := httptest.NewRequest("GET", "/db", nil)
+		req.RemoteAddr = "203.0.113.5:4000"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if want := []int{http.StatusOK, http.StatusTooManyRequests}[i]; rec.Code != want {
+			t.Fatalf("request %d status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+}
+
+func TestRateLimiterMiddlewareLimitsRotatingInvalidKeysByIP(t *testing.T) {
+	cfg := &Config{APIKeys: []apiKeyEntry{{Name: "client", Key: "client-key"}}}
+	handler := newRateLimiter(1).middleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Made-up keys share the client's IP bucket rather than getting one each
+	for i, key := range []string{"bogus-1", "bogus-2"} {
+		req := httptest.NewRequest("GET", "/db", nil)
+		req.RemoteAddr = "203.0.113.5:4000"
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if want := []int{http.StatusOK, http.StatusTooManyRequests}[i]; rec.Code != want {
+			t.Fatalf("request %d status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+
+	// A valid key from the same address has its own allowance
+	req := httptest.NewRequest("GET", "/db", nil)
+	req.RemoteAddr = "203.0.113.5:4000"
+	req.Header.Set("X-API-Key", "client-key")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("valid key status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimiterLeavesPublicRoutesAlone(t *testing.T) {
+	limiter := newRateLimiter(1)
+	handler := publicRoutes(testConfig, limiter.middleware(testConfig, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/db", nil))
+		if rec.Code != want {
+			t.Fatalf("/db request %d: status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+
+	// The same client's probes still get answered once its budget is spent
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/healthz after the budget was spent: status = %d, want 200", rec.Code)
+	}
+}
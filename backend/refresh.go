@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// regenerate builds a fresh database; swapped out in tests
+	regenerate = generateDB
+
+	// latencyBudgetEnabled turns on REQUEST_LATENCY_BUDGET: on a cache miss, wait at most
+	// latencyBudget for a refresh before falling back to a stale database no older than maxStale.
+	// STALE_WHILE_REVALIDATE enables it with a zero budget.
+	latencyBudgetEnabled bool
+	latencyBudget        time.Duration
+	maxStale             = time.Hour
+
+	// PREWARM: regenerate prewarmLead before cacheTTL expires, checking every prewarmInterval
+	prewarmLead     = 30 * time.Second
+	prewarmInterval = 10 * time.Second
+
+	// Background refresh state: refreshDone is non-nil while a refresh is running
+	refreshMutex sync.Mutex
+	refreshDone  chan struct{}
+)
+
+// getStaleDB returns the cached database path even if it's past cacheTTL,
+// as long as it's no older than maxAge
+func getStaleDB(maxAge time.Duration) (string, time.Duration, bool) {
+	entry, ok := fullCache.Get()
+	if !ok {
+		return "", 0, false
+	}
+
+	age := entry.age()
+	if age > maxAge {
+		return "", age, false
+	}
+
+	if _, err := os.Stat(entry.path); os.IsNotExist(err) {
+		return "", age, false
+	}
+
+	return entry.path, age, true
+}
+
+// startBackgroundRefresh kicks off a database generation in the background, unless one
+// is already running, and returns a channel that's closed when it finishes
+func startBackgroundRefresh(cfg *Config) <-chan struct{} {
+	refreshMutex.Lock()
+	defer refreshMutex.Unlock()
+
+	if refreshDone != nil {
+		return refreshDone
+	}
+
+	done := make(chan struct{})
+	refreshDone = done
+
+	go func() {
+		start := time.Now()
+		if _, err := regenerate(cfg); err != nil {
+			metrics.observeGenerationFailure()
+			appLog.Error("Background refresh failed: %v", err)
+		} else {
+			appLog.Info("Background refresh completed in %s", time.Since(start))
+		}
+
+		refreshMutex.Lock()
+		refreshDone = nil
+		refreshMutex.Unlock()
+		close(done)
+	}()
+
+	return done
+}
+
+// serveWithinLatencyBudget handles a cache miss when a stale database is available:
+// it starts a background refresh, waits up to latencyBudget for it, and otherwise serves
+// the stale copy. Returns false if there's nothing recent enough to serve, in which case
+// the caller should block on generation.
+func serveWithinLatencyBudget(cfg *Config, w http.ResponseWriter, r *http.Request, format, disposition string, requestStart time.Time) bool {
+	stalePath, age, ok := getStaleDB(maxStale)
+	if !ok {
+		return false
+	}
+
+	done := startBackgroundRefresh(cfg)
+	select {
+	case <-done:
+		if freshPath, ok := getCachedDB(); ok {
+			requestLog(r).Info("Refresh finished within latency budget, serving fresh database")
+			w.Header().Set("X-Cache", "MISS")
+			serveDB(w, r, freshPath, format, disposition, requestStart)
+			return true
+		}
+	case <-time.After(latencyBudget):
+	}
+
+	requestLog(r).Info("Serving stale database (age: %s) while refreshing in the background", age.Round(time.Second))
+	w.Header().Set("X-Cache", "STALE")
+	serveDB(w, r, stalePath, format, disposition, requestStart)
+	return true
+}
+
+// warmCache primes the cache at startup (WARM_ON_START) unless a valid one was restored,
+// so the first /db request doesn't pay for a cold generation. It goes through regenerate,
+// so requests arriving meanwhile share the generation. A failure is only logged: the
+// server keeps running and requests retry the generation on demand.
+func warmCache(cfg *Config) {
+	if _, ok := getCachedDB(); ok {
+		appLog.Info("Cache warm-up skipped: a cached database was restored")
+		return
+	}
+
+	start := time.Now()
+	if _, err := regenerate(cfg); err != nil {
+		metrics.observeGenerationFailure()
+		appLog.Error("Cache warm-up failed, the first request will generate instead: %v", err)
+		return
+	}
+	appLog.Info("Cache warmed in %s", time.Since(start))
+}
+
+// prewarmGenerate is generateDBIfOlderThan; swapped out in tests
+var prewarmGenerate = generateDBIfOlderThan
+
+// prewarmIfDue regenerates the database if it's within prewarmLead of expiring (or there
+// is none), and reports whether it tried to
+func prewarmIfDue(cfg *Config) bool {
+	refreshAt := cacheTTL - prewarmLead
+	if _, _, fresh := getStaleDB(refreshAt); fresh {
+		return false
+	}
+
+	start := time.Now()
+	if _, err := prewarmGenerate(cfg, refreshAt); err != nil {
+		metrics.observeGenerationFailure()
+		appLog.Error("Proactive refresh failed: %v", err)
+	} else {
+		appLog.Info("Proactive refresh completed in %s", time.Since(start))
+	}
+	return true
+}
+
+// prewarmLoop keeps the cache warm by regenerating shortly before it expires, so
+// requests almost never pay for a generation. It shares generationMutex and the
+// double-check with request-triggered generation, so the two never run concurrently.
+// It runs until stop is closed; the server passes nil and never stops it.
+func prewarmLoop(cfg *Config, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		prewarmIfDue(cfg)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
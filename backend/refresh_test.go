@@ -0,0 +1,205 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withCacheEntry installs entry as the cached database and restores the previous
+// cache state afterwards
+func withCacheEntry(t *testing.T, entry cacheEntry) {
+	t.Helper()
+	fullCache.mu.Lock()
+	prevEntry, prevReady := fullCache.entry, fullCache.ready
+	fullCache.entry = entry
+	fullCache.mu.Unlock()
+
+	t.Cleanup(func() {
+		fullCache.mu.Lock()
+		fullCache.entry, fullCache.ready = prevEntry, prevReady
+		fullCache.mu.Unlock()
+	})
+}
+
+// withCachedFile installs a file with the given contents as the cached database,
+// created at the given age, and restores the previous cache state afterwards
+func withCachedFile(t *testing.T, contents string, age time.Duration) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cached-db-test.db.zst")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("writing cached file: %v", err)
+	}
+
+	withCacheEntry(t, cacheEntry{path: path, createdAt: time.Now().Add(-age)})
+	return path
+}
+
+func TestLatencyBudgetServesStaleWithoutBlocking(t *testing.T) {
+	withCachedFile(t, "stale-database", cacheTTL+time.Minute)
+
+	prevRegenerate, prevEnabled, prevBudget, prevMaxStale := regenerate, latencyBudgetEnabled, latencyBudget, maxStale
+	t.Cleanup(func() {
+		regenerate, latencyBudgetEnabled, latencyBudget, maxStale = prevRegenerate, prevEnabled, prevBudget, prevMaxStale
+	})
+
+	// A generation that doesn't finish until the test says so
+	release := make(chan struct{})
+	regenerate = func(*Config) (string, error) {
+		<-release
+		return "", os.ErrNotExist
+	}
+	latencyBudgetEnabled = true
+	latencyBudget = 20 * time.Millisecond
+	maxStale = time.Hour
+
+	rec := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		handleDBDownload(testConfig, rec, httptest.NewRequest("GET", "/db", nil), formatZstd, `attachment; filename="database.db.zst"`, time.Now())
+		close(served)
+	}()
+
+	select {
+	case <-served:
+	case <-time.After(2 * time.Second):
+		close(release)
+		t.Fatal("handleDBDownload blocked on generation instead of serving stale data")
+	}
+
+	if body := rec.Body.String(); body != "stale-database" {
+		t.Errorf("body = %q, want stale database contents", body)
+	}
+	if got := rec.Header().Get("X-Cache"); got != "STALE" {
+		t.Errorf("X-Cache = %q, want STALE", got)
+	}
+
+	// Let the background refresh finish so it doesn't leak into other tests
+	refreshMutex.Lock()
+	done := refreshDone
+	refreshMutex.Unlock()
+	close(release)
+	if done != nil {
+		<-done
+	}
+}
+
+func TestGetStaleDBRespectsMaxStale(t *testing.T) {
+	withCachedFile(t, "old", 2*time.Hour)
+
+	if _, _, ok := getStaleDB(time.Hour); ok {
+		t.Error("getStaleDB() returned a database older than the stale bound")
+	}
+	if _, _, ok := getStaleDB(3 * time.Hour); !ok {
+		t.Error("getStaleDB() rejected a database within the stale bound")
+	}
+}
+
+func TestFreshCacheReportsHit(t *testing.T) {
+	withCachedFile(t, "fresh-database", time.Minute)
+
+	rec := httptest.NewRecorder()
+	handleDBDownload(testConfig, rec, httptest.NewRequest("GET", "/db", nil), formatZstd, `attachment; filename="database.db.zst"`, time.Now())
+
+	if got := rec.Header().Get("X-Cache"); got != "HIT" {
+		t.Errorf("X-Cache = %q, want HIT", got)
+	}
+	if body := rec.Body.String(); body != "fresh-database" {
+		t.Errorf("body = %q, want cached contents", body)
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	prevRegenerate := regenerate
+	t.Cleanup(func() { regenerate = prevRegenerate })
+	calls := 0
+	regenerate = func(*Config) (string, error) {
+		calls++
+		return "", os.ErrNotExist
+	}
+
+	// A restored cache is left alone
+	withCachedFile(t, "restored", time.Minute)
+	warmCache(testConfig)
+	if calls != 0 {
+		t.Errorf("warmCache(testConfig) generated %d times with a valid cache, want 0", calls)
+	}
+
+	// A cold start generates once, and a failure doesn't stop anything
+	withCacheEntry(t, cacheEntry{})
+	warmCache(testConfig)
+	if calls != 1 {
+		t.Errorf("warmCache(testConfig) generated %d times on a cold start, want 1", calls)
+	}
+}
+
+// withPrewarmGenerate replaces the prewarmer's generation with generate until the test ends
+func withPrewarmGenerate(t *testing.T, generate func(*Config, time.Duration) (string, error)) {
+	t.Helper()
+	prev := prewarmGenerate
+	prewarmGenerate = generate
+	t.Cleanup(func() { prewarmGenerate = prev })
+}
+
+func TestPrewarmIfDue(t *testing.T) {
+	var calls []time.Duration
+	withPrewarmGenerate(t, func(_ *Config, maxAge time.Duration) (string, error) {
+		calls = append(calls, maxAge)
+		return "", nil
+	})
+
+	// Well before expiry there's nothing to do
+	withCachedFile(t, "fresh", time.Second)
+	if prewarmIfDue(testConfig) || len(calls) != 0 {
+		t.Fatalf("prewarmed a fresh database (%d generations)", len(calls))
+	}
+
+	// Within prewarmLead of expiry it regenerates anything older than that
+	withCachedFile(t, "expiring", cacheTTL-prewarmLead+time.Second)
+	if !prewarmIfDue(testConfig) || len(calls) != 1 || calls[0] != cacheTTL-prewarmLead {
+		t.Fatalf("prewarmIfDue() generations = %v, want one with maxAge %s", calls, cacheTTL-prewarmLead)
+	}
+
+	// Without any database it generates one
+	withCacheEntry(t, cacheEntry{})
+	if !prewarmIfDue(testConfig) || len(calls) != 2 {
+		t.Errorf("prewarmIfDue() without a database made %d generations in all, want 2", len(calls))
+	}
+}
+
+func TestPrewarmLoopRefreshesUntilStopped(t *testing.T) {
+	withCachedFile(t, "expiring", cacheTTL)
+	generated := make(chan struct{}, 1)
+	withPrewarmGenerate(t, func(*Config, time.Duration) (string, error) {
+		select {
+		case generated <- struct{}{}:
+		default:
+		}
+		return "", os.ErrNotExist
+	})
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		prewarmLoop(testConfig, time.Millisecond, stop)
+		close(stopped)
+	}()
+
+	// A failed refresh is retried on the next tick
+	for i := 0; i < 2; i++ {
+		select {
+		case <-generated:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("prewarmLoop made %d refreshes of an expiring database, want 2", i)
+		}
+	}
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("prewarmLoop didn't return after stop was closed")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// routeSpec declares which methods a route accepts and how large a request body it
+// reads. Handlers behind wrap can assume both have already been checked.
+type routeSpec struct {
+	methods      []string
+	maxBodyBytes int64 // 0 for routes that never read the body
+}
+
+// readRoute is the spec for the GET endpoints, which never read a body
+var readRoute = routeSpec{methods: []string{http.MethodGet, http.MethodHead}}
+
+// publicRoutes serves the routes that bypass API key authentication (probes, metrics and
+// the well-known files) and hands everything else to protected
+func publicRoutes(cfg *Config, protected http.Handler) *http.ServeMux {
+	root := http.NewServeMux()
+	root.Handle("/metrics", readRoute.wrap(metricsHandler(cfg)))
+	root.Handle("/healthz", readRoute.wrap(http.HandlerFunc(healthzHandler)))
+	root.Handle("/ready", readRoute.wrap(http.HandlerFunc(readyHandler)))
+	root.Handle("/version", readRoute.wrap(http.HandlerFunc(versionHandler)))
+	root.Handle("/robots.txt", readRoute.wrap(http.HandlerFunc(robotsHandler)))
+	root.Handle("/favicon.ico", readRoute.wrap(http.HandlerFunc(faviconHandler)))
+	root.Handle("/.well-known/security.txt", readRoute.wrap(http.HandlerFunc(securityTxtHandler)))
+	root.Handle("/", protected)
+	return root
+}
+
+// allows reports whether the spec accepts method
+func (s routeSpec) allows(method string) bool {
+	for _, m := range s.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap returns next guarded by the spec: other methods get 405 with an Allow header,
+// bodies declared larger than maxBodyBytes get 413, and reading past it fails
+func (s routeSpec) wrap(next http.Handler) http.Handler {
+	allow := strings.Join(s.methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allows(r.Method) {
+			w.Header().Set("Allow", allow)
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+			return
+		}
+		if r.ContentLength > s.maxBodyBytes {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request Entity Too Large: at most %d bytes", s.maxBodyBytes))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
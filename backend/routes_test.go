@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouteSpecRejectsOtherMethods(t *testing.T) {
+	handler := readRoute.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for method, want := range map[string]int{"GET": 200, "HEAD": 200, "POST": 405, "DELETE": 405} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/db", nil))
+		if rec.Code != want {
+			t.Errorf("%s status = %d, want %d", method, rec.Code, want)
+		}
+		if want == 405 && rec.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s Allow = %q, want \"GET, HEAD\"", method, rec.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestRouteSpecBoundsBody(t *testing.T) {
+	spec := routeSpec{methods: []string{http.MethodPost}, maxBodyBytes: 8}
+	var readErr error
+	handler := spec.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/lookup", strings.NewReader("too long a body")))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("declared oversized body: status = %d, want 413", rec.Code)
+	}
+
+	// Without a declared length, reading past the limit fails instead
+	req := httptest.NewRequest("POST", "/lookup", strings.NewReader("too long a body"))
+	req.ContentLength = -1
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if readErr == nil {
+		t.Error("reading an undeclared oversized body succeeded")
+	}
+
+	readErr = nil
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/lookup", strings.NewReader("short")))
+	if readErr != nil {
+		t.Errorf("reading a body within the limit failed: %v", readErr)
+	}
+}
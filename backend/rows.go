@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The shipped row shapes, shared by the SQLite copy and the exports so every format has the
+// same fields and transforms. Columns come from the table definitions in SQLite order; the
+// scan functions return values in that order with URLs normalized and emails replaced by
+// their hash.
+var (
+	approvedProjectColumns = approvedProjectsTable.columnNames()
+	projectMentionColumns  = projectMentionsTable.columnNames()
+
+	approvedProjectIndex = columnIndex(approvedProjectColumns)
+	projectMentionIndex  = columnIndex(projectMentionColumns)
+)
+
+// includeEmailDomain (INCLUDE_EMAIL_DOMAIN=true) fills email_domain with the part of each
+// email after the @, for segmenting projects by school or provider. The column always
+// exists so the schema doesn't depend on configuration; it's NULL when this is off.
+var includeEmailDomain bool
+
+// pgSchema is the Postgres schema holding the synced Airtable tables (PG_SCHEMA).
+// It's interpolated into queries, so it must pass validatePGSchema.
+var pgSchema = "airtable_unified_ysws_projects_db"
+
+// pgIdentifierPattern matches unquoted Postgres identifiers (at most 63 bytes)
+var pgIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// validatePGSchema rejects schema names that aren't plain identifiers, so interpolating
+// one into a query can't change its meaning
+func validatePGSchema(name string) error {
+	if !pgIdentifierPattern.MatchString(name) {
+		return fmt.Errorf("%q is not a valid schema name (letters, digits, and underscores, not starting with a digit)", name)
+	}
+	return nil
+}
+
+// inSchema fills the {schema} placeholders in a query with pgSchema
+func inSchema(query string) string {
+	return strings.ReplaceAll(query, "{schema}", pgSchema)
+}
+
+// approvedProjectsQuery selects approved projects with their YSWS name from the child table.
+// Conditions can be appended with a pgFilter; run it through inSchema.
+const approvedProjectsQuery = `
+		SELECT 
+			ap.record_id,
+			ap.first_name,
+			ap.last_name,
+			ap.git_hub_username,
+			ap.geocoded_country,
+			ap.geocoded_country_code,
+			ap.playable_url,
+			ap.code_url,
+			ap.hours_spent,
+			ap.approved_at,
+			ap.override_hours_spent_justification,
+			ap.age_when_approved,
+			ysws_name.value as ysws_name,
+			ap.email
+		FROM {schema}.approved_projects ap
+		LEFT JOIN {schema}.approved_projects__ysws_name ysws_name
+			ON ap._dlt_id = ysws_name._dlt_parent_id
+			AND ysws_name._dlt_list_idx = 0
+	`
+
+// projectMentionsQuery selects project mentions. Conditions can be appended with a pgFilter;
+// run it through inSchema.
+const projectMentionsQuery = `
+		SELECT 
+			id,
+			ysws_project_mentions_id,
+			ysws_project_mention_searches,
+			ysws_from_ysws_approved_project,
+			record_id,
+			ysws_approved_project,
+			source,
+			link_found_at,
+			archive_url,
+			url,
+			headline,
+			date,
+			weighted_engagement_points,
+			project_url,
+			engagement_count,
+			engagement_type,
+			mentions_hack_club,
+			published_by_hack_club
+		FROM {schema}.ysws_project_mentions
+	`
+
+// scanApprovedProject scans a row of approvedProjectsQuery into shipped values, hashing
+// the email with c
+func (c *Config) scanApprovedProject(rows *sql.Rows) ([]interface{}, error) {
+	var recordID, firstName, lastName, gitHubUsername, geocodedCountry sql.NullString
+	var geocodedCountryCode, playableURL, codeURL sql.NullString
+	var hoursSpent sql.NullFloat64
+	var approvedAt, overrideHoursJustification sql.NullString
+	var ageWhenApproved sql.NullInt64
+	var yswsName sql.NullString
+	var email sql.NullString
+
+	err := rows.Scan(
+		&recordID, &firstName, &lastName, &gitHubUsername, &geocodedCountry,
+		&geocodedCountryCode, &playableURL, &codeURL,
+		&hoursSpent, &approvedAt, &overrideHoursJustification, &ageWhenApproved,
+		&yswsName, &email,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("scanning row: %w", err)
+	}
+
+	// Hash the email if present
+	var emailHash, domain interface{}
+	if email.Valid && email.String != "" {
+		emailHash = c.hashEmail(email.String)
+		if includeEmailDomain {
+			domain = emailDomain(email.String)
+		}
+	}
+
+	return []interface{}{
+		nullStringToPtr(recordID), nullStringToPtr(firstName),
+		nullStringToPtr(lastName), nullStringToPtr(gitHubUsername), nullStringToPtr(geocodedCountry),
+		nullStringToPtr(geocodedCountryCode),
+		normalizeURL(playableURL), normalizeURL(codeURL),
+		nullFloat64ToPtr(hoursSpent), nullStringToPtr(approvedAt),
+		nullStringToPtr(overrideHoursJustification), nullInt64ToPtr(ageWhenApproved),
+		nullStringToPtr(yswsName), emailHash, domain,
+	}, nil
+}
+
+// emailDomain returns the lowercased part of an email after its last @, or nil when the
+// address is malformed (no @, or nothing after it)
+func emailDomain(email string) interface{} {
+	email = normalizeEmail(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 || at == len(email)-1 {
+		return nil
+	}
+	return email[at+1:]
+}
+
+// scanProjectMention scans a row of projectMentionsQuery into shipped values
+func scanProjectMention(rows *sql.Rows) ([]interface{}, error) {
+	var id, mentionsID, mentionSearches, fromApproved sql.NullString
+	var recordID, yswsApproved, source, linkFoundAt sql.NullString
+	var archiveURL, url, headline, date sql.NullString
+	var weightedEngagement sql.NullFloat64
+	var projectURL, engagementType sql.NullString
+	var engagementCount sql.NullInt64
+	var mentionsHackClub, publishedByHackClub sql.NullBool
+
+	err := rows.Scan(
+		&id, &mentionsID, &mentionSearches, &fromApproved,
+		&recordID, &yswsApproved, &source, &linkFoundAt,
+		&archiveURL, &url, &headline, &date,
+		&weightedEngagement, &projectURL, &engagementCount,
+		&engagementType, &mentionsHackClub, &publishedByHackClub,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("scanning row: %w", err)
+	}
+
+	return []interface{}{
+		nullStringToPtr(id), nullStringToPtr(mentionsID),
+		nullStringToPtr(mentionSearches), nullStringToPtr(fromApproved),
+		nullStringToPtr(recordID), nullStringToPtr(yswsApproved),
+		nullStringToPtr(source), nullStringToPtr(linkFoundAt),
+		normalizeURL(archiveURL), normalizeURL(url),
+		nullStringToPtr(headline), nullStringToPtr(date),
+		nullFloat64ToPtr(weightedEngagement), normalizeURL(projectURL),
+		nullInt64ToPtr(engagementCount), nullStringToPtr(engagementType),
+		nullBoolToInt(mentionsHackClub), nullBoolToInt(publishedByHackClub),
+	}, nil
+}
+
+// insertStatement builds an INSERT (or INSERT OR REPLACE) for the given columns
+func insertStatement(verb, table string, columns []string) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	return fmt.Sprintf("%s INTO %s (%s) VALUES (%s)", verb, table, strings.Join(columns, ", "), placeholders)
+}
+
+func columnIndex(columns []string) map[string]int {
+	index := make(map[string]int, len(columns))
+	for i, column := range columns {
+		index[column] = i
+	}
+	return index
+}
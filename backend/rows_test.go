@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePGSchema(t *testing.T) {
+	for _, name := range []string{"airtable_unified_ysws_projects_db", "staging", "_tmp2"} {
+		if err := validatePGSchema(name); err != nil {
+			t.Errorf("validatePGSchema(%q) error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "2fast", "public.x", "a b", `x"; DROP TABLE y; --`, strings.Repeat("a", 64)} {
+		if err := validatePGSchema(name); err == nil {
+			t.Errorf("validatePGSchema(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestInSchema(t *testing.T) {
+	prev := pgSchema
+	t.Cleanup(func() { pgSchema = prev })
+	pgSchema = "staging"
+
+	query := inSchema(approvedProjectsQuery)
+	if strings.Contains(query, "{schema}") || !strings.Contains(query, "FROM staging.approved_projects ap") {
+		t.Errorf("inSchema() left placeholders or used the wrong schema:\n%s", query)
+	}
+}
+
+func TestEmailDomain(t *testing.T) {
+	tests := []struct {
+		email string
+		want  interface{}
+	}{
+		{"ada@example.com", "example.com"},
+		{" Ada@Student.Example.EDU ", "student.example.edu"},
+		{`"odd@name"@example.org`, "example.org"},
+		{"no-at-sign", nil},
+		{"trailing@", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := emailDomain(tt.email); got != tt.want {
+			t.Errorf("emailDomain(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// schemaVersion identifies the structure of the generated SQLite database.
+// Bump it together with expectedSchemaHash whenever sqliteTables changes.
+// Version 2 added the full-text search tables (mentions_fts, projects_fts).
+// Version 3 added schema_meta and PRAGMA user_version.
+// Version 4 declared ysws_project_mentions.ysws_approved_project as a foreign key.
+// Version 5 added project_engagement_summary.
+// Version 6 added approved_projects.email_domain.
+const schemaVersion = 6
+
+// expectedSchemaHash is the SHA-256 of the normalized DDL produced by createSQLiteTables.
+// If generation reports a mismatch, the schema was edited without updating this value
+// (and schemaVersion); the new hash is printed in the error to copy here.
+const expectedSchemaHash = "04b85979f9817d12e8a9ec33be1a1cf77778395495cd1654d69cf08820bd69b3"
+
+// stampSchemaVersion sets PRAGMA user_version, so clients can check the schema version
+// of a downloaded database before querying it. schema_meta holds it too.
+func stampSchemaVersion(db *sql.DB) error {
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion)); err != nil {
+		return fmt.Errorf("setting user_version: %w", err)
+	}
+	return nil
+}
+
+// writeSchemaMeta records the schema version and when this database was generated.
+// It runs on every generation, including incremental ones that reuse the tables.
+func writeSchemaMeta(db *sql.DB, generatedAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("writing schema_meta: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM schema_meta`); err != nil {
+		return fmt.Errorf("writing schema_meta: %w", err)
+	}
+	_, err = tx.Exec(schemaMetaTable.insertStatement("INSERT"),
+		schemaVersion, generatedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("writing schema_meta: %w", err)
+	}
+	return tx.Commit()
+}
+
+// schemaMismatchFatal controls whether a schema hash mismatch fails generation (default)
+// or only logs a warning (SCHEMA_HASH_MISMATCH=warn)
+var schemaMismatchFatal = true
+
+// schemaHash computes a SHA-256 over the DDL of every table and index in the database.
+// Whitespace is collapsed so reformatting the CREATE statements doesn't count as drift.
+// SQLite's internal tables (like sqlite_stat1 from ANALYZE) are left out, as are the
+// full-text search tables, which only exist when the SQLite build supports FTS5.
+func schemaHash(db *sql.DB) (string, error) {
+	rows, err := db.Query(`
+		SELECT type, name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL
+			AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+			AND tbl_name NOT IN ('mentions_fts', 'projects_fts')
+			AND name NOT LIKE 'mentions\_fts\_%' ESCAPE '\'
+			AND name NOT LIKE 'projects\_fts\_%' ESCAPE '\'
+		ORDER BY type, name
+	`)
+	if err != nil {
+		return "", fmt.Errorf("querying sqlite_master: %w", err)
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	for rows.Next() {
+		var objType, name, ddl string
+		if err := rows.Scan(&objType, &name, &ddl); err != nil {
+			return "", fmt.Errorf("scanning sqlite_master: %w", err)
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\n", objType, name, strings.Join(strings.Fields(ddl), " "))
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("reading sqlite_master: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifySchema checks the generated schema against expectedSchemaHash and returns the actual hash.
+// A mismatch is an error unless schemaMismatchFatal is disabled, in which case it's only logged.
+func verifySchema(db *sql.DB) (string, error) {
+	actual, err := schemaHash(db)
+	if err != nil {
+		return "", err
+	}
+
+	if actual != expectedSchemaHash {
+		if schemaMismatchFatal {
+			return actual, fmt.Errorf("schema hash mismatch: got %s, expected %s (update expectedSchemaHash and bump schemaVersion)", actual, expectedSchemaHash)
+		}
+		appLog.Warn("⚠️  SCHEMA HASH MISMATCH: got %s, expected %s — update expectedSchemaHash and bump schemaVersion", actual, expectedSchemaHash)
+	}
+
+	return actual, nil
+}
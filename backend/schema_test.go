@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// openTestSQLite opens an in-memory SQLite database limited to one connection,
+// since every new connection to ":memory:" would otherwise get its own empty database
+func openTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening in-memory SQLite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSchemaHashMatchesExpected(t *testing.T) {
+	db := openTestSQLite(t)
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+
+	hash, err := verifySchema(db)
+	if err != nil {
+		t.Fatalf("verifySchema() error: %v", err)
+	}
+	if hash != expectedSchemaHash {
+		t.Errorf("schema hash = %s, want %s", hash, expectedSchemaHash)
+	}
+}
+
+func TestSchemaHashDetectsDrift(t *testing.T) {
+	db := openTestSQLite(t)
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+
+	// Simulate someone adding a column without updating the expected hash
+	if _, err := db.Exec(`ALTER TABLE approved_projects ADD COLUMN favorite_color TEXT`); err != nil {
+		t.Fatalf("altering table: %v", err)
+	}
+
+	hash, err := verifySchema(db)
+	if err == nil {
+		t.Fatal("verifySchema() expected a mismatch error after a schema change")
+	}
+	if hash == expectedSchemaHash {
+		t.Errorf("schema hash unchanged after altering the table")
+	}
+}
+
+func TestSchemaMetaRecordsVersion(t *testing.T) {
+	db := openTestSQLite(t)
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+
+	generatedAt := time.Date(2024, 6, 16, 10, 0, 0, 0, time.UTC)
+	// Written twice, as an incremental generation would; only the latest row is kept
+	for _, at := range []time.Time{generatedAt.Add(-time.Hour), generatedAt} {
+		if err := writeSchemaMeta(db, at); err != nil {
+			t.Fatalf("writeSchemaMeta() error: %v", err)
+		}
+	}
+
+	var version, rows int
+	var at string
+	if err := db.QueryRow(`SELECT schema_version, generated_at, (SELECT COUNT(*) FROM schema_meta) FROM schema_meta`).Scan(&version, &at, &rows); err != nil {
+		t.Fatalf("reading schema_meta: %v", err)
+	}
+	if version != schemaVersion || at != "2024-06-16T10:00:00Z" || rows != 1 {
+		t.Errorf("schema_meta = (%d, %q) in %d rows, want (%d, 2024-06-16T10:00:00Z) in 1 row", version, at, rows, schemaVersion)
+	}
+
+	var userVersion int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&userVersion); err != nil {
+		t.Fatalf("reading user_version: %v", err)
+	}
+	if userVersion != schemaVersion {
+		t.Errorf("user_version = %d, want %d", userVersion, schemaVersion)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// buildDSN opens a database that's being generated with build-time pragmas, applied to
+// every pooled connection:
+//   - journal_mode=WAL lets the bulk inserts append to the log instead of rewriting pages.
+//     The journal mode is stored in the file, so finalizeSQLite switches it back to DELETE;
+//     a shipped WAL database would need -wal/-shm files and write access to open.
+//   - synchronous=OFF skips fsyncs. It's per connection and never stored in the file; a
+//     crash mid-build only loses a temp file we'd throw away anyway.
+//   - foreign_keys=OFF (SQLite's default, spelled out) leaves the declared foreign keys
+//     unenforced while building: mentions may reference projects that aren't approved,
+//     and incremental merges replace project rows that mentions point at. Orphans are
+//     counted by countOrphanMentions instead. Clients can turn enforcement on themselves.
+func buildDSN(path string) string {
+	return "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=synchronous(OFF)&_pragma=foreign_keys(OFF)"
+}
+
+// countOrphanMentions counts mentions whose ysws_approved_project doesn't match any
+// approved project, i.e. rows that would fail PRAGMA foreign_key_check
+func countOrphanMentions(db *sql.DB) (int, error) {
+	var orphans int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM ysws_project_mentions m
+		WHERE m.ysws_approved_project IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM approved_projects p WHERE p.record_id = m.ysws_approved_project)
+	`).Scan(&orphans)
+	if err != nil {
+		return 0, fmt.Errorf("counting orphaned mentions: %w", err)
+	}
+	return orphans, nil
+}
+
+// finalizeSQLite prepares a generated database for shipping. What persists in the file:
+//   - ANALYZE writes sqlite_stat1, so downstream query planners pick the right indexes
+//   - VACUUM rebuilds the file without free pages, so it's as small as it can be
+//   - journal_mode=DELETE replaces the build-time WAL mode
+//
+// PRAGMA optimize runs alongside ANALYZE as SQLite recommends before closing.
+func finalizeSQLite(db *sql.DB) error {
+	steps := []struct {
+		name string
+		stmt string
+	}{
+		{"analyzing", `ANALYZE`},
+		{"optimizing", `PRAGMA optimize`},
+		{"vacuuming", `VACUUM`},
+		{"leaving WAL mode", `PRAGMA journal_mode=DELETE`},
+	}
+	for _, step := range steps {
+		if _, err := db.Exec(step.stmt); err != nil {
+			return fmt.Errorf("%s SQLite database: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+// sqliteBuild describes a database built by buildSQLite
+type sqliteBuild struct {
+	projectCount int
+	mentionCount int
+	schemaHash   string
+	// dataModifiedAt is the newest approved_at or mention date in the database, or zero
+	// if none parse; it stays the same when a regeneration finds no new data
+	dataModifiedAt time.Time
+}
+
+// buildSQLite copies PostgreSQL (read through pg, hashing emails with cfg) into db and
+// finalizes it. Without since, db must be empty; with it, db already holds the previous
+// database and only newer rows are copied. The parallel copies write side databases at
+// sideBase.<table>. buildSQLite reads no cache state, so it can run against any
+// database, in-memory ones included.
+func buildSQLite(pg Querier, cfg *Config, db *sql.DB, sideBase string, since *watermarks) (sqliteBuild, error) {
+	// Create tables in SQLite (an incremental base already has them)
+	if since == nil {
+		appLog.Debug("Creating SQLite tables...")
+		tableStart := time.Now()
+		if err := createSQLiteTables(db); err != nil {
+			return sqliteBuild{}, fmt.Errorf("failed to create tables: %w", err)
+		}
+		appLog.Debug("Tables created in %s", time.Since(tableStart))
+	}
+
+	// Catch schema drift before spending time on the copy
+	schemaHash, err := verifySchema(db)
+	if err != nil {
+		return sqliteBuild{}, fmt.Errorf("failed to verify schema: %w", err)
+	}
+
+	// Copy data from PostgreSQL to SQLite, both tables at once
+	appLog.Info("Copying approved_projects and ysws_project_mentions from PostgreSQL...")
+	copyStart := time.Now()
+	ctx, cancel := generationContext()
+	defer cancel()
+	copied, err := copyTables(ctx, pg, db, sideBase, copyScope{cfg: cfg, since: since}, tableCopies)
+	if err != nil {
+		return sqliteBuild{}, generationError(ctx, err)
+	}
+	projectCount, mentionCount := copied["approved_projects"], copied["ysws_project_mentions"]
+	appLog.Info("Copied %d approved_projects and %d ysws_project_mentions in %s", projectCount, mentionCount, time.Since(copyStart))
+
+	if dedupMentionsEnabled {
+		collapsed, err := dedupMentions(db)
+		if err != nil {
+			return sqliteBuild{}, err
+		}
+		mentionCount -= collapsed
+		appLog.Info("Collapsed %d duplicate ysws_project_mentions (same project and normalized URL)", collapsed)
+	}
+
+	// Incremental copies only report new rows; the rest of generation wants table totals
+	if since != nil {
+		if projectCount, err = countRows(db, "approved_projects"); err == nil {
+			mentionCount, err = countRows(db, "ysws_project_mentions")
+		}
+		if err != nil {
+			return sqliteBuild{}, err
+		}
+	}
+
+	// The foreign key isn't enforced during the build, so report what doesn't satisfy it
+	if orphans, err := countOrphanMentions(db); err != nil {
+		appLog.Warn("%v", err)
+	} else if orphans > 0 {
+		appLog.Info("%d ysws_project_mentions reference a project missing from approved_projects", orphans)
+	}
+
+	if err := buildEngagementSummary(db); err != nil {
+		return sqliteBuild{}, err
+	}
+
+	newest, err := readWatermarks(db)
+	if err != nil {
+		return sqliteBuild{}, err
+	}
+
+	if err := writeSchemaMeta(db, time.Now()); err != nil {
+		return sqliteBuild{}, err
+	}
+
+	// Compact the file and record planner statistics before shipping it
+	finalizeStart := time.Now()
+	if err := finalizeSQLite(db); err != nil {
+		return sqliteBuild{}, err
+	}
+	appLog.Debug("Finalized SQLite database in %s", time.Since(finalizeStart))
+
+	return sqliteBuild{
+		projectCount:   projectCount,
+		mentionCount:   mentionCount,
+		schemaHash:     schemaHash,
+		dataModifiedAt: newest.latest(),
+	}, nil
+}
+
+// buildSQLiteFile runs buildSQLite on the database file at path. On error the caller
+// removes the file.
+func buildSQLiteFile(cfg *Config, path string, since *watermarks) (sqliteBuild, error) {
+	db, err := sql.Open("sqlite", buildDSN(path))
+	if err != nil {
+		return sqliteBuild{}, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	defer db.Close()
+
+	built, err := buildSQLite(pgDB, cfg, db, path, since)
+	if err != nil {
+		return sqliteBuild{}, err
+	}
+
+	// Close SQLite to flush all data
+	if err := db.Close(); err != nil {
+		return sqliteBuild{}, fmt.Errorf("failed to close SQLite database: %w", err)
+	}
+	return built, nil
+}
+
+// buildAndCompress builds a database in scratchDir, compresses it with zstd into dir, and
+// verifies the result, returning an entry describing the compressed file for the caller
+// to cache. incrementalFrom, if it has a path, is a previous compressed database to start from; if
+// it can't be used the build falls back to a full one. streamTo is as for
+// generateDBStreaming. Builds with fewer than minRows rows in either table fail. Nothing
+// is left in either directory on error.
+func buildAndCompress(cfg *Config, scratchDir, dir string, incrementalFrom cacheEntry, streamTo func() io.Writer) (cacheEntry, error) {
+	appLog.Debug("Creating SQLite database file...")
+	tmpFile, err := os.CreateTemp(scratchDir, "cached-db-*.db")
+	if err != nil {
+		return cacheEntry{}, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	var since *watermarks
+	if incrementalFrom.path != "" {
+		since, err = prepareIncremental(cfg, incrementalFrom, tmpPath)
+		if err != nil {
+			appLog.Info("Incremental generation unavailable (%v), doing a full generation", err)
+			since = nil
+			if err := os.Truncate(tmpPath, 0); err != nil {
+				return cacheEntry{}, fmt.Errorf("failed to reset temp file: %w", err)
+			}
+		} else {
+			appLog.Info("Incremental generation from approved_at >= %q, mention link_found_at >= %q", since.approvedAt, since.mentionFoundAt)
+		}
+	}
+
+	built, err := buildSQLiteFile(cfg, tmpPath, since)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+
+	// Keep the previous database rather than replacing it with an empty one
+	if err := checkMinRows(built); err != nil {
+		appLog.Warn("Not caching the generated database, is PG_SCHEMA right? %v", err)
+		return cacheEntry{}, err
+	}
+
+	// Get uncompressed file size
+	var uncompressedSize int64
+	if fileInfo, err := os.Stat(tmpPath); err == nil {
+		uncompressedSize = fileInfo.Size()
+		appLog.Info("SQLite database size (uncompressed): %.2f MB, total rows: %d", float64(uncompressedSize)/(1024*1024), built.projectCount+built.mentionCount)
+	}
+
+	// Compress the database with zstd
+	appLog.Info("Compressing database with zstd (level %s)...", zstdLevel)
+	compressStart := time.Now()
+	var stream io.Writer
+	if streamTo != nil {
+		stream = streamTo()
+	}
+	compressedPath, err := compressWithZstdTo(tmpPath, dir, stream)
+	if err != nil {
+		return cacheEntry{}, fmt.Errorf("failed to compress database: %w", err)
+	}
+
+	// Never cache a file clients can't decompress; the previous entry stays in place
+	if err := verifyZstd(compressedPath, uncompressedSize); err != nil {
+		os.Remove(compressedPath)
+		return cacheEntry{}, fmt.Errorf("compressed database failed verification: %w", err)
+	}
+
+	// Hash once here so every download can carry the digest without rereading the file
+	sum, err := fileSHA256(compressedPath)
+	if err != nil {
+		os.Remove(compressedPath)
+		return cacheEntry{}, err
+	}
+
+	entry := cacheEntry{
+		path:             compressedPath,
+		uncompressedSize: uncompressedSize,
+		schemaHash:       built.schemaHash,
+		sha256:           sum,
+		projectCount:     built.projectCount,
+		mentionCount:     built.mentionCount,
+		dataModifiedAt:   built.dataModifiedAt,
+		emailHashes:      cfg.emailHashMode(),
+	}
+
+	// Get compressed file size
+	if compressedInfo, err := os.Stat(compressedPath); err == nil {
+		entry.compressedSize = compressedInfo.Size()
+		entry.compressionRatio = float64(uncompressedSize) / float64(entry.compressedSize)
+		entry.etag = fileETag(compressedInfo)
+		appLog.Info("Compressed database size: %.2f MB (%.1fx compression at level %s) in %s",
+			float64(entry.compressedSize)/(1024*1024), entry.compressionRatio, zstdLevel, time.Since(compressStart))
+	}
+
+	return entry, nil
+}
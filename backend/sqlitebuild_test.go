@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFinalizeSQLiteCompactsAndLeavesWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.db")
+	db, err := sql.Open("sqlite", buildDSN(path))
+	if err != nil {
+		t.Fatalf("opening SQLite: %v", err)
+	}
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+	for _, stmt := range []string{
+		`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 2000)
+			INSERT INTO ysws_project_mentions (id, headline) SELECT 'm' || i, printf('%.500c', 'x') FROM n`,
+		`DELETE FROM ysws_project_mentions WHERE id != 'm1'`,
+		`PRAGMA wal_checkpoint(TRUNCATE)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("executing %q: %v", stmt, err)
+		}
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	if err := finalizeSQLite(db); err != nil {
+		t.Fatalf("finalizeSQLite() error: %v", err)
+	}
+	db.Close()
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if after.Size() >= before.Size() {
+		t.Errorf("finalized file is %d bytes, want smaller than %d", after.Size(), before.Size())
+	}
+	if _, err := os.Stat(path + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("WAL file still present after finalizing (stat error: %v)", err)
+	}
+
+	// The shipped file must open read-only without any sidecar files
+	shipped, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer shipped.Close()
+
+	var mode, integrity string
+	if err := shipped.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "delete" {
+		t.Errorf("journal_mode = %q, %v, want delete", mode, err)
+	}
+	if err := shipped.QueryRow(`PRAGMA integrity_check`).Scan(&integrity); err != nil || integrity != "ok" {
+		t.Errorf("integrity_check = %q, %v, want ok", integrity, err)
+	}
+	if n, err := countRows(shipped, "ysws_project_mentions"); err != nil || n != 1 {
+		t.Errorf("ysws_project_mentions has %d rows (%v), want 1", n, err)
+	}
+
+	hash, err := schemaHash(shipped)
+	if err != nil || hash != expectedSchemaHash {
+		t.Errorf("schemaHash() after ANALYZE = %s, %v, want the expected hash", hash, err)
+	}
+}
+
+func TestMentionsDeclareProjectForeignKey(t *testing.T) {
+	db := openTestSQLite(t)
+	if err := createSQLiteTables(db); err != nil {
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+
+	var from, table, to string
+	err := db.QueryRow(`SELECT "from", "table", "to" FROM pragma_foreign_key_list('ysws_project_mentions')`).Scan(&from, &table, &to)
+	if err != nil {
+		t.Fatalf("reading foreign keys: %v", err)
+	}
+	if from != "ysws_approved_project" || table != "approved_projects" || to != "record_id" {
+		t.Errorf("foreign key = %s -> %s(%s), want ysws_approved_project -> approved_projects(record_id)", from, table, to)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO approved_projects (record_id) VALUES ('rec1');
+		INSERT INTO ysws_project_mentions (id, ysws_approved_project) VALUES
+			('m1', 'rec1'), ('m2', 'rec-missing'), ('m3', NULL);
+	`)
+	if err != nil {
+		t.Fatalf("inserting rows: %v", err)
+	}
+	if orphans, err := countOrphanMentions(db); err != nil || orphans != 1 {
+		t.Errorf("countOrphanMentions() = %d, %v; want 1", orphans, err)
+	}
+}
+
+func TestBuildSQLiteInMemory(t *testing.T) {
+	withExportTables(t)
+
+	// One connection, since each connection to :memory: is its own database
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening SQLite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	built, err := buildSQLite(nil, testConfig, db, filepath.Join(t.TempDir(), "build"), nil)
+	if err != nil {
+		t.Fatalf("buildSQLite() error: %v", err)
+	}
+	if built.projectCount != 2 || built.mentionCount != 1 {
+		t.Errorf("buildSQLite() counted %d projects and %d mentions, want 2 and 1", built.projectCount, built.mentionCount)
+	}
+	if built.schemaHash != expectedSchemaHash {
+		t.Errorf("schemaHash = %q, want %q", built.schemaHash, expectedSchemaHash)
+	}
+
+	for table, want := range map[string]int{"approved_projects": 2, "ysws_project_mentions": 1, "schema_meta": 1} {
+		if n, err := countRows(db, table); err != nil || n != want {
+			t.Errorf("%s has %d rows (%v), want %d", table, n, err, want)
+		}
+	}
+}
+
+func TestBuildAndCompressLeavesCacheAlone(t *testing.T) {
+	withExportTables(t)
+	withCacheEntry(t, cacheEntry{})
+
+	dir := t.TempDir()
+	entry, err := buildAndCompress(testConfig, dir, dir, cacheEntry{}, nil)
+	if err != nil {
+		t.Fatalf("buildAndCompress(testConfig, ) error: %v", err)
+	}
+	if filepath.Dir(entry.path) != dir || entry.projectCount != 2 || entry.mentionCount != 1 ||
+		entry.sha256 == "" || entry.etag == "" || entry.compressedSize <= 0 || entry.uncompressedSize <= 0 {
+		t.Errorf("buildAndCompress(testConfig, ) = %+v, want a complete entry in %s", entry, dir)
+	}
+	if err := verifyZstd(entry.path, entry.uncompressedSize); err != nil {
+		t.Errorf("compressed file doesn't verify: %v", err)
+	}
+
+	// Only the compressed file is left, and it isn't cached until the caller does so
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("buildAndCompress(testConfig, ) left %d files, want 1", len(files))
+	}
+	if cached, ok := fullCache.Get(); ok {
+		t.Errorf("buildAndCompress(testConfig, ) cached %+v", cached)
+	}
+}
+
+func TestBuildAndCompressFailureLeavesNothing(t *testing.T) {
+	withTableCopies(t,
+		func(context.Context, Querier, *sql.DB, copyScope) (int, error) { return 0, os.ErrDeadlineExceeded },
+		func(context.Context, Querier, *sql.DB, copyScope) (int, error) { return 0, nil },
+	)
+
+	dir := t.TempDir()
+	if _, err := buildAndCompress(testConfig, dir, dir, cacheEntry{}, nil); err == nil {
+		t.Fatal("buildAndCompress(testConfig, ) succeeded, want the copy error")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("failed build left %d files, want none", len(files))
+	}
+}
+
+func TestBuildAndCompressBuildsInScratchDir(t *testing.T) {
+	withExportTables(t)
+	withCacheEntry(t, cacheEntry{})
+
+	scratch, dir := t.TempDir(), t.TempDir()
+	entry, err := buildAndCompress(testConfig, scratch, dir, cacheEntry{}, nil)
+	if err != nil {
+		t.Fatalf("buildAndCompress(testConfig, ) error: %v", err)
+	}
+	if filepath.Dir(entry.path) != dir || !cacheFilePattern.MatchString(filepath.Base(entry.path)) {
+		t.Errorf("compressed database at %s, want a cache file in %s", entry.path, dir)
+	}
+	if files, _ := os.ReadDir(scratch); len(files) != 0 {
+		t.Errorf("buildAndCompress(testConfig, ) left %d files in the scratch dir, want none", len(files))
+	}
+}
+
+func TestCompressWithZstdToNeverOverwrites(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "cached-db-1.db")
+	if err := os.WriteFile(input, []byte("SQLite format 3\x00"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "cached-db-1.db.zst")
+	if err := os.WriteFile(existing, []byte("served database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := compressWithZstdTo(input, dir, nil); err == nil {
+		t.Error("compressWithZstdTo() succeeded over an existing file")
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "served database" {
+		t.Errorf("existing file now holds %q", data)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// datasetStats summarizes the cached database for /stats
+type datasetStats struct {
+	SchemaVersion    int       `json:"schema_version"`
+	ApprovedProjects int       `json:"approved_projects"`
+	Mentions         int       `json:"ysws_project_mentions"`
+	YSWSPrograms     int       `json:"distinct_ysws_programs"`
+	Countries        int       `json:"distinct_countries"`
+	LatestApprovedAt *string   `json:"latest_approved_at"`
+	GeneratedAt      time.Time `json:"generated_at"`
+}
+
+// Stats are computed once per cached database and reused until the cache changes
+var (
+	statsMutex  sync.Mutex
+	statsSource string
+	statsCached *datasetStats
+)
+
+// computeStats runs the summary queries against a generated database
+func computeStats(db *sql.DB) (*datasetStats, error) {
+	stats := &datasetStats{}
+
+	err := db.QueryRow(`
+		SELECT
+			COUNT(*),
+			COUNT(DISTINCT ysws_name),
+			COUNT(DISTINCT geocoded_country_code),
+			MAX(approved_at)
+		FROM approved_projects
+	`).Scan(&stats.ApprovedProjects, &stats.YSWSPrograms, &stats.Countries, &stats.LatestApprovedAt)
+	if err != nil {
+		return nil, fmt.Errorf("querying approved_projects: %w", err)
+	}
+
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ysws_project_mentions`).Scan(&stats.Mentions); err != nil {
+		return nil, fmt.Errorf("querying ysws_project_mentions: %w", err)
+	}
+
+	// Read from the database itself, so it reflects what clients actually download
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&stats.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("reading user_version: %w", err)
+	}
+
+	return stats, nil
+}
+
+// getStats returns the stats for the given cached database, computing them on first use
+func getStats(log *Logger, compressedPath string) (*datasetStats, error) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	if statsCached != nil && statsSource == compressedPath {
+		return statsCached, nil
+	}
+
+	db, release, err := openQueryDB(compressedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var stats *datasetStats
+	err = retryIfBusy(log, func() (err error) {
+		stats, err = computeStats(db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if entry, ok := fullCache.Lookup(compressedPath); ok {
+		stats.GeneratedAt = entry.createdAt.UTC()
+	}
+
+	statsSource = compressedPath
+	statsCached = stats
+	return stats, nil
+}
+
+// statsHandler returns a JSON summary of the dataset, computed from the cached
+// SQLite database rather than Postgres so it stays cheap
+func statsHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := ensureDB(cfg)
+		if err != nil {
+			requestLog(r).Error("Failed to prepare database for stats: %v", err)
+			writeGenerationFailure(w, err)
+			return
+		}
+
+		stats, err := getStats(requestLog(r), path)
+		if err != nil {
+			requestLog(r).Error("Failed to compute stats: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
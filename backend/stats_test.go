@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTestDatabase creates a generated-schema SQLite file, runs the given statements
+// against it, and returns the raw file contents
+func buildTestDatabase(t *testing.T, statements ...string) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "build.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("opening SQLite: %v", err)
+	}
+	if err := createSQLiteTables(db); err != nil {
+		db.Close()
+		t.Fatalf("createSQLiteTables() error: %v", err)
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			t.Fatalf("executing %q: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading database: %v", err)
+	}
+	return contents
+}
+
+func TestStatsHandler(t *testing.T) {
+	withCachedDatabase(t, buildTestDatabase(t,
+		`INSERT INTO approved_projects (record_id, ysws_name, geocoded_country_code, approved_at) VALUES
+			('rec1', 'Daydream', 'US', '2024-05-01'),
+			('rec2', 'Daydream', 'IN', '2024-06-15'),
+			('rec3', 'Summer of Making', 'US', '2024-03-10')`,
+		`INSERT INTO ysws_project_mentions (id, ysws_approved_project) VALUES ('m1', 'rec1'), ('m2', 'rec1')`,
+	))
+	t.Cleanup(closeQueryDB)
+
+	rec := httptest.NewRecorder()
+	statsHandler(testConfig)(rec, httptest.NewRequest("GET", "/stats", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	var stats datasetStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if stats.ApprovedProjects != 3 || stats.Mentions != 2 || stats.YSWSPrograms != 2 || stats.Countries != 2 {
+		t.Errorf("stats = %+v, want 3 projects, 2 mentions, 2 programs, 2 countries", stats)
+	}
+	if stats.LatestApprovedAt == nil || *stats.LatestApprovedAt != "2024-06-15" {
+		t.Errorf("latest_approved_at = %v, want 2024-06-15", stats.LatestApprovedAt)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// streamOnMiss (STREAM_ON_MISS=true) sends the zstd database to the client that caused a
+	// cache miss while it's being compressed, instead of after the .zst file has been written
+	// and read back. The response has no Content-Length and is sent chunked.
+	streamOnMiss bool
+
+	// regenerateStreaming is generateDBStreaming; swapped out in tests
+	regenerateStreaming = generateDBStreaming
+
+	// streamBufferLimit bounds how much compressed output may queue up for a streaming
+	// client that reads slower than the database compresses. Past it the client is dropped,
+	// so it can never hold up the generation everyone else is waiting on.
+	streamBufferLimit int64 = 64 << 20
+)
+
+// bufferedClient hands writes to a goroutine that sends them to the client, so the
+// generation writing into it never waits on the network. Writes beyond limit bytes
+// queued drop the client instead of blocking.
+type bufferedClient struct {
+	w     io.Writer
+	limit int64
+
+	mu      sync.Mutex
+	ready   *sync.Cond
+	queue   [][]byte
+	queued  int64
+	closed  bool
+	dropped bool
+	n       int64
+	err     error
+	done    chan struct{}
+}
+
+func newBufferedClient(w io.Writer, limit int64) *bufferedClient {
+	c := &bufferedClient{w: w, limit: limit, done: make(chan struct{})}
+	c.ready = sync.NewCond(&c.mu)
+	go c.run()
+	return c
+}
+
+func (c *bufferedClient) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.dropped {
+		return len(p), nil
+	}
+	if c.queued+int64(len(p)) > c.limit {
+		c.dropped = true
+		c.queue, c.queued = nil, 0
+		c.ready.Signal()
+		return len(p), nil
+	}
+	c.queue = append(c.queue, append([]byte(nil), p...))
+	c.queued += int64(len(p))
+	c.ready.Signal()
+	return len(p), nil
+}
+
+// run sends queued writes to the client until it's closed and drained, or dropped
+func (c *bufferedClient) run() {
+	defer close(c.done)
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && !c.closed && !c.dropped {
+			c.ready.Wait()
+		}
+		if c.dropped || len(c.queue) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		chunk := c.queue[0]
+		c.queue = c.queue[1:]
+		c.queued -= int64(len(chunk))
+		c.mu.Unlock()
+
+		n, err := c.w.Write(chunk)
+		c.mu.Lock()
+		c.n += int64(n)
+		if err != nil {
+			c.err, c.dropped = err, true
+			c.queue, c.queued = nil, 0
+		}
+		c.mu.Unlock()
+	}
+}
+
+// finish waits for everything written so far to reach the client. It returns how many
+// bytes were sent, and an error if the client was dropped along the way.
+func (c *bufferedClient) finish() (int64, error) {
+	c.mu.Lock()
+	c.closed = true
+	c.ready.Signal()
+	c.mu.Unlock()
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.err != nil:
+		return c.n, c.err
+	case c.dropped:
+		return c.n, fmt.Errorf("client fell more than %d MB behind", c.limit>>20)
+	}
+	return c.n, nil
+}
+
+// clientWriter writes to a client on a best-effort basis: after the first error it drops
+// everything, so the cache file being written alongside it still completes
+type clientWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *clientWriter) Write(p []byte) (int, error) {
+	if c.err == nil {
+		var n int
+		n, c.err = c.w.Write(p)
+		c.n += int64(n)
+	}
+	return len(p), nil
+}
+
+// serveGeneratingDB generates a fresh database and streams its zstd output to w as it's
+// compressed. If another request already generated a fresh one, that's served as usual.
+// The stream is a download, so it holds a download slot for the whole generation. Output
+// is queued for the client rather than written under the generation lock, and whatever is
+// still queued once the database is cached is sent after the lock is released.
+func serveGeneratingDB(cfg *Config, w http.ResponseWriter, r *http.Request, disposition string, requestStart time.Time) {
+	release, ok := acquireDownloadSlot()
+	if !ok {
+		writeDownloadsBusy(w, r)
+		return
+	}
+	defer release()
+
+	var client *bufferedClient
+	path, err := regenerateStreaming(cfg, cacheTTL, func() io.Writer {
+		w.Header().Set("Content-Type", "application/zstd")
+		w.Header().Set("Content-Disposition", disposition)
+		w.Header().Set("Content-Transfer-Encoding", "binary")
+		w.Header().Set("X-Cache", "MISS")
+		w.Header().Set("X-Schema-Version", strconv.Itoa(schemaVersion))
+		w.WriteHeader(http.StatusOK)
+		client = newBufferedClient(w, streamBufferLimit)
+		return client
+	})
+
+	if err != nil {
+		metrics.observeGenerationFailure()
+		if path, ok := keptDB(err); ok && client == nil {
+			requestLog(r).Warn("Generated database was discarded, serving the previous one: %v", err)
+			markKeptDB(w)
+			serveCachedDB(w, r, path, disposition, requestStart)
+			return
+		}
+		requestLog(r).Error("Failed to generate database: %v", err)
+		if client == nil {
+			writeGenerationFailure(w, err)
+			return
+		}
+		client.finish()
+		// Headers are gone already; abort the connection so the client doesn't keep a
+		// truncated file that looks complete
+		panic(http.ErrAbortHandler)
+	}
+
+	if client == nil {
+		// Someone else generated it while we waited
+		w.Header().Set("X-Cache", "HIT")
+		serveCachedDB(w, r, path, disposition, requestStart)
+		return
+	}
+
+	requestLog(r).Info("Generated fresh database, caching for %s", cacheTTL)
+	sent, err := client.finish()
+	if err != nil {
+		requestLog(r).Error("Error streaming database to client: %v", err)
+		if client.err == nil {
+			// Dropped for falling behind, on a connection that still works: as above, a
+			// truncated stream mustn't look like a complete download
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+	requestLog(r).Info("Compressed database streamed: %.2f MB in %s", float64(sent)/(1024*1024), time.Since(requestStart))
+}
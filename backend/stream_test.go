@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressWithZstdToTeesOutput(t *testing.T) {
+	rawPath := filepath.Join(t.TempDir(), "cached-db-1.db")
+	if err := os.WriteFile(rawPath, bytes.Repeat([]byte("pretend database page "), 1000), 0o600); err != nil {
+		t.Fatalf("writing database: %v", err)
+	}
+
+	var streamed bytes.Buffer
+	compressedPath, err := compressWithZstdTo(rawPath, filepath.Dir(rawPath), &streamed)
+	if err != nil {
+		t.Fatalf("compressWithZstdTo() error: %v", err)
+	}
+	cached, err := os.ReadFile(compressedPath)
+	if err != nil {
+		t.Fatalf("reading compressed file: %v", err)
+	}
+	if !bytes.Equal(cached, streamed.Bytes()) {
+		t.Errorf("streamed %d bytes, cache file has %d; want identical output", streamed.Len(), len(cached))
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestClientWriterSwallowsErrors(t *testing.T) {
+	client := &clientWriter{w: failingWriter{}}
+	var cache bytes.Buffer
+	if _, err := io.MultiWriter(&cache, client).Write([]byte("data")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if cache.String() != "data" {
+		t.Errorf("cache got %q, want the data despite the client failing", cache.String())
+	}
+	if client.err == nil {
+		t.Error("clientWriter didn't record the client error")
+	}
+}
+
+func TestServeGeneratingDBStreamsWithoutContentLength(t *testing.T) {
+	prev := regenerateStreaming
+	t.Cleanup(func() { regenerateStreaming = prev })
+	regenerateStreaming = func(_ *Config, _ time.Duration, streamTo func() io.Writer) (string, error) {
+		io.WriteString(streamTo(), "compressed-bytes")
+		return "cached-db-1.db.zst", nil
+	}
+
+	rec := httptest.NewRecorder()
+	serveGeneratingDB(testConfig, rec, httptest.NewRequest("GET", "/db", nil), `attachment; filename="database.db.zst"`, time.Now())
+
+	if rec.Body.String() != "compressed-bytes" {
+		t.Errorf("body = %q, want the streamed output", rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length = %q, want none for a streamed response", got)
+	}
+	if got := rec.Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("X-Cache = %q, want MISS", got)
+	}
+}
+
+func TestServeGeneratingDBReportsEarlyFailure(t *testing.T) {
+	prev := regenerateStreaming
+	t.Cleanup(func() { regenerateStreaming = prev })
+	regenerateStreaming = func(*Config, time.Duration, func() io.Writer) (string, error) {
+		return "", errors.New("postgres unavailable")
+	}
+
+	rec := httptest.NewRecorder()
+	serveGeneratingDB(testConfig, rec, httptest.NewRequest("GET", "/db", nil), `attachment; filename="database.db.zst"`, time.Now())
+
+	if rec.Code != 500 {
+		t.Errorf("status = %d, want 500 when generation fails before streaming", rec.Code)
+	}
+}
+
+// stalledWriter blocks every write until release is closed
+type stalledWriter struct {
+	release chan struct{}
+	buf     bytes.Buffer
+}
+
+func (s *stalledWriter) Write(p []byte) (int, error) {
+	<-s.release
+	return s.buf.Write(p)
+}
+
+func TestBufferedClientDoesNotBlockOnAStalledClient(t *testing.T) {
+	client := &stalledWriter{release: make(chan struct{})}
+	buffered := newBufferedClient(client, 1<<20)
+
+	// The writes return while the client is still stalled
+	written := make(chan struct{})
+	go func() {
+		io.WriteString(buffered, "compressed-")
+		io.WriteString(buffered, "bytes")
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write() blocked on a stalled client")
+	}
+
+	close(client.release)
+	if n, err := buffered.finish(); err != nil || n != int64(len("compressed-bytes")) {
+		t.Fatalf("finish() = %d, %v; want every byte sent", n, err)
+	}
+	if client.buf.String() != "compressed-bytes" {
+		t.Errorf("client got %q, want the writes in order", client.buf.String())
+	}
+}
+
+func TestBufferedClientDropsAClientThatFallsTooFarBehind(t *testing.T) {
+	client := &stalledWriter{release: make(chan struct{})}
+	buffered := newBufferedClient(client, 8)
+
+	io.WriteString(buffered, "first")
+	io.WriteString(buffered, "second chunk past the limit")
+	close(client.release)
+
+	if _, err := buffered.finish(); err == nil {
+		t.Fatal("finish() succeeded for a client that fell behind")
+	}
+	if strings.Contains(client.buf.String(), "second") {
+		t.Errorf("client got %q after being dropped", client.buf.String())
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Subset downloads (?tables=approved_projects, ?ysws=Daydream) are generated from Postgres
+// with only the requested copies and rows, and cached separately per subset so they don't
+// evict the full database. Requesting every table is the same as not passing ?tables= at all.
+var (
+	subsetGenerationMutex sync.Mutex
+	subsetCacheMutex      sync.RWMutex
+	subsetCache           = map[string]*subsetEntry{}
+)
+
+// subsetEntry is a cached subset database
+type subsetEntry struct {
+	path             string
+	uncompressedSize int64
+	createdAt        time.Time
+}
+
+// maxSubsetCacheEntries bounds how many subsets are kept on disk at once, since ?ysws=
+// values come from clients. The oldest is evicted to make room.
+const maxSubsetCacheEntries = 32
+
+// maxYSWSNameLength bounds ?ysws= values; real program names are far shorter
+const maxYSWSNameLength = 200
+
+// The program names in the full database, read once per cached database so a ?ysws= value
+// can be checked before anything is built for it
+var (
+	yswsProgramsMutex  sync.Mutex
+	yswsProgramsSource string
+	yswsPrograms       map[string]bool
+)
+
+// dbSubset describes which part of the dataset a download asks for. The zero value is
+// the full database.
+type dbSubset struct {
+	tables []string // sorted; nil for every table
+	ysws   string   // only this program's projects and their mentions; empty for all
+}
+
+// parseSubset reads ?tables= and ?ysws= from a request
+func parseSubset(r *http.Request) (dbSubset, error) {
+	tables, err := parseTables(r.URL.Query().Get("tables"))
+	if err != nil {
+		return dbSubset{}, err
+	}
+
+	ysws, err := parseYSWSName(r.URL.Query().Get("ysws"))
+	if err != nil {
+		return dbSubset{}, err
+	}
+
+	return dbSubset{tables: tables, ysws: ysws}, nil
+}
+
+// parseYSWSName validates a ?ysws= program name, returning it trimmed
+func parseYSWSName(value string) (string, error) {
+	ysws := strings.TrimSpace(value)
+	if len(ysws) > maxYSWSNameLength {
+		return "", fmt.Errorf("ysws is longer than %d characters", maxYSWSNameLength)
+	}
+	for _, c := range ysws {
+		if unicode.IsControl(c) {
+			return "", fmt.Errorf("ysws contains control characters")
+		}
+	}
+	return ysws, nil
+}
+
+// isFull reports whether the subset is actually the whole database
+func (s dbSubset) isFull() bool {
+	return s.tables == nil && s.ysws == ""
+}
+
+// key identifies the subset in the cache and logs
+func (s dbSubset) key() string {
+	tables := "all"
+	if s.tables != nil {
+		tables = strings.Join(s.tables, ",")
+	}
+	if s.ysws == "" {
+		return "tables=" + tables
+	}
+	return fmt.Sprintf("tables=%s ysws=%q", tables, s.ysws)
+}
+
+// parseTables parses a comma-separated ?tables= value into a sorted, de-duplicated list of
+// known tables. It returns nil when the value is empty or names every table.
+func parseTables(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	known := make(map[string]bool, len(tableCopies))
+	for _, tc := range tableCopies {
+		known[tc.table] = true
+	}
+
+	seen := make(map[string]bool)
+	var tables []string
+	for _, raw := range strings.Split(value, ",") {
+		table := strings.TrimSpace(raw)
+		if table == "" || seen[table] {
+			continue
+		}
+		if !known[table] {
+			return nil, fmt.Errorf("unknown table %q", table)
+		}
+		seen[table] = true
+		tables = append(tables, table)
+	}
+
+	if len(tables) == 0 || len(tables) == len(tableCopies) {
+		return nil, nil
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// getSubsetDB returns the cached database for a table set if it's still fresh
+func getSubsetDB(key string) (string, bool) {
+	subsetCacheMutex.RLock()
+	defer subsetCacheMutex.RUnlock()
+
+	entry, ok := subsetCache[key]
+	if !ok || time.Since(entry.createdAt) > cacheTTL {
+		return "", false
+	}
+	if _, err := os.Stat(entry.path); os.IsNotExist(err) {
+		return "", false
+	}
+	return entry.path, true
+}
+
+// knownYSWSProgram reports whether the full database has projects from the named program.
+// ?ysws= values come from clients, and each new one costs a Postgres build and a slot in
+// the subset cache, so made-up names are turned away first. A program added since the
+// full database was built is recognized once it's regenerated.
+func knownYSWSProgram(cfg *Config, log *Logger, name string) (bool, error) {
+	path, err := ensureDB(cfg)
+	if err != nil {
+		return false, err
+	}
+
+	yswsProgramsMutex.Lock()
+	defer yswsProgramsMutex.Unlock()
+	if yswsPrograms == nil || yswsProgramsSource != path {
+		db, release, err := openQueryDB(path)
+		if err != nil {
+			return false, err
+		}
+		defer release()
+		var programs map[string]bool
+		err = retryIfBusy(log, func() (err error) {
+			programs, err = readYSWSPrograms(db)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		yswsPrograms, yswsProgramsSource = programs, path
+	}
+	return yswsPrograms[name], nil
+}
+
+// readYSWSPrograms returns the distinct program names in a generated database
+func readYSWSPrograms(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT DISTINCT ysws_name FROM approved_projects WHERE ysws_name IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("querying ysws_name: %w", err)
+	}
+	defer rows.Close()
+
+	programs := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("reading ysws_name: %w", err)
+		}
+		programs[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading ysws_name: %w", err)
+	}
+	return programs, nil
+}
+
+// generateSubsetDB builds and caches a database containing only the given subset, logging
+// to the log of the request that asked for it
+func generateSubsetDB(cfg *Config, log *Logger, subset dbSubset) (string, error) {
+	key := subset.key()
+
+	subsetGenerationMutex.Lock()
+	defer subsetGenerationMutex.Unlock()
+
+	// Another request may have generated this subset while we waited
+	if path, ok := getSubsetDB(key); ok {
+		return path, nil
+	}
+
+	generationStart := time.Now()
+	tmpFile, err := os.CreateTemp(buildDir(), "cached-db-*.db")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+
+	if err := buildSubsetSQLite(cfg, tmpPath, subset); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	var uncompressedSize int64
+	if info, err := os.Stat(tmpPath); err == nil {
+		uncompressedSize = info.Size()
+	}
+
+	compressedPath, err := compressWithZstdTo(tmpPath, cacheDir, nil)
+	os.Remove(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to compress database: %w", err)
+	}
+	if err := verifyZstd(compressedPath, uncompressedSize); err != nil {
+		os.Remove(compressedPath)
+		return "", fmt.Errorf("compressed database failed verification: %w", err)
+	}
+	log.Info("Generated subset database (%s) in %s", key, time.Since(generationStart))
+
+	subsetCacheMutex.Lock()
+	var evicted []string
+	if previous, ok := subsetCache[key]; ok {
+		evicted = append(evicted, previous.path)
+	} else if len(subsetCache) >= maxSubsetCacheEntries {
+		oldestKey := ""
+		for k, entry := range subsetCache {
+			if oldestKey == "" || entry.createdAt.Before(subsetCache[oldestKey].createdAt) {
+				oldestKey = k
+			}
+		}
+		evicted = append(evicted, subsetCache[oldestKey].path)
+		delete(subsetCache, oldestKey)
+	}
+	subsetCache[key] = &subsetEntry{path: compressedPath, uncompressedSize: uncompressedSize, createdAt: time.Now()}
+	subsetCacheMutex.Unlock()
+
+	// A request may have just looked an evicted path up, so let it open the file first
+	for _, path := range evicted {
+		removeAfterGrace(path)
+		removeDerivedFiles(path)
+	}
+	return compressedPath, nil
+}
+
+// buildSubsetSQLite creates the usual schema at path, copies the requested tables and rows
+// into it, and drops the other tables (along with their search tables)
+func buildSubsetSQLite(cfg *Config, path string, subset dbSubset) error {
+	db, err := sql.Open("sqlite", buildDSN(path))
+	if err != nil {
+		return fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	defer db.Close()
+
+	if err := createSQLiteTables(db); err != nil {
+		return fmt.Errorf("failed to create tables: %w", err)
+	}
+
+	requested := make(map[string]bool, len(subset.tables))
+	for _, table := range subset.tables {
+		requested[table] = true
+	}
+	var copies []tableCopy
+	for _, tc := range tableCopies {
+		if subset.tables == nil || requested[tc.table] {
+			copies = append(copies, tc)
+			continue
+		}
+		if _, err := db.Exec(`DROP TABLE ` + tc.table); err != nil {
+			return fmt.Errorf("dropping %s: %w", tc.table, err)
+		}
+		if fts, ok := ftsTable[tc.table]; ok && ftsAvailable {
+			if _, err := db.Exec(`DROP TABLE ` + fts.name); err != nil {
+				return fmt.Errorf("dropping %s: %w", fts.name, err)
+			}
+		}
+	}
+
+	ctx, cancel := generationContext()
+	defer cancel()
+	if _, err := copyTables(ctx, pgDB, db, path, copyScope{cfg: cfg, ysws: subset.ysws}, copies); err != nil {
+		return generationError(ctx, err)
+	}
+	// The engagement rollups need both tables
+	if len(copies) == len(tableCopies) {
+		if err := buildEngagementSummary(db); err != nil {
+			return err
+		}
+	} else if _, err := db.Exec(`DROP TABLE project_engagement_summary`); err != nil {
+		return fmt.Errorf("dropping project_engagement_summary: %w", err)
+	}
+
+	if err := writeSchemaMeta(db, time.Now()); err != nil {
+		return err
+	}
+	return finalizeSQLite(db)
+}
+
+// handleSubsetDownload serves a subset database, generating it on a cache miss
+func handleSubsetDownload(cfg *Config, w http.ResponseWriter, r *http.Request, subset dbSubset, format, disposition string, requestStart time.Time) {
+	key := subset.key()
+	path, fromCache := getSubsetDB(key)
+	metrics.observeCache(fromCache)
+
+	if fromCache {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		if subset.ysws != "" {
+			known, err := knownYSWSProgram(cfg, requestLog(r), subset.ysws)
+			if err != nil {
+				requestLog(r).Error("Failed to read YSWS programs for subset (%s): %v", key, err)
+				writeGenerationFailure(w, err)
+				return
+			}
+			if !known {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad Request: unknown ysws program %q", subset.ysws))
+				return
+			}
+		}
+
+		var err error
+		path, err = generateSubsetDB(cfg, requestLog(r), subset)
+		if err != nil {
+			metrics.observeGenerationFailure()
+			requestLog(r).Error("Failed to generate subset database (%s): %v", key, err)
+			writeGenerationFailure(w, err)
+			return
+		}
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	requestLog(r).Info("Serving subset database (%s, format: %s)", key, format)
+	serveDB(w, r, path, format, disposition, requestStart)
+}
+
+// subsetUncompressedSize returns the uncompressed size of a cached subset database, or 0
+func subsetUncompressedSize(compressedPath string) int64 {
+	subsetCacheMutex.RLock()
+	defer subsetCacheMutex.RUnlock()
+
+	for _, entry := range subsetCache {
+		if entry.path == compressedPath {
+			return entry.uncompressedSize
+		}
+	}
+	return 0
+}
+
+// removeSubsetDBs drops every cached subset database, deleting the files once requests
+// that already looked them up have had time to open them
+func removeSubsetDBs() {
+	subsetCacheMutex.Lock()
+	defer subsetCacheMutex.Unlock()
+
+	for key, entry := range subsetCache {
+		removeAfterGrace(entry.path)
+		removeDerivedFiles(entry.path)
+		delete(subsetCache, key)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withSubsetEntry caches a fresh subset file under key and returns its path, restoring the
+// subset cache afterwards
+func withSubsetEntry(t *testing.T, key string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cached-db-3.db.zst")
+	if err := os.WriteFile(path, []byte("subset"), 0o600); err != nil {
+		t.Fatalf("writing subset: %v", err)
+	}
+
+	subsetCacheMutex.Lock()
+	prev := subsetCache
+	subsetCache = map[string]*subsetEntry{key: {path: path, createdAt: time.Now()}}
+	subsetCacheMutex.Unlock()
+	t.Cleanup(func() {
+		subsetCacheMutex.Lock()
+		subsetCache = prev
+		subsetCacheMutex.Unlock()
+	})
+	return path
+}
+
+func TestParseTables(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"approved_projects", []string{"approved_projects"}},
+		{" ysws_project_mentions , ysws_project_mentions", []string{"ysws_project_mentions"}},
+		{"ysws_project_mentions,approved_projects", nil}, // every table is the full database
+	}
+	for _, tt := range tests {
+		got, err := parseTables(tt.value)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTables(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+
+	if _, err := parseTables("approved_projects,users"); err == nil {
+		t.Error("parseTables() accepted an unknown table")
+	}
+}
+
+func TestBuildSubsetSQLiteKeepsOnlyRequestedTables(t *testing.T) {
+	withTableCopies(t,
+		func(ctx context.Context, _ Querier, db *sql.DB, _ copyScope) (int, error) {
+			_, err := db.ExecContext(ctx, `INSERT INTO approved_projects (record_id) VALUES ('rec1')`)
+			return 1, err
+		},
+		func(context.Context, Querier, *sql.DB, copyScope) (int, error) {
+			t.Error("copied ysws_project_mentions for an approved_projects-only database")
+			return 0, nil
+		},
+	)
+
+	path := filepath.Join(t.TempDir(), "subset.db")
+	if err := buildSubsetSQLite(testConfig, path, dbSubset{tables: []string{"approved_projects"}}); err != nil {
+		t.Fatalf("buildSubsetSQLite(testConfig, ) error: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatalf("opening subset: %v", err)
+	}
+	defer db.Close()
+
+	if n, err := countRows(db, "approved_projects"); err != nil || n != 1 {
+		t.Errorf("approved_projects has %d rows (%v), want 1", n, err)
+	}
+	var mentionTables int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE tbl_name IN ('ysws_project_mentions', 'mentions_fts')`).Scan(&mentionTables)
+	if mentionTables != 0 {
+		t.Errorf("subset still contains %d mention tables/indexes", mentionTables)
+	}
+	var version int
+	if err := db.QueryRow(`SELECT schema_version FROM schema_meta`).Scan(&version); err != nil || version != schemaVersion {
+		t.Errorf("schema_meta version = %d (%v), want %d", version, err, schemaVersion)
+	}
+}
+
+func TestParseSubsetYSWS(t *testing.T) {
+	subset, err := parseSubset(httptest.NewRequest("GET", "/db?ysws=Summer+of+Making&tables=approved_projects", nil))
+	if err != nil {
+		t.Fatalf("parseSubset() error: %v", err)
+	}
+	if subset.ysws != "Summer of Making" || subset.isFull() {
+		t.Errorf("parseSubset() = %+v, want a Summer of Making subset", subset)
+	}
+	if got := subset.key(); got != `tables=approved_projects ysws="Summer of Making"` {
+		t.Errorf("key() = %s", got)
+	}
+
+	// Filter values are bound as query parameters, so quotes are just part of the name
+	if _, err := parseSubset(httptest.NewRequest("GET", "/db?ysws='%3B+DROP+TABLE+x%3B--", nil)); err != nil {
+		t.Errorf("parseSubset() rejected a name with quotes: %v", err)
+	}
+	if _, err := parseSubset(httptest.NewRequest("GET", "/db?ysws=a%0Ab", nil)); err == nil {
+		t.Error("parseSubset() accepted a name with a newline")
+	}
+}
+
+func TestPGFilterNumbersPlaceholders(t *testing.T) {
+	var f pgFilter
+	if f.where() != "" {
+		t.Errorf("empty filter where() = %q, want empty", f.where())
+	}
+	f.add(`date >= ?`, "2024-01-01")
+	f.add(`ysws_name.value = ?`, "Daydream")
+	if got := f.where(); got != ` WHERE date >= $1 AND ysws_name.value = $2` {
+		t.Errorf("where() = %q", got)
+	}
+	if !reflect.DeepEqual(f.args, []interface{}{"2024-01-01", "Daydream"}) {
+		t.Errorf("args = %v", f.args)
+	}
+}
+
+func TestSubsetDownloadRejectsUnknownYSWSProgram(t *testing.T) {
+	withCachedDatabase(t, buildTestDatabase(t,
+		`INSERT INTO approved_projects (record_id, ysws_name) VALUES ('rec1', 'Daydream'), ('rec2', NULL)`))
+	t.Cleanup(closeQueryDB)
+
+	if known, err := knownYSWSProgram(testConfig, appLog, "Daydream"); err != nil || !known {
+		t.Errorf("knownYSWSProgram(Daydream) = %v, %v; want true", known, err)
+	}
+
+	// A made-up name is turned away before anything is generated for it
+	rec := httptest.NewRecorder()
+	handleSubsetDownload(testConfig, rec, httptest.NewRequest("GET", "/db", nil), dbSubset{ysws: "Made Up"}, formatZstd, "attachment", time.Now())
+	if body := decodeErrorResponse(t, rec, http.StatusBadRequest); body.Error != `Bad Request: unknown ysws program "Made Up"` {
+		t.Errorf("error = %q, want the unknown program", body.Error)
+	}
+	if _, ok := getSubsetDB(dbSubset{ysws: "Made Up"}.key()); ok {
+		t.Error("a subset was cached for the unknown program")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// sqliteColumn declares a column of a generated table
+type sqliteColumn struct {
+	name       string
+	sqlType    string
+	primaryKey bool
+	notNull    bool
+	references string // "table(column)" for a foreign key, or empty
+}
+
+// sqliteIndex declares an index over columns of its table
+type sqliteIndex struct {
+	name    string
+	columns []string
+}
+
+// sqliteTable declares a generated table. createSQLiteTables and /schema are both built
+// from these definitions, so the endpoint can't drift from the database.
+type sqliteTable struct {
+	name    string
+	columns []sqliteColumn
+	indexes []sqliteIndex
+}
+
+var (
+	approvedProjectsTable = sqliteTable{
+		name: "approved_projects",
+		columns: []sqliteColumn{
+			{name: "record_id", sqlType: "TEXT", primaryKey: true},
+			{name: "first_name", sqlType: "TEXT"},
+			{name: "last_name", sqlType: "TEXT"},
+			{name: "git_hub_username", sqlType: "TEXT"},
+			{name: "geocoded_country", sqlType: "TEXT"},
+			{name: "geocoded_country_code", sqlType: "TEXT"},
+			{name: "playable_url", sqlType: "TEXT"},
+			{name: "code_url", sqlType: "TEXT"},
+			{name: "hours_spent", sqlType: "REAL"},
+			{name: "approved_at", sqlType: "TEXT"},
+			{name: "override_hours_spent_justification", sqlType: "TEXT"},
+			{name: "age_when_approved", sqlType: "INTEGER"},
+			{name: "ysws_name", sqlType: "TEXT"},
+			{name: "email_hash", sqlType: "TEXT"},
+			{name: "email_domain", sqlType: "TEXT"},
+		},
+	}
+
+	projectMentionsTable = sqliteTable{
+		name: "ysws_project_mentions",
+		columns: []sqliteColumn{
+			{name: "id", sqlType: "TEXT", primaryKey: true},
+			{name: "ysws_project_mentions_id", sqlType: "TEXT"},
+			{name: "ysws_project_mention_searches", sqlType: "TEXT"},
+			{name: "ysws_from_ysws_approved_project", sqlType: "TEXT"},
+			{name: "record_id", sqlType: "TEXT"},
+			{name: "ysws_approved_project", sqlType: "TEXT", references: "approved_projects(record_id)"},
+			{name: "source", sqlType: "TEXT"},
+			{name: "link_found_at", sqlType: "TEXT"},
+			{name: "archive_url", sqlType: "TEXT"},
+			{name: "url", sqlType: "TEXT"},
+			{name: "headline", sqlType: "TEXT"},
+			{name: "date", sqlType: "TEXT"},
+			{name: "weighted_engagement_points", sqlType: "REAL"},
+			{name: "project_url", sqlType: "TEXT"},
+			{name: "engagement_count", sqlType: "INTEGER"},
+			{name: "engagement_type", sqlType: "TEXT"},
+			{name: "mentions_hack_club", sqlType: "INTEGER"},
+			{name: "published_by_hack_club", sqlType: "INTEGER"},
+		},
+		indexes: []sqliteIndex{
+			{name: "idx_mentions_record_id", columns: []string{"record_id"}},
+			{name: "idx_mentions_approved_project", columns: []string{"ysws_approved_project"}},
+		},
+	}
+
+	// Per-project engagement rollups, filled by buildEngagementSummary
+	engagementSummaryTable = sqliteTable{
+		name: "project_engagement_summary",
+		columns: []sqliteColumn{
+			{name: "record_id", sqlType: "TEXT", primaryKey: true, references: "approved_projects(record_id)"},
+			{name: "total_mentions", sqlType: "INTEGER", notNull: true},
+			{name: "total_weighted_engagement", sqlType: "REAL", notNull: true},
+			{name: "max_engagement", sqlType: "REAL"},
+			{name: "distinct_sources", sqlType: "INTEGER", notNull: true},
+		},
+	}
+
+	// Schema version, readable via schema_meta or PRAGMA user_version
+	schemaMetaTable = sqliteTable{
+		name: "schema_meta",
+		columns: []sqliteColumn{
+			{name: "schema_version", sqlType: "INTEGER", notNull: true},
+			{name: "generated_at", sqlType: "TEXT", notNull: true},
+		},
+	}
+
+	// sqliteTables lists every generated table in creation order. The full-text search
+	// tables aren't here: they're virtual, and only exist when SQLite supports FTS5.
+	sqliteTables = []sqliteTable{approvedProjectsTable, projectMentionsTable, engagementSummaryTable, schemaMetaTable}
+)
+
+// createStatement returns the CREATE TABLE statement for t, with foreign keys declared
+// as table constraints after the columns
+func (t sqliteTable) createStatement() string {
+	var defs, foreignKeys []string
+	for _, c := range t.columns {
+		def := c.name + " " + c.sqlType
+		if c.primaryKey {
+			def += " PRIMARY KEY"
+		}
+		if c.notNull {
+			def += " NOT NULL"
+		}
+		defs = append(defs, def)
+		if c.references != "" {
+			foreignKeys = append(foreignKeys, fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s", c.name, c.references))
+		}
+	}
+	defs = append(defs, foreignKeys...)
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", t.name, strings.Join(defs, ",\n\t"))
+}
+
+// columnNames returns t's column names in table order
+func (t sqliteTable) columnNames() []string {
+	names := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// insertStatement builds an INSERT (or INSERT OR REPLACE) of every column of t
+func (t sqliteTable) insertStatement(verb string) string {
+	return insertStatement(verb, t.name, t.columnNames())
+}
+
+// create creates t and its indexes
+func (t sqliteTable) create(db *sql.DB) error {
+	if _, err := db.Exec(t.createStatement()); err != nil {
+		return fmt.Errorf("creating %s table: %w", t.name, err)
+	}
+	for _, index := range t.indexes {
+		stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(%s)", index.name, t.name, strings.Join(index.columns, ", "))
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("creating %s index: %w", index.name, err)
+		}
+	}
+	return nil
+}
+
+// columnSchema describes a column in the /schema response
+type columnSchema struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Nullable   bool   `json:"nullable"`
+	PrimaryKey bool   `json:"primary_key,omitempty"`
+	References string `json:"references,omitempty"`
+}
+
+// indexSchema describes an index in the /schema response
+type indexSchema struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+}
+
+// tableSchema describes a table in the /schema response
+type tableSchema struct {
+	Name    string         `json:"name"`
+	Columns []columnSchema `json:"columns"`
+	Indexes []indexSchema  `json:"indexes"`
+}
+
+// schemaResponse is the body returned by /schema
+type schemaResponse struct {
+	SchemaVersion int           `json:"schema_version"`
+	Tables        []tableSchema `json:"tables"`
+}
+
+// describeSchema describes sqliteTables for /schema
+func describeSchema() schemaResponse {
+	response := schemaResponse{SchemaVersion: schemaVersion, Tables: []tableSchema{}}
+	for _, t := range sqliteTables {
+		table := tableSchema{Name: t.name, Columns: []columnSchema{}, Indexes: []indexSchema{}}
+		for _, c := range t.columns {
+			// SQLite lets non-INTEGER primary keys hold NULL, so only NOT NULL counts
+			table.Columns = append(table.Columns, columnSchema{
+				Name: c.name, Type: c.sqlType, Nullable: !c.notNull, PrimaryKey: c.primaryKey, References: c.references,
+			})
+		}
+		for _, index := range t.indexes {
+			table.Indexes = append(table.Indexes, indexSchema{Name: index.name, Columns: index.columns})
+		}
+		response.Tables = append(response.Tables, table)
+	}
+	return response
+}
+
+// schemaHandler describes the tables of the generated database. It never triggers a
+// generation: the structure is fixed by schemaVersion, not by the data.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Schema-Version", strconv.Itoa(schemaVersion))
+	json.NewEncoder(w).Encode(describeSchema())
+}
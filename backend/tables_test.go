@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaHandlerMatchesDatabase(t *testing.T) {
+	db, _ := openGeneratedDB(t)
+
+	rec := httptest.NewRecorder()
+	schemaHandler(rec, httptest.NewRequest("GET", "/schema", nil))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, Content-Type %q; want 200 JSON", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var got schemaResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if got.SchemaVersion != schemaVersion || len(got.Tables) != len(sqliteTables) {
+		t.Fatalf("schema_version %d with %d tables, want %d with %d", got.SchemaVersion, len(got.Tables), schemaVersion, len(sqliteTables))
+	}
+
+	for _, table := range got.Tables {
+		rows, err := db.Query(`SELECT name, type, "notnull", pk FROM pragma_table_info(?)`, table.Name)
+		if err != nil {
+			t.Fatalf("reading columns of %s: %v", table.Name, err)
+		}
+		var actual []columnSchema
+		for rows.Next() {
+			var c columnSchema
+			var notNull, pk int
+			if err := rows.Scan(&c.Name, &c.Type, &notNull, &pk); err != nil {
+				t.Fatalf("scanning columns of %s: %v", table.Name, err)
+			}
+			c.Nullable, c.PrimaryKey = notNull == 0, pk > 0
+			actual = append(actual, c)
+		}
+		rows.Close()
+
+		described := make([]columnSchema, len(table.Columns))
+		for i, c := range table.Columns {
+			described[i] = columnSchema{Name: c.Name, Type: c.Type, Nullable: c.Nullable, PrimaryKey: c.PrimaryKey}
+		}
+		if !reflect.DeepEqual(described, actual) {
+			t.Errorf("/schema columns of %s = %+v, database has %+v", table.Name, described, actual)
+		}
+
+		for _, index := range table.Indexes {
+			var count int
+			if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ? AND tbl_name = ?`, index.Name, table.Name).Scan(&count); err != nil || count != 1 {
+				t.Errorf("index %s on %s missing from the database (%v)", index.Name, table.Name, err)
+			}
+		}
+	}
+}
+
+func TestTableInsertStatements(t *testing.T) {
+	want := "INSERT INTO schema_meta (schema_version, generated_at) VALUES (?, ?)"
+	if got := schemaMetaTable.insertStatement("INSERT"); got != want {
+		t.Errorf("insertStatement() = %q, want %q", got, want)
+	}
+
+	// Every column gets a placeholder, in table order
+	for _, table := range sqliteTables {
+		stmt := table.insertStatement("INSERT OR REPLACE")
+		columns := table.columnNames()
+		if n := strings.Count(stmt, "?"); n != len(columns) {
+			t.Errorf("%s: %d placeholders for %d columns in %q", table.name, n, len(columns), stmt)
+		}
+		if !strings.Contains(stmt, "("+strings.Join(columns, ", ")+")") {
+			t.Errorf("%s: %q doesn't list the columns in table order", table.name, stmt)
+		}
+	}
+}
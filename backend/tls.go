@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// tlsConfigFor returns the server TLS configuration for TLS_CERT_FILE and TLS_KEY_FILE,
+// or nil to serve plain HTTP when neither is set. The key pair is loaded up front so a
+// bad path fails at startup rather than on the first connection.
+func tlsConfigFor(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a throwaway certificate and key, returning their paths
+func writeSelfSignedCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("writing certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfigFor(t *testing.T) {
+	if config, err := tlsConfigFor("", ""); config != nil || err != nil {
+		t.Errorf("tlsConfigFor(\"\", \"\") = %v, %v; want plain HTTP", config, err)
+	}
+
+	certFile, keyFile := writeSelfSignedCert(t)
+	config, err := tlsConfigFor(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("tlsConfigFor() error: %v", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 || len(config.Certificates) != 1 {
+		t.Errorf("tlsConfigFor() = MinVersion %x with %d certificates", config.MinVersion, len(config.Certificates))
+	}
+
+	if _, err := tlsConfigFor(certFile, ""); err == nil {
+		t.Error("tlsConfigFor() with only a certificate expected an error")
+	}
+	if _, err := tlsConfigFor(certFile, filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("tlsConfigFor() with a missing key file expected an error")
+	}
+}
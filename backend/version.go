@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build info, set at link time:
+//
+//	go build -ldflags "-X main.gitCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When they're empty, /version falls back to the VCS stamp `go build` embeds when it's
+// run inside a git checkout, with the commit's time standing in for the build time.
+var (
+	gitCommit string
+	buildTime string
+)
+
+// readBuildInfo is debug.ReadBuildInfo; swapped out in tests
+var readBuildInfo = debug.ReadBuildInfo
+
+// versionResponse is the body returned by /version
+type versionResponse struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	Modified  bool   `json:"modified,omitempty"` // built from a checkout with uncommitted changes
+}
+
+// currentVersion describes the running binary, preferring the -ldflags values over the
+// embedded VCS stamp. Anything unknown is reported as "unknown".
+func currentVersion() versionResponse {
+	version := versionResponse{Commit: gitCommit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	if info, ok := readBuildInfo(); ok {
+		if info.GoVersion != "" {
+			version.GoVersion = info.GoVersion
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if version.Commit == "" {
+					version.Commit = setting.Value
+				}
+			case "vcs.time":
+				if version.BuildTime == "" {
+					version.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				version.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if version.Commit == "" {
+		version.Commit = "unknown"
+	}
+	if version.BuildTime == "" {
+		version.BuildTime = "unknown"
+	}
+	return version
+}
+
+// versionHandler reports which build is running. Like /healthz it's unauthenticated.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentVersion())
+}
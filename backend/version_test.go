@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime/debug"
+	"testing"
+)
+
+func withBuildInfo(t *testing.T, commit, built string, info *debug.BuildInfo) {
+	t.Helper()
+	prevCommit, prevBuilt, prevRead := gitCommit, buildTime, readBuildInfo
+	t.Cleanup(func() { gitCommit, buildTime, readBuildInfo = prevCommit, prevBuilt, prevRead })
+	gitCommit, buildTime = commit, built
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return info, info != nil }
+}
+
+func TestVersionPrefersLinkerFlags(t *testing.T) {
+	withBuildInfo(t, "abc123", "2024-06-01T12:00:00Z", &debug.BuildInfo{
+		GoVersion: "go1.22.4",
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "def456"},
+			{Key: "vcs.time", Value: "2024-05-31T09:00:00Z"},
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	versionHandler(rec, httptest.NewRequest("GET", "/version", nil))
+
+	var got versionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	want := versionResponse{Commit: "abc123", BuildTime: "2024-06-01T12:00:00Z", GoVersion: "go1.22.4"}
+	if got != want {
+		t.Errorf("/version = %+v, want %+v", got, want)
+	}
+}
+
+func TestVersionFallsBackToVCSStamp(t *testing.T) {
+	withBuildInfo(t, "", "", &debug.BuildInfo{
+		GoVersion: "go1.22.4",
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "def456"},
+			{Key: "vcs.time", Value: "2024-05-31T09:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	})
+	want := versionResponse{Commit: "def456", BuildTime: "2024-05-31T09:00:00Z", GoVersion: "go1.22.4", Modified: true}
+	if got := currentVersion(); got != want {
+		t.Errorf("currentVersion() = %+v, want %+v", got, want)
+	}
+
+	withBuildInfo(t, "", "", nil)
+	if got := currentVersion(); got.Commit != "unknown" || got.BuildTime != "unknown" || got.GoVersion == "" {
+		t.Errorf("currentVersion() without build info = %+v, want unknown commit and build time", got)
+	}
+}
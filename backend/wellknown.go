@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// robotsTxt is served at /robots.txt. Nothing here is meant for crawlers, so the
+// default turns them all away; ROBOTS_TXT_FILE replaces it.
+var robotsTxt = "User-agent: *\nDisallow: /\n"
+
+// securityContact is the Contact of /.well-known/security.txt (SECURITY_CONTACT), e.g.
+// mailto:security@example.com. Without one the file isn't served.
+var securityContact string
+
+// securityTxtLifetime is how far ahead security.txt's required Expires field is set.
+// RFC 9116 recommends less than a year, so a stale deployment's file eventually lapses.
+const securityTxtLifetime = 180 * 24 * time.Hour
+
+// loadRobotsTxt reads a robots.txt to serve instead of the default
+func loadRobotsTxt(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// checkSecurityContact accepts the URI forms RFC 9116 allows for Contact
+func checkSecurityContact(contact string) error {
+	for _, scheme := range []string{"mailto:", "https://", "tel:"} {
+		if strings.HasPrefix(contact, scheme) && len(contact) > len(scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q should be a mailto:, https:// or tel: URI", contact)
+}
+
+// robotsHandler serves robotsTxt. Like the other public routes it's unauthenticated and
+// never touches the database, so scanners probing for it cost nothing.
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	fmt.Fprint(w, robotsTxt)
+}
+
+// faviconHandler answers browsers' /favicon.ico requests with 204 No Content, so opening
+// an endpoint in a browser doesn't log a 401 for the icon. ServeMux matches the path
+// exactly, so nothing under it escapes the authenticated catch-all.
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// securityTxtHandler serves /.well-known/security.txt with securityContact, or 404 if
+// there's no contact to give
+func securityTxtHandler(w http.ResponseWriter, r *http.Request) {
+	if securityContact == "" {
+		notFoundHandler(w, r)
+		return
+	}
+	expires := time.Now().UTC().Add(securityTxtLifetime).Truncate(24 * time.Hour)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	fmt.Fprintf(w, "Contact: %s\nExpires: %s\n", securityContact, expires.Format(time.RFC3339))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsHandlerDisallowsAllByDefault(t *testing.T) {
+	rec := httptest.NewRecorder()
+	robotsHandler(rec, httptest.NewRequest("GET", "/robots.txt", nil))
+	if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("status = %d, Content-Type %q; want 200 text/plain", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); body != "User-agent: *\nDisallow: /\n" {
+		t.Errorf("/robots.txt = %q, want every crawler disallowed", body)
+	}
+}
+
+func TestFaviconHandlerIsEmpty(t *testing.T) {
+	rec := httptest.NewRecorder()
+	faviconHandler(rec, httptest.NewRequest("GET", "/favicon.ico", nil))
+	if rec.Code != 204 || rec.Body.Len() != 0 {
+		t.Errorf("status = %d with %d bytes, want 204 and no body", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestLoadRobotsTxt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "robots.txt")
+	if err := os.WriteFile(path, []byte("User-agent: *\nAllow: /healthz\n"), 0o600); err != nil {
+		t.Fatalf("writing robots.txt: %v", err)
+	}
+	if got, err := loadRobotsTxt(path); err != nil || got != "User-agent: *\nAllow: /healthz\n" {
+		t.Errorf("loadRobotsTxt() = %q, %v; want the file's contents", got, err)
+	}
+	if _, err := loadRobotsTxt(path + ".missing"); err == nil {
+		t.Error("loadRobotsTxt() of a missing file succeeded")
+	}
+}
+
+func TestSecurityTxtHandler(t *testing.T) {
+	prev := securityContact
+	t.Cleanup(func() { securityContact = prev })
+
+	securityContact = ""
+	rec := httptest.NewRecorder()
+	securityTxtHandler(rec, httptest.NewRequest("GET", "/.well-known/security.txt", nil))
+	if rec.Code != 404 {
+		t.Errorf("status without a contact = %d, want 404", rec.Code)
+	}
+
+	securityContact = "mailto:security@example.com"
+	rec = httptest.NewRecorder()
+	securityTxtHandler(rec, httptest.NewRequest("GET", "/.well-known/security.txt", nil))
+	body := rec.Body.String()
+	if rec.Code != 200 || !strings.Contains(body, "Contact: mailto:security@example.com\n") {
+		t.Fatalf("status = %d, body %q; want the contact", rec.Code, body)
+	}
+	_, value, _ := strings.Cut(body, "Expires: ")
+	expires, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil || !expires.After(time.Now()) || expires.After(time.Now().AddDate(1, 0, 0)) {
+		t.Errorf("Expires = %q (%v), want a time within the next year", value, err)
+	}
+}
+
+func TestCheckSecurityContact(t *testing.T) {
+	for _, contact := range []string{"mailto:security@example.com", "https://example.com/security", "tel:+1-201-555-0123"} {
+		if err := checkSecurityContact(contact); err != nil {
+			t.Errorf("checkSecurityContact(%q) error: %v", contact, err)
+		}
+	}
+	for _, contact := range []string{"security@example.com", "http://example.com", "mailto:"} {
+		if err := checkSecurityContact(contact); err == nil {
+			t.Errorf("checkSecurityContact(%q) accepted it", contact)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDict is the dictionary (ZSTD_DICT_FILE) offered to clients that ask for
+// ?format=zstd-dict and fetch it from /db.dict, or nil without one. The cached .zst file
+// is always compressed without it, so existing clients can keep decompressing downloads.
+var zstdDict []byte
+
+// zstdDictID is the ID of zstdDict, sent with /db.dict and dictionary-compressed downloads
+// so clients can tell which dictionary they need. It's 0 without a dictionary.
+var zstdDictID uint32
+
+// dictZstdFiles holds the .dict.zst files built from the full database and from subsets,
+// compressed with zstdDict, for ?format=zstd-dict
+var dictZstdFiles = newDerivedCache("zstd dictionary", func(log *Logger, source string) (string, error) {
+	return buildDictZstdFile(log, source)
+})
+
+// loadZstdDict reads and checks a zstd dictionary, returning it with its ID
+func loadZstdDict(path string) ([]byte, uint32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	d, err := zstd.InspectDictionary(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s is not a zstd dictionary: %w", path, err)
+	}
+	return data, d.ID(), nil
+}
+
+// zstdEncoderOptions returns the options compressWithZstd encodes the database with
+func zstdEncoderOptions() []zstd.EOption {
+	return []zstd.EOption{zstd.WithEncoderLevel(zstdLevel)}
+}
+
+// newZstdReader returns a decoder for a zstd database file, which also knows zstdDict so
+// dictionary-compressed downloads passed to train can be read back
+func newZstdReader(r io.Reader) (*zstd.Decoder, error) {
+	if zstdDict == nil {
+		return zstd.NewReader(r)
+	}
+	return zstd.NewReader(r, zstd.WithDecoderDicts(zstdDict))
+}
+
+// buildDictZstdFile decompresses the zstd database at source and compresses it again
+// with zstdDict next to it, as .dict.zst, returning that path
+func buildDictZstdFile(log *Logger, source string) (string, error) {
+	start := time.Now()
+	path, err := buildDerivedFile(source, ".dict.zst", func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel), zstd.WithEncoderDict(zstdDict))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		log.Info("Built zstd dictionary copy of the database: %.2f MB (dictionary %d) in %s",
+			float64(info.Size())/(1024*1024), zstdDictID, time.Since(start))
+	}
+	return path, nil
+}
+
+// dbDictHandler serves the zstd dictionary ?format=zstd-dict downloads are compressed
+// with, and its ID in X-Zstd-Dictionary-ID. It's 404 when none is configured.
+func dbDictHandler(w http.ResponseWriter, r *http.Request) {
+	if zstdDict == nil {
+		notFoundHandler(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="database.dict"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(zstdDict)))
+	w.Header().Set("X-Zstd-Dictionary-ID", strconv.FormatUint(uint64(zstdDictID), 10))
+	w.Write(zstdDict)
+}
+
+// trainOptions are the flags of the train subcommand
+type trainOptions struct {
+	out     string
+	size    int
+	samples []string
+}
+
+// defaultZstdDictSize is the default -size of a trained dictionary, as in zstd's own trainer
+const defaultZstdDictSize = 112 << 10
+
+// parseTrainArgs parses the arguments after `train`, e.g. `train -out db.dict a.db b.db`
+func parseTrainArgs(args []string) (trainOptions, error) {
+	var opts trainOptions
+	flags := flag.NewFlagSet("train", flag.ContinueOnError)
+	flags.StringVar(&opts.out, "out", "", "write the dictionary to this path (required)")
+	flags.IntVar(&opts.size, "size", defaultZstdDictSize, "maximum dictionary size in bytes")
+	if err := flags.Parse(args); err != nil {
+		return trainOptions{}, err
+	}
+	if opts.out == "" {
+		return trainOptions{}, fmt.Errorf("train: -out is required")
+	}
+	if opts.size < 1<<10 {
+		return trainOptions{}, fmt.Errorf("train: -size must be at least 1024 bytes")
+	}
+	opts.samples = flags.Args()
+	if len(opts.samples) == 0 {
+		return trainOptions{}, fmt.Errorf("train: pass one or more generated databases (.db, or .zst to decompress) to sample")
+	}
+	return opts, nil
+}
+
+// readSampleDatabase returns the SQLite database at path, decompressing it first if the
+// name ends in .zst
+func readSampleDatabase(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(path, ".zst") {
+		return io.ReadAll(file)
+	}
+	decoder, err := newZstdReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd decoder: %w", err)
+	}
+	defer decoder.Close()
+	return io.ReadAll(decoder)
+}
+
+// sqlitePages splits a database into its pages, the unit its structure repeats in. The
+// page size is read from the header; anything that isn't a SQLite file is one sample.
+func sqlitePages(db []byte) [][]byte {
+	if len(db) < 100 || !bytes.HasPrefix(db, []byte("SQLite format 3\x00")) {
+		return [][]byte{db}
+	}
+	pageSize := int(binary.BigEndian.Uint16(db[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	var pages [][]byte
+	for start := 0; start < len(db); start += pageSize {
+		pages = append(pages, db[start:min(start+pageSize, len(db))])
+	}
+	return pages
+}
+
+// zstdSizeWith returns the size of data compressed at zstdLevel, with the options given
+func zstdSizeWith(data []byte, options ...zstd.EOption) (int, error) {
+	encoder, err := zstd.NewWriter(nil, append([]zstd.EOption{zstd.WithEncoderLevel(zstdLevel)}, options...)...)
+	if err != nil {
+		return 0, err
+	}
+	defer encoder.Close()
+	return len(encoder.EncodeAll(data, nil)), nil
+}
+
+// trainResult reports how a trained dictionary compresses one of its samples
+type trainResult struct {
+	path        string
+	size        int
+	withoutDict int
+	withDict    int
+}
+
+// trainZstdDict builds a dictionary from the pages of the sample databases, writes it to
+// opts.out, and measures each sample compressed at zstdLevel with and without it
+func trainZstdDict(opts trainOptions) ([]trainResult, error) {
+	databases := make([][]byte, len(opts.samples))
+	var pages [][]byte
+	for i, path := range opts.samples {
+		db, err := readSampleDatabase(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading sample %s: %w", path, err)
+		}
+		databases[i] = db
+		pages = append(pages, sqlitePages(db)...)
+	}
+
+	trained, err := dict.BuildZstdDict(pages, dict.Options{
+		MaxDictSize: opts.size,
+		HashBytes:   6,
+		// Keep the dictionary readable by the zstd CLI that clients decompress with
+		ZstdDictCompat: true,
+		ZstdLevel:      zstdLevel,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("building dictionary: %w", err)
+	}
+	if err := os.WriteFile(opts.out, trained, 0o644); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", opts.out, err)
+	}
+
+	results := make([]trainResult, len(databases))
+	for i, db := range databases {
+		without, err := zstdSizeWith(db)
+		if err != nil {
+			return nil, err
+		}
+		with, err := zstdSizeWith(db, zstd.WithEncoderDict(trained))
+		if err != nil {
+			return nil, err
+		}
+		results[i] = trainResult{path: opts.samples[i], size: len(db), withoutDict: without, withDict: with}
+	}
+	return results, nil
+}
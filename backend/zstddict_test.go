@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// withZstdDict offers dictionary for ?format=zstd-dict for the duration of the test
+func withZstdDict(t *testing.T, dictionary []byte, id uint32) {
+	t.Helper()
+	oldDict, oldID := zstdDict, zstdDictID
+	zstdDict, zstdDictID = dictionary, id
+	t.Cleanup(func() { zstdDict, zstdDictID = oldDict, oldID })
+}
+
+// writeTrainingSamples writes n small generated databases with varying rows
+func writeTrainingSamples(t *testing.T, n int) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < n; i++ {
+		var statements []string
+		for j := 0; j < 200; j++ {
+			statements = append(statements, fmt.Sprintf(
+				`INSERT INTO approved_projects (record_id, git_hub_username, code_url, ysws_name) VALUES ('rec%d-%d', 'user%d', 'https://github.com/user%d/project', 'Arcade')`,
+				i, j, i*1000+j, i*1000+j))
+		}
+		path := filepath.Join(dir, fmt.Sprintf("sample-%d.db", i))
+		if err := os.WriteFile(path, buildTestDatabase(t, statements...), 0o600); err != nil {
+			t.Fatalf("writing sample: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestParseTrainArgs(t *testing.T) {
+	opts, err := parseTrainArgs([]string{"-out", "db.dict", "a.db", "b.db.zst"})
+	if err != nil || opts.out != "db.dict" || opts.size != defaultZstdDictSize || strings.Join(opts.samples, " ") != "a.db b.db.zst" {
+		t.Errorf("parseTrainArgs() = %+v, %v; want db.dict from two samples", opts, err)
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"a.db"},
+		{"-out", "db.dict"},
+		{"-out", "db.dict", "-size", "10", "a.db"},
+		{"-unknown"},
+	} {
+		if _, err := parseTrainArgs(args); err == nil {
+			t.Errorf("parseTrainArgs(%q) succeeded, want an error", args)
+		}
+	}
+}
+
+func TestSQLitePages(t *testing.T) {
+	db := buildTestDatabase(t)
+	pages := sqlitePages(db)
+	if len(pages) < 2 || len(pages[0]) != 4096 || len(pages)*4096 != len(db) {
+		t.Errorf("sqlitePages() split %d bytes into %d pages, want 4096-byte pages", len(db), len(pages))
+	}
+	if pages := sqlitePages([]byte("not a database")); len(pages) != 1 {
+		t.Errorf("sqlitePages() split a non-SQLite file into %d samples, want 1", len(pages))
+	}
+}
+
+func TestTrainedDictionaryCompressesCache(t *testing.T) {
+	samples := writeTrainingSamples(t, 3)
+	out := filepath.Join(t.TempDir(), "db.dict")
+	results, err := trainZstdDict(trainOptions{out: out, size: 16 << 10, samples: samples})
+	if err != nil {
+		t.Fatalf("trainZstdDict() error: %v", err)
+	}
+	if len(results) != len(samples) {
+		t.Fatalf("trainZstdDict() measured %d samples, want %d", len(results), len(samples))
+	}
+	for _, r := range results {
+		if r.withDict <= 0 || r.withoutDict <= 0 || r.withDict > r.withoutDict {
+			t.Errorf("%s: %d bytes with the dictionary, %d without; want it no larger", r.path, r.withDict, r.withoutDict)
+		}
+	}
+
+	dictionary, id, err := loadZstdDict(out)
+	if err != nil || id == 0 {
+		t.Fatalf("loadZstdDict() = id %d, %v; want the trained dictionary", id, err)
+	}
+	withZstdDict(t, dictionary, id)
+
+	// The cached file stays readable by clients that don't have the dictionary
+	compressed, err := compressWithZstd(samples[0])
+	if err != nil {
+		t.Fatalf("compressWithZstd() error: %v", err)
+	}
+	info, _ := os.Stat(samples[0])
+	if _, err := zstdDecodeWithout(t, compressed); err != nil {
+		t.Errorf("cached file can't be decompressed without the dictionary: %v", err)
+	}
+
+	// Its dictionary copy can only be read with the dictionary
+	dictPath, err := buildDictZstdFile(appLog, compressed)
+	if err != nil {
+		t.Fatalf("buildDictZstdFile() error: %v", err)
+	}
+	if err := verifyZstd(dictPath, info.Size()); err != nil {
+		t.Errorf("verifyZstd() with the dictionary: %v", err)
+	}
+	if _, err := zstdDecodeWithout(t, dictPath); err == nil {
+		t.Error("the dictionary copy was decompressed without the dictionary")
+	}
+}
+
+// zstdDecodeWithout decompresses the file at path without any dictionary
+func zstdDecodeWithout(t *testing.T, path string) ([]byte, error) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening %s: %v", path, err)
+	}
+	defer file.Close()
+	decoder, err := zstd.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	return io.ReadAll(decoder)
+}
+
+func TestDBHandlerServesDictionaryCompressionOnlyWhenAskedFor(t *testing.T) {
+	prev := dictZstdFiles
+	dictZstdFiles = newDerivedCache("zstd dictionary", buildDictZstdFile)
+	t.Cleanup(func() { dictZstdFiles = prev })
+	samples := writeTrainingSamples(t, 2)
+	out := filepath.Join(t.TempDir(), "db.dict")
+	if _, err := trainZstdDict(trainOptions{out: out, size: 16 << 10, samples: samples}); err != nil {
+		t.Fatalf("trainZstdDict() error: %v", err)
+	}
+	dictionary, id, err := loadZstdDict(out)
+	if err != nil {
+		t.Fatalf("loadZstdDict() error: %v", err)
+	}
+	withZstdDict(t, dictionary, id)
+	contents, _ := os.ReadFile(samples[0])
+	withCachedDatabase(t, contents)
+
+	// Plain zstd clients, like the frontend, don't get the dictionary copy
+	rec := httptest.NewRecorder()
+	dbHandler(testConfig)(rec, httptest.NewRequest("GET", "/db?format=zstd", nil))
+	if rec.Code != 200 || rec.Header().Get("X-Zstd-Dictionary-ID") != "" {
+		t.Fatalf("format=zstd: status %d, X-Zstd-Dictionary-ID %q; want 200 without one", rec.Code, rec.Header().Get("X-Zstd-Dictionary-ID"))
+	}
+	decoder, _ := zstd.NewReader(rec.Body)
+	plain, err := io.ReadAll(decoder)
+	decoder.Close()
+	if err != nil || !bytes.Equal(plain, contents) {
+		t.Errorf("format=zstd decoded %d bytes (%v) without the dictionary, want the database", len(plain), err)
+	}
+
+	// The dictionary and the ID to match it against
+	rec = httptest.NewRecorder()
+	dbDictHandler(rec, httptest.NewRequest("GET", "/db.dict", nil))
+	wantID := strconv.FormatUint(uint64(id), 10)
+	if rec.Code != 200 || !bytes.Equal(rec.Body.Bytes(), dictionary) || rec.Header().Get("X-Zstd-Dictionary-ID") != wantID {
+		t.Fatalf("/db.dict: status %d, %d bytes, ID %q; want the dictionary with ID %s", rec.Code, rec.Body.Len(), rec.Header().Get("X-Zstd-Dictionary-ID"), wantID)
+	}
+	served := rec.Body.Bytes()
+
+	rec = httptest.NewRecorder()
+	dbHandler(testConfig)(rec, httptest.NewRequest("GET", "/db?format=zstd-dict", nil))
+	if rec.Code != 200 || rec.Header().Get("X-Zstd-Dictionary-ID") != wantID {
+		t.Fatalf("format=zstd-dict: status %d, X-Zstd-Dictionary-ID %q; want 200 with %s", rec.Code, rec.Header().Get("X-Zstd-Dictionary-ID"), wantID)
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("Content-Length = %q, want %d", got, rec.Body.Len())
+	}
+	decoder, _ = zstd.NewReader(bytes.NewReader(rec.Body.Bytes()), zstd.WithDecoderDicts(served))
+	decoded, err := io.ReadAll(decoder)
+	decoder.Close()
+	if err != nil || !bytes.Equal(decoded, contents) {
+		t.Errorf("format=zstd-dict decoded %d bytes (%v) with /db.dict, want the database", len(decoded), err)
+	}
+}
+
+func TestDictionaryFormatNeedsADictionary(t *testing.T) {
+	withZstdDict(t, nil, 0)
+
+	rec := httptest.NewRecorder()
+	dbHandler(testConfig)(rec, httptest.NewRequest("GET", "/db?format=zstd-dict", nil))
+	decodeErrorResponse(t, rec, 400)
+
+	rec = httptest.NewRecorder()
+	dbDictHandler(rec, httptest.NewRequest("GET", "/db.dict", nil))
+	decodeErrorResponse(t, rec, 404)
+}
+
+func TestLoadZstdDictRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.dict")
+	if err := os.WriteFile(path, []byte("not a dictionary"), 0o600); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+	if _, _, err := loadZstdDict(path); err == nil {
+		t.Error("loadZstdDict() accepted a file that isn't a dictionary")
+	}
+}
+
+func TestLoadCacheMetadataRejectsDictionaryCompressedFiles(t *testing.T) {
+	dir := t.TempDir()
+	entry := writeCacheFile(t, dir, time.Now())
+	if err := saveCacheMetadata(dir, entry); err != nil {
+		t.Fatalf("saveCacheMetadata() error: %v", err)
+	}
+
+	if _, err := loadCacheMetadata(testConfig, dir, 5*time.Minute); err != nil {
+		t.Fatalf("loadCacheMetadata() error: %v", err)
+	}
+
+	// Older versions compressed the cached file itself with the dictionary, which clients
+	// without it can't read
+	data, _ := os.ReadFile(filepath.Join(dir, cacheMetadataFile))
+	data = bytes.Replace(data, []byte(`"schema_hash"`), []byte(`"zstd_dict_id":42,"schema_hash"`), 1)
+	if err := os.WriteFile(filepath.Join(dir, cacheMetadataFile), data, 0o600); err != nil {
+		t.Fatalf("writing sidecar: %v", err)
+	}
+	if _, err := loadCacheMetadata(testConfig, dir, 5*time.Minute); err == nil {
+		t.Error("loadCacheMetadata() adopted a file compressed with a dictionary")
+	}
+}
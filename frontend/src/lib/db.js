@@ -254,7 +254,8 @@ export async function loadDatabase(apiKey) {
 	try {
 		// Fetch compressed database from backend
 		console.log('Fetching database from backend...');
-		const response = await fetch(`${BACKEND_URL}/db`, {
+		// Ask for zstd explicitly so the browser's Accept-Encoding can't switch the format
+		const response = await fetch(`${BACKEND_URL}/db?format=zstd`, {
 			headers: {
 				'X-API-Key': key
 			}